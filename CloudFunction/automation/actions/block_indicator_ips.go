@@ -0,0 +1,122 @@
+/*
+Package actions provides the implementation of automated actions.
+
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+        https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package actions
+
+import (
+        "automation/clients"
+        "automation/dedup"
+        "automation/finding"
+        "automation/firewall"
+        "automation/host"
+
+        "context"
+        "fmt"
+        "log"
+        "net"
+        "path"
+
+        "cloud.google.com/go/pubsub"
+)
+
+// blockIPsAction is the name findings are recorded under once handled.
+const blockIPsAction = "block-indicator-ips"
+
+// BlockIndicatorIPsOptions are the settings of BlockIndicatorIPs.
+type BlockIndicatorIPsOptions struct {
+        // SupportedRules are the rules of the findings bad IPs are blocked for.
+        SupportedRules []string
+        // Threshold is the minimum severity and priority of a finding before its bad IPs are blocked.
+        Threshold finding.Threshold
+        // Dedup skips findings already handled within its window, nil to never skip them.
+        Dedup *dedup.Deduplicator
+}
+
+// BlockIndicatorIPs denies egress to the bad IPs of a finding on the networks of the
+// instances it affects.
+//
+// The IPs are merged into each network's "block-indicators-<network>" egress deny rules,
+// created in the network's project so Shared VPC networks are blocked in their host project.
+// The rules are sharded per address family and once a rule holds
+// firewall.MaxDestinationRanges ranges, and are described with the insert IDs of every
+// finding that added to them. Findings of rules that aren't supported, below the minimum
+// severity and priority or already handled within the deduplication window are ignored, as
+// are IPs that don't parse.
+func BlockIndicatorIPs(ctx context.Context, m pubsub.Message, c clients.ClientInt, o BlockIndicatorIPsOptions) error {
+        f := finding.NewFinding()
+        if err := f.ReadFinding(&m); err != nil {
+                return fmt.Errorf("failed to read finding: %q", err)
+        }
+        return blockIndicatorIPs(ctx, c, f, o)
+}
+
+// BlockIndicatorIPsHandler returns a Handler blocking the finding's bad IPs with the settings.
+func BlockIndicatorIPsHandler(o BlockIndicatorIPsOptions) Handler {
+        return func(ctx context.Context, c clients.ClientInt, f *finding.Finding) error {
+                return blockIndicatorIPs(ctx, c, f, o)
+        }
+}
+
+// blockIndicatorIPs responds to the parsed finding, see BlockIndicatorIPs.
+func blockIndicatorIPs(ctx context.Context, c clients.ClientInt, f *finding.Finding, o BlockIndicatorIPsOptions) error {
+        if !contains(o.SupportedRules, f.RuleName()) {
+                return nil
+        }
+
+        if ok, err := guard(blockIPsAction, f, o.Threshold, o.Dedup); !ok {
+                return err
+        }
+
+        ips := []string{}
+        for _, ip := range f.BadIPs() {
+                if net.ParseIP(ip) == nil {
+                        log.Printf("skipping invalid IP %q of %s finding %q", ip, f.RuleName(), f.InsertID())
+                        continue
+                }
+                ips = append(ips, ip)
+        }
+        if len(ips) == 0 {
+                return nil
+        }
+
+        h := host.NewHost(c)
+        fw := firewall.NewFirewall(c)
+        blocked := map[string]bool{}
+        for _, i := range affectedInstances(f) {
+                networks, err := h.InstanceNetworks(i.projectID, i.zone, i.name)
+                if err != nil {
+                        return err
+                }
+                for _, network := range networks {
+                        if blocked[network] {
+                                continue
+                        }
+                        blocked[network] = true
+                        // Rules of a Shared VPC network are created in its host project.
+                        projectID := networkProject(network, i.projectID)
+                        changed, err := fw.BlockDestinations(projectID, network, "block-indicators-"+path.Base(network), ips, f.InsertID())
+                        if err != nil {
+                                return fmt.Errorf("failed to block indicator IPs: %q", err)
+                        }
+                        if len(changed) > 0 {
+                                log.Printf("blocked indicator IPs %q in project %q with rules %q", ips, projectID, changed)
+                        }
+                }
+        }
+
+        return record(blockIPsAction, f, o.Dedup)
+}
\ No newline at end of file
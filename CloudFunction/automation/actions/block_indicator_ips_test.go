@@ -0,0 +1,199 @@
+/*
+Package actions provides the implementation of automated actions.
+
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+        https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package actions
+
+import (
+        "automation/clients"
+        "context"
+        "reflect"
+        "testing"
+
+        "cloud.google.com/go/pubsub"
+        cs "google.golang.org/api/compute/v1"
+)
+
+// defaultNetwork is the URL of the network the test instance is attached to.
+const defaultNetwork = "https://www.googleapis.com/compute/v1/projects/test-project/global/networks/default"
+
+func TestBlockIndicatorIPs(t *testing.T) {
+        ctx := context.Background()
+        tests := []struct {
+                name           string
+                ruleName       string
+                ips            string
+                network        string
+                existing       *cs.Firewall
+                expectedRanges map[string][]string
+                // expectedProjects maps each rule created to the project it's created in.
+                expectedProjects map[string]string
+                // expectedFindings are the findings the description of each rule changed lists.
+                expectedFindings string
+        }{
+                {
+                        name:             "creates rule",
+                        ruleName:         "bad_ip",
+                        ips:              `"8.8.8.8", "not-an-ip"`,
+                        network:          defaultNetwork,
+                        expectedRanges:   map[string][]string{"block-indicators-default-v4-0": {"8.8.8.8"}},
+                        expectedProjects: map[string]string{"block-indicators-default-v4-0": "test-project"},
+                        expectedFindings: "eppsoda4",
+                },
+                {
+                        name:     "creates rule per address family",
+                        ruleName: "bad_ip",
+                        ips:      `"8.8.8.8", "2001:4860:4860::8888"`,
+                        network:  defaultNetwork,
+                        expectedRanges: map[string][]string{
+                                "block-indicators-default-v4-0": {"8.8.8.8"},
+                                "block-indicators-default-v6-0": {"2001:4860:4860::8888"},
+                        },
+                        expectedProjects: map[string]string{
+                                "block-indicators-default-v4-0": "test-project",
+                                "block-indicators-default-v6-0": "test-project",
+                        },
+                        expectedFindings: "eppsoda4",
+                },
+                {
+                        name:             "creates rule in shared vpc host project",
+                        ruleName:         "bad_ip",
+                        ips:              `"8.8.8.8"`,
+                        network:          "https://www.googleapis.com/compute/v1/projects/host-project/global/networks/shared",
+                        expectedRanges:   map[string][]string{"block-indicators-shared-v4-0": {"8.8.8.8"}},
+                        expectedProjects: map[string]string{"block-indicators-shared-v4-0": "host-project"},
+                        expectedFindings: "eppsoda4",
+                },
+                {
+                        name:             "merges into existing rule",
+                        ruleName:         "cryptomining",
+                        ips:              `"8.8.8.8", "8.8.4.4"`,
+                        network:          defaultNetwork,
+                        existing:         &cs.Firewall{Name: "block-indicators-default-v4-0", DestinationRanges: []string{"8.8.8.8"}},
+                        expectedRanges:   map[string][]string{"block-indicators-default-v4-0": {"8.8.8.8", "8.8.4.4"}},
+                        expectedFindings: "eppsoda4",
+                },
+                {
+                        name:     "keeps findings of existing rule",
+                        ruleName: "bad_ip",
+                        ips:      `"8.8.4.4"`,
+                        network:  defaultNetwork,
+                        existing: &cs.Firewall{
+                                Name:              "block-indicators-default-v4-0",
+                                Description:       "Denies egress to destinations blocked for: abc123",
+                                DestinationRanges: []string{"8.8.8.8"},
+                        },
+                        expectedRanges:   map[string][]string{"block-indicators-default-v4-0": {"8.8.8.8", "8.8.4.4"}},
+                        expectedFindings: "abc123, eppsoda4",
+                },
+                {
+                        name:           "ignores other rules",
+                        ruleName:       "ssh_brute_force",
+                        ips:            `"8.8.8.8"`,
+                        network:        defaultNetwork,
+                        expectedRanges: map[string][]string{},
+                },
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        m := pubsub.Message{Data: []byte(`{
+                                "insertId": "eppsoda4",
+                                "jsonPayload": {"detectionCategory": {"ruleName": "` + tt.ruleName + `"},
+                                "properties": {
+                                        "project_id": "test-project",
+                                        "location": "test-zone",
+                                        "sourceInstance": "/projects/test-project/zones/test-zone/instances/instance1",
+                                        "ip": [` + tt.ips + `]
+                                }
+                        }, "logName": "projects/test-project/logs/threatdetection.googleapis.com%2Fdetection"}`)}
+                        mock := clients.NewMockClients()
+                        mock.AddGetInstanceFake(&cs.Instance{
+                                Name:              "instance1",
+                                NetworkInterfaces: []*cs.NetworkInterface{{Network: tt.network}},
+                        })
+                        if tt.existing != nil {
+                                mock.AddFirewallRuleFake(tt.existing)
+                        }
+
+                        if err := BlockIndicatorIPs(ctx, m, mock, BlockIndicatorIPsOptions{SupportedRules: []string{"bad_ip", "cryptomining"}}); err != nil {
+                                t.Fatalf("%s failed: %q", tt.name, err)
+                        }
+                        got := map[string][]string{}
+                        for name, rb := range mock.SavedFirewallRules {
+                                got[name] = rb.DestinationRanges
+                        }
+                        for _, rb := range mock.SavedInsertedFirewalls {
+                                got[rb.Name] = rb.DestinationRanges
+                        }
+                        if !reflect.DeepEqual(got, tt.expectedRanges) {
+                                t.Errorf("%s failed got:%q want:%q", tt.name, got, tt.expectedRanges)
+                        }
+                        if len(mock.SavedInsertedProjects) > 0 || len(tt.expectedProjects) > 0 {
+                                if !reflect.DeepEqual(mock.SavedInsertedProjects, tt.expectedProjects) {
+                                        t.Errorf("%s failed projects got:%q want:%q", tt.name, mock.SavedInsertedProjects, tt.expectedProjects)
+                                }
+                        }
+                        rules := mock.SavedInsertedFirewalls
+                        for _, rb := range mock.SavedFirewallRules {
+                                rules = append(rules, rb)
+                        }
+                        for _, rb := range rules {
+                                if exp := "Denies egress to destinations blocked for: " + tt.expectedFindings; rb.Description != exp {
+                                        t.Errorf("%s failed description got:%q want:%q", tt.name, rb.Description, exp)
+                                }
+                        }
+                })
+        }
+}
+
+func TestBlockIndicatorIPsDryRun(t *testing.T) {
+        ctx := context.Background()
+        mock := clients.NewMockClients()
+        mock.AddGetInstanceFake(&cs.Instance{
+                Name:              "instance1",
+                NetworkInterfaces: []*cs.NetworkInterface{{Network: defaultNetwork}},
+        })
+        mock.AddFirewallRuleFake(&cs.Firewall{Name: "block-indicators-default-v4-0", DestinationRanges: []string{"8.8.8.8"}})
+        dry := clients.NewDryRun(mock)
+        m := pubsub.Message{Data: []byte(`{
+                "insertId": "eppsoda4",
+                "jsonPayload": {"detectionCategory": {"ruleName": "bad_ip"},
+                "properties": {
+                        "project_id": "test-project",
+                        "location": "test-zone",
+                        "sourceInstance": "/projects/test-project/zones/test-zone/instances/instance1",
+                        "ip": ["8.8.4.4"]
+                }
+        }, "logName": "projects/test-project/logs/threatdetection.googleapis.com%2Fdetection"}`)}
+
+        if err := BlockIndicatorIPs(ctx, m, dry, BlockIndicatorIPsOptions{SupportedRules: []string{"bad_ip"}}); err != nil {
+                t.Fatalf("failed to plan blocking: %q", err)
+        }
+        if len(mock.SavedFirewallRules) != 0 || len(mock.SavedInsertedFirewalls) != 0 {
+                t.Errorf("failed dry run changed rules: %v %v", mock.SavedFirewallRules, mock.SavedInsertedFirewalls)
+        }
+        var ops []string
+        for _, c := range dry.Plan() {
+                ops = append(ops, c.Operation+" "+c.Resource)
+        }
+        exp := []string{"patch //compute.googleapis.com/projects/test-project/global/firewalls/block-indicators-default-v4-0"}
+        if !reflect.DeepEqual(ops, exp) {
+                t.Fatalf("failed plan got:%q want:%q", ops, exp)
+        }
+        if before, ok := dry.Plan()[0].Before.(*cs.Firewall); !ok || !reflect.DeepEqual(before.DestinationRanges, []string{"8.8.8.8"}) {
+                t.Errorf("failed plan before got:%v want:%q", dry.Plan()[0].Before, []string{"8.8.8.8"})
+        }
+}
\ No newline at end of file
@@ -0,0 +1,91 @@
+/*
+Package actions provides the implementation of automated actions.
+
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+        https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package actions
+
+import (
+        "automation/clients"
+        "automation/dedup"
+        "automation/finding"
+        "automation/firewall"
+
+        "context"
+        "fmt"
+        "log"
+
+        "cloud.google.com/go/pubsub"
+)
+
+// closeFirewallAction is the name findings are recorded under once handled.
+const closeFirewallAction = "close-open-firewall"
+
+// CloseOpenFirewallOptions are the settings of CloseOpenFirewall.
+type CloseOpenFirewallOptions struct {
+        // SupportedRules are the Security Health Analytics categories firewall rules are disabled for.
+        SupportedRules []string
+        // Threshold is the minimum severity and priority of a finding before its rules are disabled.
+        Threshold finding.Threshold
+        // Dedup skips findings already handled within its window, nil to never skip them.
+        Dedup *dedup.Deduplicator
+}
+
+// CloseOpenFirewall disables the firewall rules Security Health Analytics reports as open.
+//
+// Findings of categories that aren't supported are ignored, as are findings below the
+// minimum severity and priority or already handled within the deduplication window.
+func CloseOpenFirewall(ctx context.Context, m pubsub.Message, c clients.ClientInt, o CloseOpenFirewallOptions) error {
+        f := finding.NewFinding()
+        if err := f.ReadFinding(&m); err != nil {
+                return fmt.Errorf("failed to read finding: %q", err)
+        }
+        return closeOpenFirewall(ctx, c, f, o)
+}
+
+// CloseOpenFirewallHandler returns a Handler disabling the open firewall rules with the settings.
+func CloseOpenFirewallHandler(o CloseOpenFirewallOptions) Handler {
+        return func(ctx context.Context, c clients.ClientInt, f *finding.Finding) error {
+                return closeOpenFirewall(ctx, c, f, o)
+        }
+}
+
+// closeOpenFirewall responds to the parsed finding, see CloseOpenFirewall.
+func closeOpenFirewall(ctx context.Context, c clients.ClientInt, f *finding.Finding, o CloseOpenFirewallOptions) error {
+        if !contains(o.SupportedRules, f.RuleName()) {
+                return nil
+        }
+
+        if ok, err := guard(closeFirewallAction, f, o.Threshold, o.Dedup); !ok {
+                return err
+        }
+
+        fw := firewall.NewFirewall(c)
+        for _, r := range f.AffectedResources() {
+                if r.Type != "firewalls" {
+                        continue
+                }
+                projectID := r.Project
+                if projectID == "" {
+                        projectID = f.ProjectID()
+                }
+                if _, err := fw.DisableFirewallRule(projectID, r.Name); err != nil {
+                        return fmt.Errorf("failed to close firewall rule %q: %q", r.Name, err)
+                }
+                log.Printf("disabled open firewall rule %q in project %q", r.Name, projectID)
+        }
+
+        return record(closeFirewallAction, f, o.Dedup)
+}
\ No newline at end of file
@@ -0,0 +1,82 @@
+/*
+Package actions provides the implementation of automated actions.
+
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+        https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package actions
+
+import (
+        "automation/clients"
+        "automation/finding"
+        "context"
+        "reflect"
+        "testing"
+
+        "cloud.google.com/go/pubsub"
+)
+
+func TestCloseOpenFirewall(t *testing.T) {
+        ctx := context.Background()
+        test := []struct {
+                name     string
+                message  pubsub.Message
+                disabled []string
+        }{
+                {
+                        name:     "disable open firewall",
+                        message:  createHealthMessage(finding.CategoryOpenFirewall, "//compute.googleapis.com/projects/test-project/global/firewalls/default-allow-ssh"),
+                        disabled: []string{"default-allow-ssh"},
+                },
+                {
+                        name:     "ignores other categories",
+                        message:  createHealthMessage(finding.CategoryPublicIPAddress, "//compute.googleapis.com/projects/test-project/zones/us-central1-a/instances/instance-1"),
+                        disabled: []string{},
+                },
+        }
+        for _, tt := range test {
+                t.Run(tt.name, func(t *testing.T) {
+                        mock := clients.NewMockClients()
+                        if err := CloseOpenFirewall(ctx, tt.message, mock, CloseOpenFirewallOptions{SupportedRules: []string{finding.CategoryOpenFirewall}}); err != nil {
+                                t.Fatalf("%s failed: %q", tt.name, err)
+                        }
+                        got := []string{}
+                        for name, rb := range mock.SavedFirewallRules {
+                                if !rb.Disabled {
+                                        t.Errorf("%s failed rule %q not disabled", tt.name, name)
+                                }
+                                got = append(got, name)
+                        }
+                        if !reflect.DeepEqual(got, tt.disabled) {
+                                t.Errorf("%s failed got:%q want:%q", tt.name, got, tt.disabled)
+                        }
+                })
+        }
+}
+
+func createHealthMessage(category string, resourceName string) pubsub.Message {
+        return pubsub.Message{Data: []byte(`{
+                "notificationConfigName": "organizations/154584661726/notificationConfigs/health-findings",
+                "finding": {
+                        "name": "organizations/154584661726/sources/1986930501971458034/findings/f2",
+                        "resourceName": "` + resourceName + `",
+                        "state": "ACTIVE",
+                        "category": "` + category + `",
+                        "sourceProperties": {
+                                "ProjectId": "test-project",
+                                "SeverityLevel": "High"
+                        }
+                }
+        }`)}
+}
\ No newline at end of file
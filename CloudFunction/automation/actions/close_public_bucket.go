@@ -0,0 +1,113 @@
+/*
+Package actions provides the implementation of automated actions.
+
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+        https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package actions
+
+import (
+        "automation/clients"
+        "automation/dedup"
+        "automation/finding"
+        "automation/user"
+
+        "context"
+        "fmt"
+        "log"
+
+        "cloud.google.com/go/pubsub"
+        stg "cloud.google.com/go/storage"
+)
+
+// closeBucketAction is the name findings are recorded under once handled.
+const closeBucketAction = "close-public-bucket"
+
+// publicEntities contains the ACL entities that make a bucket public.
+var publicEntities = map[stg.ACLEntity]bool{
+        stg.AllUsers:              true,
+        stg.AllAuthenticatedUsers: true,
+}
+
+// ClosePublicBucketOptions are the settings of ClosePublicBucket.
+type ClosePublicBucketOptions struct {
+        // SupportedRules are the Security Health Analytics categories public access is removed for.
+        SupportedRules []string
+        // Threshold is the minimum severity and priority of a finding before public access is removed.
+        Threshold finding.Threshold
+        // Dedup skips findings already handled within its window, nil to never skip them.
+        Dedup *dedup.Deduplicator
+}
+
+// ClosePublicBucket removes public access from the buckets Security Health Analytics reports.
+//
+// Only the allUsers and allAuthenticatedUsers entities are removed from the bucket's ACL,
+// other entries are left as they are. Findings of categories that aren't supported are
+// ignored, as are findings below the minimum severity and priority or already handled within
+// the deduplication window.
+func ClosePublicBucket(ctx context.Context, m pubsub.Message, c clients.ClientInt, o ClosePublicBucketOptions) error {
+        f := finding.NewFinding()
+        if err := f.ReadFinding(&m); err != nil {
+                return fmt.Errorf("failed to read finding: %q", err)
+        }
+        return closePublicBucket(ctx, c, f, o)
+}
+
+// ClosePublicBucketHandler returns a Handler removing public access from buckets with the settings.
+func ClosePublicBucketHandler(o ClosePublicBucketOptions) Handler {
+        return func(ctx context.Context, c clients.ClientInt, f *finding.Finding) error {
+                return closePublicBucket(ctx, c, f, o)
+        }
+}
+
+// closePublicBucket responds to the parsed finding, see ClosePublicBucket.
+func closePublicBucket(ctx context.Context, c clients.ClientInt, f *finding.Finding, o ClosePublicBucketOptions) error {
+        if !contains(o.SupportedRules, f.RuleName()) {
+                return nil
+        }
+
+        if ok, err := guard(closeBucketAction, f, o.Threshold, o.Dedup); !ok {
+                return err
+        }
+
+        u := user.NewUser(c)
+        for _, r := range f.AffectedResources() {
+                if r.Type != "buckets" {
+                        continue
+                }
+                if err := closeBucket(c, u, r.Name); err != nil {
+                        return err
+                }
+        }
+
+        return record(closeBucketAction, f, o.Dedup)
+}
+
+// closeBucket removes the public entities found in the bucket's ACL.
+func closeBucket(c clients.ClientInt, u *user.User, bucket string) error {
+        rules, err := c.ListBucketUsers(bucket)
+        if err != nil {
+                return fmt.Errorf("failed to list bucket users: %q", err)
+        }
+        for _, rule := range rules {
+                if !publicEntities[rule.Entity] {
+                        continue
+                }
+                if err := u.RemoveEntityFromBucket(bucket, rule.Entity); err != nil {
+                        return fmt.Errorf("failed to close bucket %q: %q", bucket, err)
+                }
+                log.Printf("removed %s from bucket %q", rule.Entity, bucket)
+        }
+        return nil
+}
\ No newline at end of file
@@ -0,0 +1,76 @@
+/*
+Package actions provides the implementation of automated actions.
+
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+        https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package actions
+
+import (
+        "automation/clients"
+        "automation/finding"
+        "context"
+        "reflect"
+        "testing"
+
+        "cloud.google.com/go/pubsub"
+        stg "cloud.google.com/go/storage"
+)
+
+func TestClosePublicBucket(t *testing.T) {
+        ctx := context.Background()
+        test := []struct {
+                name    string
+                message pubsub.Message
+                acl     []stg.ACLRule
+                removed []stg.ACLEntity
+        }{
+                {
+                        name:    "remove all users",
+                        message: createHealthMessage(finding.CategoryPublicBucketACL, "//storage.googleapis.com/public-bucket"),
+                        acl: []stg.ACLRule{
+                                {Entity: "project-owners-997507777601", Role: stg.RoleOwner},
+                                {Entity: stg.AllUsers, Role: stg.RoleReader},
+                        },
+                        removed: []stg.ACLEntity{stg.AllUsers},
+                },
+                {
+                        name:    "remove all users and all authenticated users",
+                        message: createHealthMessage(finding.CategoryPublicBucketACL, "//storage.googleapis.com/public-bucket"),
+                        acl: []stg.ACLRule{
+                                {Entity: stg.AllAuthenticatedUsers, Role: stg.RoleReader},
+                                {Entity: stg.AllUsers, Role: stg.RoleReader},
+                        },
+                        removed: []stg.ACLEntity{stg.AllAuthenticatedUsers, stg.AllUsers},
+                },
+                {
+                        name:    "ignores other categories",
+                        message: createHealthMessage(finding.CategoryOpenFirewall, "//compute.googleapis.com/projects/test-project/global/firewalls/default-allow-ssh"),
+                        acl:     []stg.ACLRule{{Entity: stg.AllUsers, Role: stg.RoleReader}},
+                        removed: nil,
+                },
+        }
+        for _, tt := range test {
+                t.Run(tt.name, func(t *testing.T) {
+                        mock := clients.NewMockClients()
+                        mock.AddListBucketUsersFake(tt.acl)
+                        if err := ClosePublicBucket(ctx, tt.message, mock, ClosePublicBucketOptions{SupportedRules: []string{finding.CategoryPublicBucketACL}}); err != nil {
+                                t.Fatalf("%s failed: %q", tt.name, err)
+                        }
+                        if got := mock.SavedRemovedBucketUsers; !reflect.DeepEqual(got, tt.removed) {
+                                t.Errorf("%s failed got:%q want:%q", tt.name, got, tt.removed)
+                        }
+                })
+        }
+}
\ No newline at end of file
@@ -19,6 +19,7 @@ package actions
 
 import (
         "automation/clients"
+        "automation/dedup"
         "automation/finding"
         "automation/host"
 
@@ -31,43 +32,113 @@ import (
 )
 
 const (
+        // snapshotAction is the name findings are recorded under once handled.
+        snapshotAction = "create-snapshot"
         snapshotPrefix = "forensic-snapshots-"
         // snapshotTemplate is the name of the snapshot with disk, rule name and time included.
         snapshotTemplate = snapshotPrefix + "%s-%s"
-        // allowSnapshotOlderThanDuration defines how old a snapshot must be before we overwrite.
-        allowSnapshotOlderThanDuration = time.Minute * 5
 )
 
-// supportedRules contains a map of rules this function supports.
-var supportedRules = map[string]bool{"bad_ip": true}
+// CreateSnapshotOptions are the settings of CreateSnapshot.
+type CreateSnapshotOptions struct {
+        // SupportedRules are the rules of the findings disks are snapshotted for.
+        SupportedRules []string
+        // AllowOlderThan is how old the last snapshot of a disk must be before another is taken.
+        AllowOlderThan time.Duration
+        // Threshold is the minimum severity and priority of a finding before disks are snapshotted.
+        Threshold finding.Threshold
+        // Dedup skips findings already handled within its window, nil to never skip them.
+        Dedup *dedup.Deduplicator
+}
 
 /*
    CreateSnapshot creates a snapshot of an instance's disk.
-   For a given supported finding pull each disk associated with the affected instance.
-   - Check to make sure we haven't created a snapshot for this finding recently.
+   For a finding of one of the supported rules pull each disk associated with each affected instance.
+   - Skip findings below the minimum severity and priority.
+   - Skip findings already handled within the deduplication window.
+   - Check to make sure we haven't created a snapshot for this finding within AllowOlderThan.
    - Create a new snapshot for each disk labeled with the finding and current time.
 */
 
 // CreateSnapshot creates a snapshot of an instance's disk.
-func CreateSnapshot(ctx context.Context, m pubsub.Message, c clients.ClientInt) error {
-
+func CreateSnapshot(ctx context.Context, m pubsub.Message, c clients.ClientInt, o CreateSnapshotOptions) error {
         f := finding.NewFinding()
-        h := host.NewHost(c)
-
         if err := f.ReadFinding(&m); err != nil {
                 return fmt.Errorf("failed to read finding: %q", err)
         }
+        return snapshotFinding(ctx, c, f, o)
+}
+
+// CreateSnapshotHandler returns a Handler snapshotting the disks of affected instances with the settings.
+func CreateSnapshotHandler(o CreateSnapshotOptions) Handler {
+        return func(ctx context.Context, c clients.ClientInt, f *finding.Finding) error {
+                return snapshotFinding(ctx, c, f, o)
+        }
+}
 
-        if !supportedRules[f.RuleName()] {
+// snapshotFinding responds to the parsed finding, see CreateSnapshot.
+func snapshotFinding(ctx context.Context, c clients.ClientInt, f *finding.Finding, o CreateSnapshotOptions) error {
+        if !contains(o.SupportedRules, f.RuleName()) {
                 return nil
         }
 
-        disks, err := h.ListInstanceDisks(f.ProjectID(), f.Zone(), f.Instance())
+        if ok, err := guard(snapshotAction, f, o.Threshold, o.Dedup); !ok {
+                return err
+        }
+
+        if err := snapshotInstances(c, f, o.AllowOlderThan); err != nil {
+                return err
+        }
+
+        return record(snapshotAction, f, o.Dedup)
+}
+
+// snapshotInstances snapshots the disks of every instance the finding affects, an instance
+// failing doesn't stop the others from being snapshotted.
+func snapshotInstances(c clients.ClientInt, f *finding.Finding, allowOlderThan time.Duration) error {
+        h := host.NewHost(c)
+        var errs []string
+        for _, i := range affectedInstances(f) {
+                if err := snapshotInstance(f, h, i, allowOlderThan); err != nil {
+                        errs = append(errs, fmt.Sprintf("instance %q: %s", i.name, err))
+                }
+        }
+        if len(errs) > 0 {
+                return fmt.Errorf("failed to snapshot instances: %s", strings.Join(errs, "; "))
+        }
+        return nil
+}
+
+// instance identifies a compute instance affected by a finding.
+type instance struct {
+        projectID, zone, name string
+}
+
+// affectedInstances returns the instances affected by the finding.
+//
+// Instances listed as affected resources are preferred, otherwise the instance the
+// finding's properties attribute the activity to is used.
+func affectedInstances(f *finding.Finding) []instance {
+        is := []instance{}
+        for _, r := range f.AffectedResources() {
+                if r.Type == "instances" {
+                        is = append(is, instance{projectID: r.Project, zone: r.Zone, name: r.Name})
+                }
+        }
+        if len(is) == 0 {
+                is = append(is, instance{projectID: f.ProjectID(), zone: f.Zone(), name: f.Instance()})
+        }
+        return is
+}
+
+// snapshotInstance creates a snapshot of each disk attached to the instance.
+func snapshotInstance(f *finding.Finding, h *host.Host, i instance, allowOlderThan time.Duration) error {
+        disks, err := h.ListInstanceDisks(i.projectID, i.zone, i.name)
         if err != nil {
                 return fmt.Errorf("failed to list disks: %q", err)
         }
 
-        snapshots, err := h.ListProjectSnapshot(f.ProjectID())
+        snapshots, err := h.ListProjectSnapshot(i.projectID)
         if err != nil {
                 return fmt.Errorf("failed to list snapshots: %q", err)
         }
@@ -85,7 +156,7 @@ func CreateSnapshot(ctx context.Context, m pubsub.Message, c clients.ClientInt)
                                 continue
                         }
 
-                        isSnapshotNew, err := isSnapshotCreatedWithin(snapshot.CreationTimestamp, allowSnapshotOlderThanDuration)
+                        isSnapshotNew, err := isSnapshotCreatedWithin(snapshot.CreationTimestamp, allowOlderThan)
                         if err != nil {
                                 return fmt.Errorf("failed to parse snapshot timestamp: %q", err)
                         }
@@ -99,17 +170,27 @@ func CreateSnapshot(ctx context.Context, m pubsub.Message, c clients.ClientInt)
                         continue
                 }
 
-                if err = h.CreateDiskSnapshot(f.ProjectID(), f.Zone(), disk, sn); err != nil {
+                if err = h.CreateDiskSnapshot(i.projectID, i.zone, disk, sn); err != nil {
                         return fmt.Errorf("failed to create disk snapshot: %q", err)
                 }
 
-                if err = addSnapshotLabels(f.ProjectID(), f.Resource(), disk, f, h); err != nil {
+                if err = addSnapshotLabels(i.projectID, sn, disk, f, h); err != nil {
                         return fmt.Errorf("failed to set snapshot labels: %q", err)
                 }
         }
         return nil
 }
 
+// contains returns true if the value is in the list.
+func contains(list []string, v string) bool {
+        for _, s := range list {
+                if s == v {
+                        return true
+                }
+        }
+        return false
+}
+
 // isSnapshotCreatedWithin checks if the previous snapshots created N mins ago.
 func isSnapshotCreatedWithin(snapshotTime string, window time.Duration) (bool, error) {
         t, err := time.Parse(time.RFC3339, snapshotTime)
@@ -19,7 +19,10 @@ package actions
 
 import (
         "automation/clients"
+        "automation/dedup"
+        "automation/finding"
         "context"
+        "errors"
         "reflect"
         "testing"
         "time"
@@ -32,6 +35,10 @@ import (
 var (
         fiveMinAgo = time.Now().Add(-time.Minute * 5).Format(time.RFC3339)
 
+        // supportedRules and allowSnapshotOlderThan are the settings snapshots are taken with.
+        supportedRules         = []string{"bad_ip", "cryptomining", "ssh_brute_force", "outgoing_dos"}
+        allowSnapshotOlderThan = 5 * time.Minute
+
         sampleFinding = pubsub.Message{Data: []byte(`{
                 "insertId": "eppsoda4",
                 "jsonPayload": {"detectionCategory": {"ruleName": "bad_ip"},
@@ -76,6 +83,7 @@ func TestCreateSnapshot(t *testing.T) {
                 existingProjectDisks  []*cs.Disk
                 existingDiskSnapshots []*cs.Snapshot
                 expectedSnapshots     map[string]cs.Snapshot
+                threshold             finding.Threshold
         }{
                 {
                         name: "generate disk snapshot (1 disk and 1 snapshot)",
@@ -141,6 +149,15 @@ func TestCreateSnapshot(t *testing.T) {
                         },
                         expectedSnapshots: make(map[string]cs.Snapshot),
                 },
+                {
+                        name: "finding below threshold, skip",
+                        existingProjectDisks: []*cs.Disk{
+                                createDisk(diskName, "instance1"),
+                        },
+                        existingDiskSnapshots: []*cs.Snapshot{},
+                        expectedSnapshots:     make(map[string]cs.Snapshot),
+                        threshold:             finding.Threshold{Priority: finding.PriorityHigh},
+                },
         }
         for _, tt := range test {
                 t.Run(tt.name, func(t *testing.T) {
@@ -149,7 +166,7 @@ func TestCreateSnapshot(t *testing.T) {
                         mock.AddListDisksFake(tt.existingProjectDisks)
                         mock.AddListProjectSnapshotsFake(tt.existingDiskSnapshots)
 
-                        if err := CreateSnapshot(ctx, sampleFinding, mock); err != nil {
+                        if err := CreateSnapshot(ctx, sampleFinding, mock, CreateSnapshotOptions{SupportedRules: supportedRules, AllowOlderThan: allowSnapshotOlderThan, Threshold: tt.threshold}); err != nil {
                                 t.Errorf("failed to create snapshot :%q", err)
                         }
 
@@ -160,6 +177,206 @@ func TestCreateSnapshot(t *testing.T) {
         }
 }
 
+func TestCreateSnapshotMultipleInstances(t *testing.T) {
+        ctx := context.Background()
+        m := pubsub.Message{Data: []byte(`{
+                "jsonPayload": {"detectionCategory": {"ruleName": "bad_ip"},
+                "affectedResources":[
+                        {"gcpResourceName": "//compute.googleapis.com/projects/test-project/zones/test-zone/instances/instance1"},
+                        {"gcpResourceName": "//compute.googleapis.com/projects/test-project/zones/test-zone/instances/instance2"}
+                ],
+                "properties": {"ip":["8.8.8.8"]}
+        }, "logName": "projects/test-project/logs/threatdetection.googleapis.com%2Fdetection"}`)}
+
+        mock := clients.NewMockClients()
+        mock.AddListDisksFake([]*cs.Disk{createDisk("disk-1", "instance1"), createDisk("disk-2", "instance2"), createDisk("disk-3", "instance3")})
+        mock.AddListProjectSnapshotsFake([]*cs.Snapshot{})
+
+        if err := CreateSnapshot(ctx, m, mock, CreateSnapshotOptions{SupportedRules: supportedRules, AllowOlderThan: allowSnapshotOlderThan}); err != nil {
+                t.Fatalf("failed to create snapshot :%q", err)
+        }
+        for _, disk := range []string{"disk-1", "disk-2"} {
+                if _, ok := mock.SavedCreateSnapshots[disk]; !ok {
+                        t.Errorf("snapshot not created for %q", disk)
+                }
+        }
+        if _, ok := mock.SavedCreateSnapshots["disk-3"]; ok {
+                t.Errorf("snapshot created for unaffected disk")
+        }
+}
+
+func TestCreateSnapshotInstanceError(t *testing.T) {
+        ctx := context.Background()
+        m := pubsub.Message{Data: []byte(`{
+                "insertId": "eppsoda4",
+                "jsonPayload": {"detectionCategory": {"ruleName": "bad_ip"},
+                "affectedResources":[
+                        {"gcpResourceName": "//compute.googleapis.com/projects/test-project/zones/test-zone/instances/instance1"},
+                        {"gcpResourceName": "//compute.googleapis.com/projects/test-project/zones/test-zone/instances/instance2"}
+                ],
+                "properties": {"ip":["8.8.8.8"]}
+        }, "logName": "projects/test-project/logs/threatdetection.googleapis.com%2Fdetection"}`)}
+
+        mock := clients.NewMockClients()
+        mock.AddListDisksFake([]*cs.Disk{createDisk("disk-1", "instance1"), createDisk("disk-2", "instance2")})
+        mock.AddListProjectSnapshotsFake([]*cs.Snapshot{})
+        mock.AddCreateSnapshotErrorFake("disk-1", errors.New("quota exceeded"))
+        d := dedup.New(dedup.NewMemoryStore(), time.Hour)
+
+        exp := `failed to snapshot instances: instance "instance1": failed to create disk snapshot: "failed to create snapshot: \"quota exceeded\""`
+        if err := CreateSnapshot(ctx, m, mock, CreateSnapshotOptions{SupportedRules: supportedRules, AllowOlderThan: allowSnapshotOlderThan, Dedup: d}); err == nil || err.Error() != exp {
+                t.Errorf("exp:%q got:%q", exp, err)
+        }
+        if _, ok := mock.SavedCreateSnapshots["disk-2"]; !ok {
+                t.Errorf("snapshot not created for the instance after the failure")
+        }
+        f := finding.NewFinding()
+        if err := f.ReadFinding(&m); err != nil {
+                t.Fatalf("failed to read finding: %q", err)
+        }
+        if seen, err := d.Seen(snapshotAction, f); err != nil || seen {
+                t.Errorf("failed finding recorded as handled: %v %q", seen, err)
+        }
+}
+
+func TestCreateSnapshotSupportedRules(t *testing.T) {
+        ctx := context.Background()
+        test := []struct {
+                name       string
+                ruleName   string
+                properties string
+                expected   []string
+        }{
+                {
+                        name:     "cryptomining",
+                        ruleName: "cryptomining",
+                        properties: `"location": "test-zone",
+                                "sourceInstance": "/projects/test-project/zones/test-zone/instances/instance1",
+                                "ip": ["52.8.47.33"]`,
+                        expected: []string{"forensic-snapshots-cryptomining-disk-1"},
+                },
+                {
+                        name:     "ssh brute force",
+                        ruleName: "ssh_brute_force",
+                        properties: `"project_id": "test-project",
+                                "location": "test-zone",
+                                "loginAttempts": [{"authResult": "FAIL", "sourceIp": "10.200.0.2", "userName": "root", "vmName": "instance1"}]`,
+                        expected: []string{"forensic-snapshots-ssh-brute-force-disk-1"},
+                },
+                {
+                        name:     "outgoing dos",
+                        ruleName: "outgoing_dos",
+                        properties: `"location": "test-zone",
+                                "sourceInstance": "/projects/test-project/zones/test-zone/instances/instance1",
+                                "ip": ["203.0.113.9"]`,
+                        expected: []string{"forensic-snapshots-outgoing-dos-disk-1"},
+                },
+                {
+                        name:     "unsupported rule",
+                        ruleName: "bad_domain",
+                        properties: `"location": "test-zone",
+                                "sourceInstance": "/projects/test-project/zones/test-zone/instances/instance1"`,
+                        expected: []string{},
+                },
+        }
+        for _, tt := range test {
+                t.Run(tt.name, func(t *testing.T) {
+                        m := pubsub.Message{Data: []byte(`{
+                                "jsonPayload": {
+                                        "detectionCategory": {"ruleName": "` + tt.ruleName + `"},
+                                        "properties": {` + tt.properties + `}
+                                },
+                                "logName": "projects/test-project/logs/threatdetection.googleapis.com%2Fdetection"
+                        }`)}
+                        mock := clients.NewMockClients()
+                        mock.AddListDisksFake([]*cs.Disk{createDisk("disk-1", "instance1")})
+                        mock.AddListProjectSnapshotsFake([]*cs.Snapshot{})
+
+                        if err := CreateSnapshot(ctx, m, mock, CreateSnapshotOptions{SupportedRules: supportedRules, AllowOlderThan: allowSnapshotOlderThan}); err != nil {
+                                t.Fatalf("failed to create snapshot :%q", err)
+                        }
+                        got := []string{}
+                        for _, s := range mock.SavedCreateSnapshots {
+                                got = append(got, s.Name)
+                        }
+                        if !reflect.DeepEqual(got, tt.expected) {
+                                t.Errorf("%v failed exp:%v got:%v", tt.name, tt.expected, got)
+                        }
+                })
+        }
+}
+
+func TestCreateSnapshotDuplicate(t *testing.T) {
+        ctx := context.Background()
+        d := dedup.New(dedup.NewMemoryStore(), time.Hour)
+        for i, exp := range []int{1, 0} {
+                mock := clients.NewMockClients()
+                mock.AddListDisksFake([]*cs.Disk{createDisk("sample-disk-name", "instance1")})
+                mock.AddListProjectSnapshotsFake([]*cs.Snapshot{})
+                if err := CreateSnapshot(ctx, sampleFinding, mock, CreateSnapshotOptions{SupportedRules: supportedRules, AllowOlderThan: allowSnapshotOlderThan, Dedup: d}); err != nil {
+                        t.Fatalf("failed to create snapshot :%q", err)
+                }
+                if got := len(mock.SavedCreateSnapshots); got != exp {
+                        t.Errorf("delivery %d failed exp:%d snapshots got:%d", i+1, exp, got)
+                }
+        }
+}
+
+func TestCreateSnapshotInvalidFinding(t *testing.T) {
+        ctx := context.Background()
+        m := pubsub.Message{Data: []byte(`{
+                "jsonPayload": {"detectionCategory": {"ruleName": "bad_ip"},
+                "properties": {"location": "test-zone", "ip":["8.8.8.8"]}
+        }, "logName": "projects/test-project/logs/threatdetection.googleapis.com%2Fdetection"}`)}
+
+        mock := clients.NewMockClients()
+        mock.AddListDisksFake([]*cs.Disk{createDisk("disk-1", "instance1")})
+        mock.AddListProjectSnapshotsFake([]*cs.Snapshot{})
+
+        exp := `invalid finding: "value not found: jsonPayload.affectedResources instance or jsonPayload.properties.sourceInstance"`
+        if err := CreateSnapshot(ctx, m, mock, CreateSnapshotOptions{SupportedRules: supportedRules, AllowOlderThan: allowSnapshotOlderThan}); err == nil || err.Error() != exp {
+                t.Errorf("exp:%q got:%q", exp, err)
+        }
+        if len(mock.SavedCreateSnapshots) != 0 {
+                t.Errorf("snapshot created for invalid finding: %v", mock.SavedCreateSnapshots)
+        }
+}
+
+func TestCreateSnapshotDryRun(t *testing.T) {
+        ctx := context.Background()
+        mock := clients.NewMockClients()
+        mock.AddListDisksFake([]*cs.Disk{createDisk("disk-1", "instance1")})
+        mock.AddListProjectSnapshotsFake([]*cs.Snapshot{})
+        dry := clients.NewDryRun(mock)
+        m := pubsub.Message{Data: []byte(`{
+                "jsonPayload": {"detectionCategory": {"ruleName": "bad_ip"},
+                "properties": {
+                        "project_id": "test-project",
+                        "location": "test-zone",
+                        "sourceInstance": "/projects/test-project/zones/test-zone/instances/instance1",
+                        "ip":["8.8.8.8"]
+                }
+        }, "logName": "projects/test-project/logs/threatdetection.googleapis.com%2Fdetection"}`)}
+
+        if err := CreateSnapshot(ctx, m, dry, CreateSnapshotOptions{SupportedRules: supportedRules, AllowOlderThan: allowSnapshotOlderThan}); err != nil {
+                t.Fatalf("failed to plan snapshot: %q", err)
+        }
+        if len(mock.SavedCreateSnapshots) != 0 {
+                t.Errorf("failed dry run created snapshots: %v", mock.SavedCreateSnapshots)
+        }
+        var ops []string
+        for _, c := range dry.Plan() {
+                ops = append(ops, c.Operation+" "+c.Resource)
+        }
+        exp := []string{
+                "createSnapshot //compute.googleapis.com/projects/test-project/zones/test-zone/disks/disk-1",
+                "setLabels //compute.googleapis.com/projects/test-project/global/snapshots/forensic-snapshots-bad-ip-disk-1",
+        }
+        if !reflect.DeepEqual(ops, exp) {
+                t.Errorf("failed got:%q want:%q", ops, exp)
+        }
+}
+
 func createDisk(name, instance string) *cs.Disk {
         return &cs.Disk{
                 Name:  name,
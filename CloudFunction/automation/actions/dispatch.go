@@ -0,0 +1,133 @@
+/*
+Package actions provides the implementation of automated actions.
+
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+        https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package actions
+
+import (
+        "automation/clients"
+        "automation/finding"
+
+        "context"
+        "fmt"
+        "log"
+        "strings"
+        "sync"
+
+        "cloud.google.com/go/pubsub"
+)
+
+// Handler responds to a finding already read from its message.
+type Handler func(ctx context.Context, c clients.ClientInt, f *finding.Finding) error
+
+// Result is the outcome of an action run by Dispatch.
+type Result struct {
+        // Action is the name the action was registered with.
+        Action string
+        // Err is the error the action failed with, nil if it succeeded.
+        Err error
+}
+
+// registeredAction is an action and the rules it's interested in.
+type registeredAction struct {
+        name    string
+        rules   map[string]bool
+        handler Handler
+}
+
+// Registry routes findings to the actions interested in them.
+type Registry struct {
+        mu      sync.RWMutex
+        actions []registeredAction
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+        return &Registry{}
+}
+
+// Register adds the action under the name, it's run for findings whose rule name, sub rule
+// name or audit log method is one of rules. Without rules it's run for every finding.
+// If Register is called twice with the same name or if h is nil, it panics.
+func (r *Registry) Register(name string, rules []string, h Handler) {
+        r.mu.Lock()
+        defer r.mu.Unlock()
+        if h == nil {
+                panic("actions: register handler is nil for " + name)
+        }
+        for _, a := range r.actions {
+                if a.name == name {
+                        panic("actions: register called twice for " + name)
+                }
+        }
+        a := registeredAction{name: name, handler: h}
+        if len(rules) > 0 {
+                a.rules = make(map[string]bool)
+                for _, rule := range rules {
+                        a.rules[rule] = true
+                }
+        }
+        r.actions = append(r.actions, a)
+}
+
+// interested returns true if the action handles the finding.
+func (a registeredAction) interested(f *finding.Finding) bool {
+        if a.rules == nil {
+                return true
+        }
+        for _, n := range []string{f.RuleName(), f.SubRuleName(), f.MethodName()} {
+                if n != "" && a.rules[n] {
+                        return true
+                }
+        }
+        return false
+}
+
+// Dispatch reads the finding once and runs every interested action in the order they were
+// registered.
+//
+// Every interested action runs even if an earlier one fails, the failures are returned
+// together along with the result of each action.
+func (r *Registry) Dispatch(ctx context.Context, m pubsub.Message, c clients.ClientInt) ([]Result, error) {
+        f := finding.NewFinding()
+        if err := f.ReadFinding(&m); err != nil {
+                return nil, fmt.Errorf("failed to read finding: %q", err)
+        }
+
+        r.mu.RLock()
+        actions := r.actions
+        r.mu.RUnlock()
+
+        results := []Result{}
+        var errs []string
+        for _, a := range actions {
+                if !a.interested(f) {
+                        continue
+                }
+                err := a.handler(ctx, c, f)
+                results = append(results, Result{Action: a.name, Err: err})
+                if err != nil {
+                        errs = append(errs, fmt.Sprintf("%s: %s", a.name, err))
+                }
+        }
+        if len(results) == 0 {
+                log.Printf("no action for %s finding %q", f.RuleName(), f.InsertID())
+        }
+        if len(errs) > 0 {
+                return results, fmt.Errorf("failed actions: %s", strings.Join(errs, "; "))
+        }
+        return results, nil
+}
\ No newline at end of file
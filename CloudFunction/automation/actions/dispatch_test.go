@@ -0,0 +1,78 @@
+/*
+Package actions provides the implementation of automated actions.
+
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+        https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package actions
+
+import (
+        "automation/clients"
+        "automation/finding"
+        "context"
+        "errors"
+        "reflect"
+        "testing"
+
+        "cloud.google.com/go/pubsub"
+)
+
+func TestDispatch(t *testing.T) {
+        ctx := context.Background()
+        r := NewRegistry()
+        r.Register("close-open-firewall", []string{finding.CategoryOpenFirewall}, CloseOpenFirewallHandler(CloseOpenFirewallOptions{SupportedRules: []string{finding.CategoryOpenFirewall}}))
+        r.Register("close-public-bucket", []string{finding.CategoryPublicBucketACL}, ClosePublicBucketHandler(ClosePublicBucketOptions{SupportedRules: []string{finding.CategoryPublicBucketACL}}))
+        r.Register("revoke-external-grants", RevokeExternalGrantsRules, RevokeExternalGrantsHandler(RevokeExternalGrantsOptions{FolderIDs: []string{"folderID"}, Disallowed: []string{"gmail.com"}}))
+        r.Register("every-finding", nil, func(context.Context, clients.ClientInt, *finding.Finding) error {
+                return errors.New("unavailable")
+        })
+
+        mock := clients.NewMockClients()
+        m := createHealthMessage(finding.CategoryOpenFirewall, "//compute.googleapis.com/projects/test-project/global/firewalls/default-allow-ssh")
+        results, err := r.Dispatch(ctx, m, mock)
+        if exp := "failed actions: every-finding: unavailable"; err == nil || err.Error() != exp {
+                t.Errorf("failed got:%v want:%q", err, exp)
+        }
+        exp := []Result{{Action: "close-open-firewall"}, {Action: "every-finding", Err: errors.New("unavailable")}}
+        if !reflect.DeepEqual(results, exp) {
+                t.Errorf("failed results got:%+v want:%+v", results, exp)
+        }
+        if len(mock.SavedFirewallRules) != 1 {
+                t.Errorf("failed got %d rules disabled want 1", len(mock.SavedFirewallRules))
+        }
+
+        mock = clients.NewMockClients()
+        mock.AddGetPolicyFake(createPolicy([]string{"user:tom@gmail.com"}))
+        mock.AddGetProjectAncestryFake([]string{"projects/test-project", "folders/folderID"})
+        results, _ = r.Dispatch(ctx, createAuditMessage("user:tom@gmail.com"), mock)
+        if got := len(results); got != 2 || results[0].Action != "revoke-external-grants" || results[0].Err != nil {
+                t.Errorf("failed audit log results got:%+v", results)
+        }
+
+        if _, err := r.Dispatch(ctx, pubsub.Message{Data: []byte(`{`)}, mock); err == nil {
+                t.Errorf("failed to reject invalid message")
+        }
+}
+
+func TestRegisterTwice(t *testing.T) {
+        defer func() {
+                if recover() == nil {
+                        t.Errorf("failed to panic registering an action twice")
+                }
+        }()
+        r := NewRegistry()
+        h := func(context.Context, clients.ClientInt, *finding.Finding) error { return nil }
+        r.Register("action", nil, h)
+        r.Register("action", nil, h)
+}
\ No newline at end of file
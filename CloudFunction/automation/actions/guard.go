@@ -0,0 +1,60 @@
+/*
+Package actions provides the implementation of automated actions.
+
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+        https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package actions
+
+import (
+        "automation/dedup"
+        "automation/finding"
+
+        "fmt"
+        "log"
+)
+
+// guard runs the checks every action makes before responding to a finding.
+//
+// Findings below the threshold or already handled by the action within the deduplication
+// window are logged and skipped, invalid findings are an error. It returns true if the
+// action should respond.
+func guard(action string, f *finding.Finding, min finding.Threshold, d *dedup.Deduplicator) (bool, error) {
+        if !f.Meets(min) {
+                log.Printf("skipping %s finding with severity %s and priority %s", f.RuleName(), f.Severity(), f.Priority())
+                return false, nil
+        }
+
+        if err := f.Validate(); err != nil {
+                return false, fmt.Errorf("invalid finding: %q", err)
+        }
+
+        seen, err := d.Seen(action, f)
+        if err != nil {
+                return false, fmt.Errorf("failed to check for duplicates: %q", err)
+        }
+        if seen {
+                log.Printf("skipping duplicate %s finding %q", f.RuleName(), f.InsertID())
+                return false, nil
+        }
+        return true, nil
+}
+
+// record marks the finding as handled by the action.
+func record(action string, f *finding.Finding, d *dedup.Deduplicator) error {
+        if err := d.Record(action, f); err != nil {
+                return fmt.Errorf("failed to record finding: %q", err)
+        }
+        return nil
+}
\ No newline at end of file
@@ -0,0 +1,79 @@
+/*
+Package actions provides the implementation of automated actions.
+
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+        https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package actions
+
+import (
+        "automation/clients"
+        "automation/finding"
+        "automation/journal"
+        "automation/playbook"
+
+        "context"
+        "errors"
+        "time"
+)
+
+// PlaybookStepsOptions are the settings of the steps PlaybookSteps adds.
+type PlaybookStepsOptions struct {
+        // AllowSnapshotOlderThan is how old the last snapshot of a disk must be before the
+        // snapshot-disks step takes another.
+        AllowSnapshotOlderThan time.Duration
+        // FolderIDs are the folders, or organizations as "organizations/<id>", the remove-members
+        // and remove-domains steps revoke grants within.
+        FolderIDs []string
+        // Journal records every policy before a step changes it, nil to not record them.
+        Journal journal.Store
+}
+
+// PlaybookSteps returns the built-in playbook steps and the steps responding exactly like
+// an action does, with the settings:
+//
+//   - "snapshot-disks" snapshots every disk of the instances the finding affects like
+//     CreateSnapshot, disks snapshotted within AllowSnapshotOlderThan are skipped.
+//   - "remove-members" removes the members of the domains the finding reports as added from
+//     the roles they were granted, like RevokeExternalGrants with RevokeFlagged.
+//   - "remove-domains" removes every member of the domains from the resources the grant was
+//     made on, like RevokeExternalGrants with RevokeDomains.
+//
+// Both remove steps require the "domains" parameter. The playbook package can't import
+// actions, playbooks are therefore loaded and run with these steps.
+func PlaybookSteps(o PlaybookStepsOptions) playbook.Steps {
+        steps := playbook.BuiltinSteps()
+        steps["snapshot-disks"] = func(_ context.Context, c clients.ClientInt, f *finding.Finding, _ playbook.Params) error {
+                return snapshotInstances(c, f, o.AllowSnapshotOlderThan)
+        }
+        steps["remove-members"] = revokeStep(o, RevokeFlagged)
+        steps["remove-domains"] = revokeStep(o, RevokeDomains)
+        return steps
+}
+
+// revokeStep returns a step revoking the grants of the finding within the folders of the
+// settings, the domains revoked are the step's "domains" parameter.
+func revokeStep(o PlaybookStepsOptions, mode RevokeMode) playbook.StepFunc {
+        return func(_ context.Context, c clients.ClientInt, f *finding.Finding, p playbook.Params) error {
+                var domains []string
+                if _, err := p.Get("domains", &domains); err != nil {
+                        return err
+                }
+                if len(domains) == 0 {
+                        return errors.New(`parameter "domains" is required`)
+                }
+                _, err := revokeGrants(c, f, RevokeExternalGrantsOptions{FolderIDs: o.FolderIDs, Disallowed: domains, Mode: mode, Journal: o.Journal}, nil)
+                return err
+        }
+}
\ No newline at end of file
@@ -0,0 +1,213 @@
+/*
+Package actions provides the implementation of automated actions.
+
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+        https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package actions
+
+import (
+        "automation/clients"
+        "automation/dedup"
+        "automation/finding"
+        "automation/firewall"
+        "automation/host"
+
+        "context"
+        "fmt"
+        "log"
+        "path"
+        "regexp"
+
+        "cloud.google.com/go/pubsub"
+        cs "google.golang.org/api/compute/v1"
+)
+
+const (
+        // quarantineAction is the name findings are recorded under once handled.
+        quarantineAction = "quarantine-instance"
+        // quarantineAllowPriority is the priority of the rules letting the forensics ranges
+        // through, it's above the deny rules so they take precedence.
+        quarantineAllowPriority = 0
+        // quarantineDenyPriority is the priority of the deny-all rules.
+        quarantineDenyPriority = 1
+        // maxRuleNameLength is the longest name a firewall rule can have.
+        maxRuleNameLength = 63
+)
+
+// networkProjectPattern extracts the project of a network URL, the host project for a
+// Shared VPC network.
+var networkProjectPattern = regexp.MustCompile(`projects/([^/]+)/global/networks/`)
+
+// QuarantineInstanceOptions are the settings of QuarantineInstance.
+type QuarantineInstanceOptions struct {
+        // SupportedRules are the rules of the findings instances are quarantined for.
+        SupportedRules []string
+        // Tag is the network tag quarantined instances are given.
+        Tag string
+        // ForensicsRanges are the ranges quarantined instances can still reach and be reached from.
+        ForensicsRanges []string
+        // Threshold is the minimum severity and priority of a finding before instances are quarantined.
+        Threshold finding.Threshold
+        // Dedup skips findings already handled within its window, nil to never skip them.
+        Dedup *dedup.Deduplicator
+}
+
+// QuarantineInstance cuts the instances a finding affects off the network.
+//
+// Each instance is given the quarantine network tag, after making sure its networks have
+// deny-all ingress and egress firewall rules targeting the tag. Traffic from and to the
+// forensics ranges is allowed through by higher priority rules so the instance can still be
+// investigated. Findings of rules that aren't supported, below the minimum severity and
+// priority or already handled within the deduplication window are ignored.
+func QuarantineInstance(ctx context.Context, m pubsub.Message, c clients.ClientInt, o QuarantineInstanceOptions) error {
+        f := finding.NewFinding()
+        if err := f.ReadFinding(&m); err != nil {
+                return fmt.Errorf("failed to read finding: %q", err)
+        }
+        return quarantineInstance(ctx, c, f, o)
+}
+
+// QuarantineInstanceHandler returns a Handler quarantining affected instances with the settings.
+func QuarantineInstanceHandler(o QuarantineInstanceOptions) Handler {
+        return func(ctx context.Context, c clients.ClientInt, f *finding.Finding) error {
+                return quarantineInstance(ctx, c, f, o)
+        }
+}
+
+// quarantineInstance responds to the parsed finding, see QuarantineInstance.
+func quarantineInstance(ctx context.Context, c clients.ClientInt, f *finding.Finding, o QuarantineInstanceOptions) error {
+        if !contains(o.SupportedRules, f.RuleName()) {
+                return nil
+        }
+
+        if ok, err := guard(quarantineAction, f, o.Threshold, o.Dedup); !ok {
+                return err
+        }
+
+        h := host.NewHost(c)
+        fw := firewall.NewFirewall(c)
+        for _, i := range affectedInstances(f) {
+                networks, err := h.InstanceNetworks(i.projectID, i.zone, i.name)
+                if err != nil {
+                        return err
+                }
+                for _, network := range networks {
+                        rules, err := quarantineRules(network, o.Tag, o.ForensicsRanges)
+                        if err != nil {
+                                return err
+                        }
+                        // Rules of a Shared VPC network are created in its host project.
+                        projectID := networkProject(network, i.projectID)
+                        for _, rule := range rules {
+                                created, err := fw.EnsureFirewallRule(projectID, rule)
+                                if err != nil {
+                                        return fmt.Errorf("failed to ensure quarantine rule %q: %q", rule.Name, err)
+                                }
+                                if created {
+                                        log.Printf("created quarantine rule %q in project %q", rule.Name, projectID)
+                                }
+                        }
+                }
+                added, err := h.AddInstanceTag(i.projectID, i.zone, i.name, o.Tag)
+                if err != nil {
+                        return fmt.Errorf("failed to quarantine instance %q: %q", i.name, err)
+                }
+                if added {
+                        log.Printf("quarantined instance %q in project %q", i.name, i.projectID)
+                }
+        }
+
+        return record(quarantineAction, f, o.Dedup)
+}
+
+// networkProject returns the project of the network URL, or the fallback if the URL has none.
+func networkProject(network, fallback string) string {
+        if m := networkProjectPattern.FindStringSubmatch(network); m != nil {
+                return m[1]
+        }
+        return fallback
+}
+
+// quarantineRules returns the firewall rules isolating instances with the tag on the network.
+//
+// The network is the URL of the network, rules are named after the tag and the network's
+// name. It fails if a name would be too long for a firewall rule.
+func quarantineRules(network, tag string, forensicsRanges []string) ([]*cs.Firewall, error) {
+        name := tag + "-%s-" + path.Base(network)
+        deny := []*cs.FirewallDenied{{IPProtocol: "all"}}
+        rules := []*cs.Firewall{
+                {
+                        Name:         fmt.Sprintf(name, "deny-ingress"),
+                        Description:  "Denies all ingress to quarantined instances.",
+                        Network:      network,
+                        Direction:    "INGRESS",
+                        Priority:     quarantineDenyPriority,
+                        Denied:       deny,
+                        SourceRanges: []string{"0.0.0.0/0"},
+                        TargetTags:   []string{tag},
+                },
+                {
+                        Name:              fmt.Sprintf(name, "deny-egress"),
+                        Description:       "Denies all egress from quarantined instances.",
+                        Network:           network,
+                        Direction:         "EGRESS",
+                        Priority:          quarantineDenyPriority,
+                        Denied:            deny,
+                        DestinationRanges: []string{"0.0.0.0/0"},
+                        TargetTags:        []string{tag},
+                },
+        }
+        if len(forensicsRanges) > 0 {
+                rules = append(rules, quarantineAllowRules(network, tag, forensicsRanges)...)
+        }
+        for _, r := range rules {
+                if len(r.Name) > maxRuleNameLength {
+                        return nil, fmt.Errorf("quarantine rule name %q is longer than %d characters", r.Name, maxRuleNameLength)
+                }
+        }
+        return rules, nil
+}
+
+// quarantineAllowRules returns the firewall rules letting the forensics ranges through to
+// and from instances with the tag on the network.
+func quarantineAllowRules(network, tag string, forensicsRanges []string) []*cs.Firewall {
+        name := tag + "-%s-" + path.Base(network)
+        allow := []*cs.FirewallAllowed{{IPProtocol: "all"}}
+        return []*cs.Firewall{
+                {
+                        Name:         fmt.Sprintf(name, "allow-ingress"),
+                        Description:  "Allows ingress to quarantined instances from the forensics ranges.",
+                        Network:      network,
+                        Direction:    "INGRESS",
+                        Priority:     quarantineAllowPriority,
+                        Allowed:      allow,
+                        SourceRanges: forensicsRanges,
+                        TargetTags:   []string{tag},
+                        // The zero priority is otherwise omitted and defaults to 1000.
+                        ForceSendFields: []string{"Priority"},
+                },
+                {
+                        Name:              fmt.Sprintf(name, "allow-egress"),
+                        Description:       "Allows egress from quarantined instances to the forensics ranges.",
+                        Network:           network,
+                        Direction:         "EGRESS",
+                        Priority:          quarantineAllowPriority,
+                        Allowed:           allow,
+                        DestinationRanges: forensicsRanges,
+                        TargetTags:        []string{tag},
+                        ForceSendFields:   []string{"Priority"},
+                },
+        }
+}
\ No newline at end of file
@@ -0,0 +1,188 @@
+/*
+Package actions provides the implementation of automated actions.
+
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+        https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package actions
+
+import (
+        "automation/clients"
+        "context"
+        "errors"
+        "reflect"
+        "strings"
+        "testing"
+
+        "cloud.google.com/go/pubsub"
+        cs "google.golang.org/api/compute/v1"
+)
+
+func TestQuarantineInstance(t *testing.T) {
+        ctx := context.Background()
+        const network = "https://www.googleapis.com/compute/v1/projects/test-project/global/networks/default"
+        denyRules := []string{"quarantine-deny-ingress-default", "quarantine-deny-egress-default"}
+        tests := []struct {
+                name            string
+                network         string
+                ruleName        string
+                forensicsRanges []string
+                tags            []string
+                existingRules   []string
+                expectedRules   []string
+                expectedProject string
+                expectedTags    []string
+        }{
+                {
+                        name:            "quarantines bad ip instance",
+                        ruleName:        "bad_ip",
+                        tags:            []string{"http-server"},
+                        expectedRules:   denyRules,
+                        expectedProject: "test-project",
+                        expectedTags:    []string{"http-server", "quarantine"},
+                },
+                {
+                        name:            "creates rules in shared vpc host project",
+                        network:         "https://www.googleapis.com/compute/v1/projects/host-project/global/networks/shared",
+                        ruleName:        "bad_ip",
+                        expectedRules:   []string{"quarantine-deny-ingress-shared", "quarantine-deny-egress-shared"},
+                        expectedProject: "host-project",
+                        expectedTags:    []string{"quarantine"},
+                },
+                {
+                        name:            "allows forensics ranges",
+                        ruleName:        "bad_domain",
+                        forensicsRanges: []string{"10.128.0.0/24"},
+                        expectedRules:   append(denyRules, "quarantine-allow-ingress-default", "quarantine-allow-egress-default"),
+                        expectedProject: "test-project",
+                        expectedTags:    []string{"quarantine"},
+                },
+                {
+                        name:          "keeps existing rules and tags",
+                        ruleName:      "bad_ip",
+                        tags:          []string{"quarantine"},
+                        existingRules: denyRules,
+                },
+                {
+                        name:     "ignores other rules",
+                        ruleName: "ssh_brute_force",
+                },
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        m := pubsub.Message{Data: []byte(`{
+                                "jsonPayload": {"detectionCategory": {"ruleName": "` + tt.ruleName + `"},
+                                "properties": {
+                                        "project_id": "test-project",
+                                        "location": "test-zone",
+                                        "sourceInstance": "/projects/test-project/zones/test-zone/instances/instance1",
+                                        "loginAttempts": [],
+                                        "ip": ["8.8.8.8"]
+                                }
+                        }, "logName": "projects/test-project/logs/threatdetection.googleapis.com%2Fdetection"}`)}
+                        if tt.network == "" {
+                                tt.network = network
+                        }
+                        mock := clients.NewMockClients()
+                        mock.AddGetInstanceFake(&cs.Instance{
+                                Name:              "instance1",
+                                NetworkInterfaces: []*cs.NetworkInterface{{Network: tt.network}},
+                                Tags:              &cs.Tags{Items: tt.tags, Fingerprint: "42WmSpB8rSM="},
+                        })
+                        for _, r := range tt.existingRules {
+                                mock.AddFirewallRuleFake(&cs.Firewall{Name: r})
+                        }
+
+                        if err := QuarantineInstance(ctx, m, mock, QuarantineInstanceOptions{SupportedRules: []string{"bad_ip", "bad_domain"}, Tag: "quarantine", ForensicsRanges: tt.forensicsRanges}); err != nil {
+                                t.Fatalf("%s failed: %q", tt.name, err)
+                        }
+                        var rules, waited []string
+                        for _, r := range mock.SavedInsertedFirewalls {
+                                if r.Network != tt.network || !reflect.DeepEqual(r.TargetTags, []string{"quarantine"}) {
+                                        t.Errorf("%s failed rule %q targets %q on %q", tt.name, r.Name, r.TargetTags, r.Network)
+                                }
+                                if p := mock.SavedInsertedProjects[r.Name]; p != tt.expectedProject {
+                                        t.Errorf("%s failed rule %q project got:%q want:%q", tt.name, r.Name, p, tt.expectedProject)
+                                }
+                                rules = append(rules, r.Name)
+                                waited = append(waited, "insert-"+r.Name)
+                        }
+                        if !reflect.DeepEqual(rules, tt.expectedRules) {
+                                t.Errorf("%s failed rules got:%q want:%q", tt.name, rules, tt.expectedRules)
+                        }
+                        if !reflect.DeepEqual(mock.SavedWaitedOperations, waited) {
+                                t.Errorf("%s failed waited got:%q want:%q", tt.name, mock.SavedWaitedOperations, waited)
+                        }
+                        var tags []string
+                        if set, ok := mock.SavedInstanceTags["instance1"]; ok {
+                                tags = set.Items
+                                if set.Fingerprint != "42WmSpB8rSM=" {
+                                        t.Errorf("%s failed fingerprint got:%q", tt.name, set.Fingerprint)
+                                }
+                        }
+                        if !reflect.DeepEqual(tags, tt.expectedTags) {
+                                t.Errorf("%s failed tags got:%q want:%q", tt.name, tags, tt.expectedTags)
+                        }
+                })
+        }
+}
+
+func TestQuarantineInstanceFailures(t *testing.T) {
+        ctx := context.Background()
+        tests := []struct {
+                name     string
+                tag      string
+                waitErr  error
+                errMatch string
+        }{
+                {
+                        name:     "rule name too long",
+                        tag:      "quarantined-by-the-incident-response-automation-tag",
+                        errMatch: `quarantine rule name "quarantined-by-the-incident-response-automation-tag-deny-ingress-default" is longer than 63 characters`,
+                },
+                {
+                        name:     "rule not in effect",
+                        tag:      "quarantine",
+                        waitErr:  errors.New("QUOTA_EXCEEDED"),
+                        errMatch: `failed to ensure quarantine rule "quarantine-deny-ingress-default"`,
+                },
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        m := pubsub.Message{Data: []byte(`{
+                                "jsonPayload": {"detectionCategory": {"ruleName": "bad_ip"},
+                                "properties": {
+                                        "project_id": "test-project",
+                                        "location": "test-zone",
+                                        "sourceInstance": "/projects/test-project/zones/test-zone/instances/instance1",
+                                        "ip": ["8.8.8.8"]
+                                }
+                        }, "logName": "projects/test-project/logs/threatdetection.googleapis.com%2Fdetection"}`)}
+                        mock := clients.NewMockClients()
+                        mock.AddGetInstanceFake(&cs.Instance{
+                                Name:              "instance1",
+                                NetworkInterfaces: []*cs.NetworkInterface{{Network: "https://www.googleapis.com/compute/v1/projects/test-project/global/networks/default"}},
+                                Tags:              &cs.Tags{},
+                        })
+                        mock.AddWaitOperationErrorFake(tt.waitErr)
+                        err := QuarantineInstance(ctx, m, mock, QuarantineInstanceOptions{SupportedRules: []string{"bad_ip"}, Tag: tt.tag})
+                        if err == nil || !strings.Contains(err.Error(), tt.errMatch) {
+                                t.Errorf("%s failed got:%v want:%q", tt.name, err, tt.errMatch)
+                        }
+                        if _, ok := mock.SavedInstanceTags["instance1"]; ok {
+                                t.Errorf("%s failed instance tagged", tt.name)
+                        }
+                })
+        }
+}
\ No newline at end of file
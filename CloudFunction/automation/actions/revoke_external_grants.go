@@ -19,15 +19,58 @@ package actions
 
 import (
         "automation/clients"
+        "automation/dedup"
         "automation/finding"
+        "automation/journal"
         "automation/user"
 
         "context"
         "fmt"
+        "log"
+        "strings"
 
         "cloud.google.com/go/pubsub"
 )
 
+// revokeAction is the name findings are recorded under once handled.
+const revokeAction = "revoke-external-grants"
+
+// RevokeMode selects which members RevokeExternalGrants removes.
+type RevokeMode int
+
+const (
+        // RevokeFlagged removes only the members flagged by the finding, and only from the
+        // roles the finding reports were granted to them.
+        RevokeFlagged RevokeMode = iota
+        // RevokeDomains removes every member of a disallowed domain from every role, including
+        // members that were granted access before the finding.
+        RevokeDomains
+        // RevokeAllowlist removes every member outside of the allowed domains, and their
+        // subdomains, from every role. Public members and Google managed service accounts are kept.
+        RevokeAllowlist
+)
+
+// RevokeExternalGrantsOptions are the settings of RevokeExternalGrants.
+type RevokeExternalGrantsOptions struct {
+        // FolderIDs are the folders, or organizations as "organizations/<id>", grants are revoked within.
+        FolderIDs []string
+        // Disallowed are the domains whose members are revoked.
+        Disallowed []string
+        // Allowed are the approved domains RevokeAllowlist keeps the members of.
+        Allowed []string
+        // Mode selects which members are removed.
+        Mode RevokeMode
+        // Threshold is the minimum severity and priority of a grant before it's revoked.
+        Threshold finding.Threshold
+        // Dedup skips findings already handled within its window, nil to never skip them.
+        Dedup *dedup.Deduplicator
+        // Journal records every policy before it's changed, nil to not record them.
+        Journal journal.Store
+}
+
+// RevokeExternalGrantsRules are the sub rules and audit log methods RevokeExternalGrants responds to.
+var RevokeExternalGrantsRules = []string{"external_member_added_to_policy", "external_member_invited_to_policy", finding.MethodSetIamPolicy}
+
 /*
 RevokeExternalGrants is the entry point of the Cloud Function.
 
@@ -35,45 +78,277 @@ This Cloud Function will read the incoming finding, if it's an ETD anomalous IAM
 identicating an external member was invited to policy check to see if the external member
 is in a list of disallowed domains.
 
-Additionally check to see if the affected project is in the specified folder. If the grant
+Additionally check to see if each project, folder or organization the grant was made on is
+in the specified folder, or is the specified organization or within it. If the grant
 was to a domain explicitly disallowed and within the folder then remove the member from the
-entire IAM policy for the resource.
+roles it was granted. If the finding doesn't say which roles were granted the member is removed
+from every role. With RevokeDomains every member of a disallowed domain is removed from the
+entire IAM policy instead, and with RevokeAllowlist every member outside of the allowed domains.
+Findings below the minimum severity and priority are logged and
+otherwise ignored, as are findings already handled within the deduplication window.
+
+Cloud Audit Log SetIamPolicy entries are handled the same way without waiting on ETD. Entries
+that don't grant a role to a member of a disallowed domain are ignored.
 
-TODO:
-  - Disallowed email list should be an argument.
-  - We currently remove any member that matches the disallowed emails. May want to only remove
-        if they are explicitly found from a detector. Currently we'll remove an existing member
-        that may not be intended.
+When a journal store is given every policy is recorded in it before it's changed, user.Restore
+re-applies the removed members from an entry.
 */
-func RevokeExternalGrants(ctx context.Context, m pubsub.Message, c clients.ClientInt, folderIDs []string, disallowed []string) error {
+func RevokeExternalGrants(ctx context.Context, m pubsub.Message, c clients.ClientInt, o RevokeExternalGrantsOptions) error {
         f := finding.NewFinding()
-
         if err := f.ReadFinding(&m); err != nil {
                 return fmt.Errorf("failed to read finding: %q", err)
         }
+        return revokeExternalGrants(ctx, c, f, o)
+}
+
+// RevokeExternalGrantsHandler returns a Handler revoking external grants with the settings.
+func RevokeExternalGrantsHandler(o RevokeExternalGrantsOptions) Handler {
+        return func(ctx context.Context, c clients.ClientInt, f *finding.Finding) error {
+                return revokeExternalGrants(ctx, c, f, o)
+        }
+}
+
+// revokeExternalGrants responds to the parsed finding, see RevokeExternalGrants.
+func revokeExternalGrants(ctx context.Context, c clients.ClientInt, f *finding.Finding, o RevokeExternalGrantsOptions) error {
+        var allow *user.Matcher
+        if o.Mode == RevokeAllowlist {
+                m, err := user.AllowDomains(o.Allowed...)
+                if err != nil {
+                        return fmt.Errorf("failed to build allowlist: %q", err)
+                }
+                allow = m.WithSubdomains()
+        }
+
+        switch f.MethodName() {
+        case "":
+                if eu := f.ExternalUsers(); len(eu) == 0 {
+                        return fmt.Errorf("no external users")
+                }
+        case finding.MethodSetIamPolicy:
+                if rm := revokedMembers(f.AddedMembers(), o.Disallowed, allow); len(rm) == 0 {
+                        log.Printf("skipping audit log %q without disallowed members", f.InsertID())
+                        return nil
+                }
+        default:
+                log.Printf("skipping audit log %q for method %s", f.InsertID(), f.MethodName())
+                return nil
+        }
+
+        if ok, err := guard(revokeAction, f, o.Threshold, o.Dedup); !ok {
+                return err
+        }
+
+        if ok, err := revokeGrants(c, f, o, allow); !ok || err != nil {
+                return err
+        }
+
+        return record(revokeAction, f, o.Dedup)
+}
+
+// revokeGrants removes the members of the finding's grants the options select from every
+// project, folder and organization within the options' folders the grant was made on. It
+// returns false if the finding grants nothing to remove.
+func revokeGrants(c clients.ClientInt, f *finding.Finding, o RevokeExternalGrantsOptions, allow *user.Matcher) (bool, error) {
+        remove := func(u *user.User, r finding.Resource) (*user.Result, error) {
+                var res *user.Result
+                var err error
+                switch r.Type {
+                case "folders":
+                        res, err = u.RemoveDomainsFolder(r.Name, o.Disallowed)
+                case "organizations":
+                        res, err = u.RemoveDomainsOrganization(r.Name, o.Disallowed)
+                default:
+                        res, err = u.RemoveDomainsProject(r.Name, o.Disallowed)
+                }
+                if err != nil {
+                        return nil, fmt.Errorf("failed to remove disallowed domains: %q", err)
+                }
+                return res, nil
+        }
+        if allow != nil {
+                remove = func(u *user.User, r finding.Resource) (*user.Result, error) {
+                        var res *user.Result
+                        var err error
+                        switch r.Type {
+                        case "folders":
+                                res, err = u.RemoveMatchingFolder(r.Name, allow)
+                        case "organizations":
+                                res, err = u.RemoveMatchingOrganization(r.Name, allow)
+                        default:
+                                res, err = u.RemoveMatchingProject(r.Name, allow)
+                        }
+                        if err != nil {
+                                return nil, fmt.Errorf("failed to remove members outside of the allowlist: %q", err)
+                        }
+                        return res, nil
+                }
+        }
+        if o.Mode == RevokeFlagged {
+                grants := flaggedGrants(f, o.Disallowed)
+                if len(grants) == 0 {
+                        log.Printf("skipping %s finding %q without disallowed members", f.RuleName(), f.InsertID())
+                        return false, nil
+                }
+                remove = func(u *user.User, r finding.Resource) (*user.Result, error) {
+                        var res *user.Result
+                        var err error
+                        switch r.Type {
+                        case "folders":
+                                res, err = u.RemoveGrantsFolder(r.Name, grants)
+                        case "organizations":
+                                res, err = u.RemoveGrantsOrganization(r.Name, grants)
+                        default:
+                                res, err = u.RemoveGrantsProject(r.Name, grants)
+                        }
+                        if err != nil {
+                                return nil, fmt.Errorf("failed to remove flagged members: %q", err)
+                        }
+                        return res, nil
+                }
+        }
 
-        if eu := f.ExternalUsers(); len(eu) == 0 {
-                return fmt.Errorf("no external users")
+        u := user.NewUser(c).Journaled(o.Journal, f.InsertID())
+        var errs []string
+        for _, r := range grantResources(f) {
+                if err := revokeResource(c, u, r, o.FolderIDs, remove); err != nil {
+                        errs = append(errs, err.Error())
+                }
+        }
+        if len(errs) > 0 {
+                return true, fmt.Errorf("failed to revoke grants: %s", strings.Join(errs, "; "))
         }
+        return true, nil
+}
 
-        ancestors, err := c.GetProjectAncestry(f.ProjectID())
+// grantResources returns the projects, folders and organizations the grant was made on.
+func grantResources(f *finding.Finding) []finding.Resource {
+        rs := []finding.Resource{}
+        seen := map[string]bool{}
+        for _, r := range f.AffectedResources() {
+                switch r.Type {
+                case "projects", "folders", "organizations":
+                default:
+                        continue
+                }
+                if seen[r.Type+"/"+r.Name] {
+                        continue
+                }
+                seen[r.Type+"/"+r.Name] = true
+                rs = append(rs, r)
+        }
+        if len(rs) == 0 && f.ProjectID() != "" {
+                rs = append(rs, finding.Resource{Type: "projects", Project: f.ProjectID(), Name: f.ProjectID()})
+        }
+        return rs
+}
+
+// disallowedMembers returns the members that belong to one of the disallowed domains.
+func disallowedMembers(members []string, disallowed []string) []string {
+        dm := []string{}
+        for _, m := range members {
+                if user.InDomains(m, disallowed) {
+                        dm = append(dm, m)
+                }
+        }
+        return dm
+}
+
+// revokedMembers returns the members the allowlist matches, or those of the disallowed domains
+// without an allowlist.
+func revokedMembers(members []string, disallowed []string, allow *user.Matcher) []string {
+        if allow == nil {
+                return disallowedMembers(members, disallowed)
+        }
+        rm := []string{}
+        for _, m := range members {
+                if allow.Match(m) {
+                        rm = append(rm, m)
+                }
+        }
+        return rm
+}
+
+// flaggedGrants returns the grants the finding reports were made to members of disallowed domains.
+//
+// Members the finding doesn't report roles for are removed from every role.
+func flaggedGrants(f *finding.Finding, disallowed []string) []user.Grant {
+        members := f.ExternalUsers()
+        if f.MethodName() != "" {
+                members = f.AddedMembers()
+        }
+        grants := []user.Grant{}
+        for _, m := range disallowedMembers(members, disallowed) {
+                granted := false
+                for _, d := range f.BindingDeltas() {
+                        if d.Action != finding.DeltaAdd || d.Member != m {
+                                continue
+                        }
+                        g := user.Grant{Member: m, Role: d.Role}
+                        // Only the conditional grant is removed, the member may hold the role otherwise.
+                        if d.Condition != nil {
+                                g.Condition = d.Condition.Expression
+                        }
+                        grants = append(grants, g)
+                        granted = true
+                }
+                if !granted {
+                        grants = append(grants, user.Grant{Member: m})
+                }
+        }
+        return grants
+}
+
+// revokeResource removes the members from the resource if it's within one of the folders.
+//
+// Scopes are folder IDs, or resource names such as "organizations/154584661726" to cover an
+// entire organization including grants made on the organization itself.
+func revokeResource(c clients.ClientInt, u *user.User, r finding.Resource, folderIDs []string, remove func(*user.User, finding.Resource) (*user.Result, error)) error {
+        ancestors, err := resourceAncestry(c, r)
         if err != nil {
-                return fmt.Errorf("failed to get project ancestry: %q", err)
+                return err
         }
 
         for _, resource := range ancestors {
                 for _, folderID := range folderIDs {
-                        if resource != "folders/"+folderID {
+                        scope := folderID
+                        if !strings.Contains(scope, "/") {
+                                scope = "folders/" + scope
+                        }
+                        if resource != scope {
                                 continue
                         }
 
-                        r := user.NewUser(c)
-                        _, err = r.RemoveDomainsProject(f.ProjectID(), disallowed)
+                        res, err := remove(u, r)
                         if err != nil {
-                                return fmt.Errorf("failed to remove disallowed domains: %q", err)
+                                return err
+                        }
+                        if res.JournalID != "" {
+                                log.Printf("revoked grants on %s/%s in %d attempts, journal entry %s: %s", r.Type, r.Name, res.Attempts, res.JournalID, res.Removed)
+                                return nil
                         }
+                        log.Printf("revoked grants on %s/%s in %d attempts: %s", r.Type, r.Name, res.Attempts, res.Removed)
                         return nil
                 }
         }
         return nil
+}
+
+// resourceAncestry returns the resource followed by the folders and organization above it.
+func resourceAncestry(c clients.ClientInt, r finding.Resource) ([]string, error) {
+        switch r.Type {
+        case "folders":
+                ancestors, err := c.GetFolderAncestry(r.Name)
+                if err != nil {
+                        return nil, fmt.Errorf("failed to get folder ancestry: %q", err)
+                }
+                return ancestors, nil
+        case "organizations":
+                return []string{"organizations/" + r.Name}, nil
+        default:
+                ancestors, err := c.GetProjectAncestry(r.Name)
+                if err != nil {
+                        return nil, fmt.Errorf("failed to get project ancestry: %q", err)
+                }
+                return ancestors, nil
+        }
 }
\ No newline at end of file
@@ -19,16 +19,23 @@ package actions
 
 import (
         "automation/clients"
+        "automation/dedup"
+        "automation/finding"
+        "bytes"
         "context"
         "errors"
         "reflect"
         "testing"
+        "time"
 
         "cloud.google.com/go/pubsub"
         "github.com/kylelemons/godebug/pretty"
         crm "google.golang.org/api/cloudresourcemanager/v1"
 )
 
+// grantProject is the project the grants of the sample findings are made on.
+const grantProject = "test-project-1-246321"
+
 func TestRevokeExternalGrants(t *testing.T) {
         ctx := context.Background()
 
@@ -47,10 +54,14 @@ func TestRevokeExternalGrants(t *testing.T) {
                 expectedMembers []string
                 // Incoming project's ancestry.
                 ancestry []string
+                // Minimum severity and priority of the finding.
+                threshold finding.Threshold
+                // Which members are removed.
+                mode RevokeMode
         }{
                 {
                         name:            "invalid finding",
-                        expectedError:   errors.New(`failed to read finding: "failed to unmarshal"`),
+                        expectedError:   errors.New(`failed to read finding: "failed to unmarshal: unexpected end of JSON input"`),
                         incomingLog:     pubsub.Message{},
                         initialMembers:  nil,
                         folderID:        []string{""},
@@ -78,6 +89,16 @@ func TestRevokeExternalGrants(t *testing.T) {
                         expectedMembers: []string{"user:test@test.com"},
                         ancestry:        []string{"projects/projectID", "folders/folderID", "organizations/organizationID"},
                 },
+                {
+                        name:            "remove new gmail user from scc notification",
+                        expectedError:   nil,
+                        incomingLog:     createSCCMessage("user:tom@gmail.com"),
+                        initialMembers:  []string{"user:test@test.com", "user:tom@gmail.com"},
+                        folderID:        []string{"folderID"},
+                        disallowed:      []string{"andrew.cmu.edu", "gmail.com"},
+                        expectedMembers: []string{"user:test@test.com"},
+                        ancestry:        []string{"projects/projectID", "folders/folderID", "organizations/organizationID"},
+                },
                 {
                         name:            "remove new and existing gmail users",
                         expectedError:   nil,
@@ -87,6 +108,17 @@ func TestRevokeExternalGrants(t *testing.T) {
                         disallowed:      []string{"andrew.cmu.edu", "gmail.com"},
                         expectedMembers: []string{"user:test@test.com"},
                         ancestry:        []string{"projects/projectID", "folders/folderID", "organizations/organizationID"},
+                        mode:            RevokeDomains,
+                },
+                {
+                        name:            "only removes flagged gmail user",
+                        expectedError:   nil,
+                        incomingLog:     createMessage("user:tom@gmail.com"),
+                        initialMembers:  []string{"user:test@test.com", "user:tom@gmail.com", "user:existing@gmail.com"},
+                        folderID:        []string{"folderID"},
+                        disallowed:      []string{"andrew.cmu.edu", "gmail.com"},
+                        expectedMembers: []string{"user:test@test.com", "user:existing@gmail.com"},
+                        ancestry:        []string{"projects/projectID", "folders/folderID", "organizations/organizationID"},
                 },
                 {
                         name:            "domain not in disallowed list",
@@ -107,6 +139,7 @@ func TestRevokeExternalGrants(t *testing.T) {
                         disallowed:      []string{"andrew.cmu.edu", "gmail.com"},
                         expectedMembers: []string{"user:test@test.com"},
                         ancestry:        []string{"projects/projectID", "folders/folderID", "organizations/organizationID"},
+                        mode:            RevokeDomains,
                 },
                 {
                         name:            "provide multiple folders and remove gmail users",
@@ -117,6 +150,7 @@ func TestRevokeExternalGrants(t *testing.T) {
                         disallowed:      []string{"andrew.cmu.edu", "gmail.com"},
                         expectedMembers: []string{"user:test@test.com"},
                         ancestry:        []string{"projects/projectID", "folders/folderID1", "organizations/organizationID"},
+                        mode:            RevokeDomains,
                 },
                 {
                         name:            "cannot revoke in this folder",
@@ -128,29 +162,287 @@ func TestRevokeExternalGrants(t *testing.T) {
                         expectedMembers: []string{"user:test@test.com", "user:tom@gmail.com", "user:existing@gmail.com"},
                         ancestry:        []string{"projects/projectID", "folders/anotherfolderID", "organizations/organizationID"},
                 },
+                {
+                        name:            "remove gmail user added in audit log",
+                        expectedError:   nil,
+                        incomingLog:     createAuditMessage("user:tom@gmail.com"),
+                        initialMembers:  []string{"user:test@test.com", "user:tom@gmail.com"},
+                        folderID:        []string{"folderID"},
+                        disallowed:      []string{"gmail.com"},
+                        expectedMembers: []string{"user:test@test.com"},
+                        ancestry:        []string{"projects/projectID", "folders/folderID", "organizations/organizationID"},
+                },
+                {
+                        name:            "audit log without disallowed members",
+                        expectedError:   nil,
+                        incomingLog:     createAuditMessage("user:tom@foo.com"),
+                        initialMembers:  []string{"user:test@test.com", "user:tom@gmail.com"},
+                        folderID:        []string{"folderID"},
+                        disallowed:      []string{"gmail.com"},
+                        expectedMembers: nil,
+                        ancestry:        []string{"projects/projectID", "folders/folderID", "organizations/organizationID"},
+                },
+                {
+                        name:            "finding below threshold and doesn't remove members",
+                        expectedError:   nil,
+                        incomingLog:     createMessage("user:tom@gmail.com"),
+                        initialMembers:  []string{"user:test@test.com", "user:tom@gmail.com"},
+                        folderID:        []string{"folderID"},
+                        disallowed:      []string{"gmail.com"},
+                        expectedMembers: []string{"user:test@test.com", "user:tom@gmail.com"},
+                        ancestry:        []string{"projects/projectID", "folders/folderID", "organizations/organizationID"},
+                        threshold:       finding.Threshold{Severity: finding.SeverityCritical},
+                },
         }
         for _, tt := range test {
                 t.Run(tt.name, func(t *testing.T) {
                         mock := &clients.MockClients{}
                         mock.AddGetPolicyFake(createPolicy(tt.initialMembers))
                         mock.AddGetProjectAncestryFake(tt.ancestry)
-                        if err := RevokeExternalGrants(ctx, tt.incomingLog, mock, tt.folderID, tt.disallowed); !reflect.DeepEqual(err, tt.expectedError) {
+                        if err := RevokeExternalGrants(ctx, tt.incomingLog, mock, RevokeExternalGrantsOptions{FolderIDs: tt.folderID, Disallowed: tt.disallowed, Mode: tt.mode, Threshold: tt.threshold}); !reflect.DeepEqual(err, tt.expectedError) {
                                 if diff := pretty.Compare(err, tt.expectedError); diff != "" {
                                         t.Errorf("%s failed want:%q got:%q", tt.name, tt.expectedError, diff)
                                 }
                         }
 
-                        if mock.SavedSetPolicy == nil {
+                        policy, ok := mock.SavedSetPolicies[grantProject]
+                        if !ok {
                                 return
                         }
 
-                        if diff := pretty.Compare(mock.SavedSetPolicy.Bindings, createPolicy(tt.expectedMembers)); diff != "" {
+                        if diff := pretty.Compare(policy.Bindings, createPolicy(tt.expectedMembers)); diff != "" {
+                                t.Errorf("%s failed got:%q", tt.name, diff)
+                        }
+                })
+        }
+}
+
+func TestRevokeExternalGrantsMultipleProjects(t *testing.T) {
+        ctx := context.Background()
+        mock := &clients.MockClients{}
+        mock.AddGetPolicyFake(createPolicy([]string{"user:test@test.com", "user:tom@gmail.com"}))
+        mock.AddGetProjectAncestryFake([]string{"projects/projectID", "folders/folderID", "organizations/organizationID"})
+        m := pubsub.Message{Data: []byte(`{
+                "jsonPayload": {
+                        "detectionCategory": {
+                                "subRuleName": "external_member_added_to_policy",
+                                "ruleName": "iam_anomalous_grant"
+                        },
+                        "affectedResources":[
+                                {"gcpResourceName": "//cloudresourcemanager.googleapis.com/projects/project-1"},
+                                {"gcpResourceName": "//cloudresourcemanager.googleapis.com/projects/project-2"}
+                        ],
+                        "properties": {"externalMembers": ["user:tom@gmail.com"]}
+                },
+                "logName": "projects/carise-etdeng-joonix/logs/threatdetection.googleapis.com%2Fdetection"
+        }`)}
+
+        if err := RevokeExternalGrants(ctx, m, mock, RevokeExternalGrantsOptions{FolderIDs: []string{"folderID"}, Disallowed: []string{"gmail.com"}}); err != nil {
+                t.Fatalf("failed to revoke grants: %q", err)
+        }
+        for _, p := range []string{"project-1", "project-2"} {
+                policy, ok := mock.SavedSetPolicies[p]
+                if !ok {
+                        t.Errorf("policy not set for %q", p)
+                        continue
+                }
+                if diff := pretty.Compare(policy.Bindings, createPolicy([]string{"user:test@test.com"})); diff != "" {
+                        t.Errorf("%s failed got:%q", p, diff)
+                }
+        }
+}
+
+func TestRevokeExternalGrantsDryRun(t *testing.T) {
+        ctx := context.Background()
+        mock := clients.NewMockClients()
+        mock.AddGetPolicyFake(createPolicy([]string{"user:tom@gmail.com", "user:test@test.com"}))
+        mock.AddGetProjectAncestryFake([]string{"projects/test-project", "folders/folderID"})
+        dry := clients.NewDryRun(mock)
+
+        if err := RevokeExternalGrants(ctx, createMessage("user:tom@gmail.com"), dry, RevokeExternalGrantsOptions{FolderIDs: []string{"folderID"}, Disallowed: []string{"gmail.com"}}); err != nil {
+                t.Fatalf("failed to plan revoke: %q", err)
+        }
+        if len(mock.SavedSetPolicies) != 0 {
+                t.Errorf("failed dry run set policy: %+v", mock.SavedSetPolicies)
+        }
+        plan := dry.Plan()
+        if len(plan) != 1 || plan[0].Operation != "setIamPolicy" {
+                t.Fatalf("failed got plan:%+v", plan)
+        }
+        if diff := pretty.Compare(plan[0].Before.(*crm.Policy).Bindings, createPolicy([]string{"user:tom@gmail.com", "user:test@test.com"})); diff != "" {
+                t.Errorf("failed before got:%q", diff)
+        }
+        if diff := pretty.Compare(plan[0].After.(*crm.Policy).Bindings, createPolicy([]string{"user:test@test.com"})); diff != "" {
+                t.Errorf("failed after got:%q", diff)
+        }
+}
+
+func TestRevokeExternalGrantsGrantedRoles(t *testing.T) {
+        ctx := context.Background()
+        initial := []*crm.Binding{
+                {Role: "roles/editor", Members: []string{"user:test@test.com", "user:tom@gmail.com"}},
+                {Role: "roles/viewer", Members: []string{"serviceAccount:service-1@compute-system.iam.gserviceaccount.com", "user:tom@gmail.com"}},
+        }
+        test := []struct {
+                name     string
+                mode     RevokeMode
+                expected []*crm.Binding
+        }{
+                {
+                        name: "removes flagged member from granted role",
+                        mode: RevokeFlagged,
+                        expected: []*crm.Binding{
+                                {Role: "roles/editor", Members: []string{"user:test@test.com"}},
+                                {Role: "roles/viewer", Members: []string{"serviceAccount:service-1@compute-system.iam.gserviceaccount.com", "user:tom@gmail.com"}},
+                        },
+                },
+                {
+                        name: "removes domain from every role",
+                        mode: RevokeDomains,
+                        expected: []*crm.Binding{
+                                {Role: "roles/editor", Members: []string{"user:test@test.com"}},
+                                {Role: "roles/viewer", Members: []string{"serviceAccount:service-1@compute-system.iam.gserviceaccount.com"}},
+                        },
+                },
+                {
+                        name: "removes members outside of the allowlist but keeps service agents",
+                        mode: RevokeAllowlist,
+                        expected: []*crm.Binding{
+                                {Role: "roles/editor", Members: []string{"user:test@test.com"}},
+                                {Role: "roles/viewer", Members: []string{"serviceAccount:service-1@compute-system.iam.gserviceaccount.com"}},
+                        },
+                },
+        }
+        for _, tt := range test {
+                t.Run(tt.name, func(t *testing.T) {
+                        mock := &clients.MockClients{}
+                        bindings := []*crm.Binding{}
+                        for _, b := range initial {
+                                bindings = append(bindings, &crm.Binding{Role: b.Role, Members: append([]string{}, b.Members...)})
+                        }
+                        mock.AddGetPolicyFake(bindings)
+                        mock.AddGetProjectAncestryFake([]string{"projects/projectID", "folders/folderID", "organizations/organizationID"})
+                        if err := RevokeExternalGrants(ctx, createAuditMessage("user:tom@gmail.com"), mock, RevokeExternalGrantsOptions{FolderIDs: []string{"folderID"}, Disallowed: []string{"gmail.com"}, Allowed: []string{"test.com"}, Mode: tt.mode}); err != nil {
+                                t.Fatalf("%s failed: %q", tt.name, err)
+                        }
+                        if diff := pretty.Compare(mock.SavedSetPolicies[grantProject].Bindings, tt.expected); diff != "" {
+                                t.Errorf("%s failed got:%q", tt.name, diff)
+                        }
+                })
+        }
+}
+
+func TestRevokeExternalGrantsResourceLevels(t *testing.T) {
+        ctx := context.Background()
+        test := []struct {
+                name         string
+                resourceName string
+                scopes       []string
+                ancestry     []string
+                // Resource expected to have its policy set, empty if none.
+                expected string
+        }{
+                {
+                        name:         "grant on folder",
+                        resourceName: "folders/111",
+                        scopes:       []string{"folderID"},
+                        ancestry:     []string{"folders/111", "folders/folderID", "organizations/organizationID"},
+                        expected:     "folders/111",
+                },
+                {
+                        name:         "grant on folder outside scope",
+                        resourceName: "folders/111",
+                        scopes:       []string{"folderID"},
+                        ancestry:     []string{"folders/111", "organizations/organizationID"},
+                },
+                {
+                        name:         "grant on organization",
+                        resourceName: "organizations/organizationID",
+                        scopes:       []string{"organizations/organizationID"},
+                        expected:     "organizations/organizationID",
+                },
+                {
+                        name:         "grant on organization with folder scope",
+                        resourceName: "organizations/organizationID",
+                        scopes:       []string{"folderID"},
+                },
+                {
+                        name:         "grant on project with organization scope",
+                        resourceName: "projects/projectID",
+                        scopes:       []string{"organizations/organizationID"},
+                        ancestry:     []string{"projects/projectID", "folders/folderID", "organizations/organizationID"},
+                        expected:     "projectID",
+                },
+        }
+        for _, tt := range test {
+                t.Run(tt.name, func(t *testing.T) {
+                        mock := &clients.MockClients{}
+                        mock.AddGetPolicyFake(createPolicy([]string{"user:test@test.com", "user:tom@gmail.com"}))
+                        mock.AddGetProjectAncestryFake(tt.ancestry)
+                        m := createAuditMessageOn(tt.resourceName, "user:tom@gmail.com")
+                        if err := RevokeExternalGrants(ctx, m, mock, RevokeExternalGrantsOptions{FolderIDs: tt.scopes, Disallowed: []string{"gmail.com"}}); err != nil {
+                                t.Fatalf("%s failed: %q", tt.name, err)
+                        }
+                        if tt.expected == "" {
+                                if len(mock.SavedSetPolicies) != 0 {
+                                        t.Errorf("%s failed policies set: %v", tt.name, mock.SavedSetPolicies)
+                                }
+                                return
+                        }
+                        policy, ok := mock.SavedSetPolicies[tt.expected]
+                        if !ok {
+                                t.Fatalf("%s failed policy not set for %q", tt.name, tt.expected)
+                        }
+                        if diff := pretty.Compare(policy.Bindings, createPolicy([]string{"user:test@test.com"})); diff != "" {
                                 t.Errorf("%s failed got:%q", tt.name, diff)
                         }
                 })
         }
 }
 
+func TestRevokeExternalGrantsConditionalGrant(t *testing.T) {
+        ctx := context.Background()
+        condition := &crm.Expr{Title: "expires", Expression: `request.time < timestamp("2020-01-01T00:00:00Z")`}
+        mock := &clients.MockClients{}
+        mock.AddGetPolicyFake([]*crm.Binding{
+                {Role: "roles/editor", Members: []string{"user:tom@gmail.com"}},
+                {Role: "roles/editor", Members: []string{"user:tom@gmail.com"}, Condition: condition},
+        })
+        mock.AddGetProjectAncestryFake([]string{"projects/projectID", "folders/folderID", "organizations/organizationID"})
+        m := pubsub.Message{Data: []byte(`{
+                "insertId": "-xyz123",
+                "logName": "projects/test-project-1-246321/logs/cloudaudit.googleapis.com%2Factivity",
+                "protoPayload": {
+                        "methodName": "SetIamPolicy",
+                        "resourceName": "projects/test-project-1-246321",
+                        "serviceName": "cloudresourcemanager.googleapis.com",
+                        "serviceData": {
+                                "policyDelta": {
+                                        "bindingDeltas": [{
+                                                "action": "ADD",
+                                                "role": "roles/editor",
+                                                "member": "user:tom@gmail.com",
+                                                "condition": {"title": "expires", "expression": "request.time < timestamp(\"2020-01-01T00:00:00Z\")"}
+                                        }]
+                                }
+                        }
+                }
+        }`)}
+        if err := RevokeExternalGrants(ctx, m, mock, RevokeExternalGrantsOptions{FolderIDs: []string{"folderID"}, Disallowed: []string{"gmail.com"}}); err != nil {
+                t.Fatalf("failed to revoke grants: %q", err)
+        }
+        expected := []*crm.Binding{
+                {Role: "roles/editor", Members: []string{"user:tom@gmail.com"}},
+        }
+        policy := mock.SavedSetPolicies[grantProject]
+        if diff := pretty.Compare(policy.Bindings, expected); diff != "" {
+                t.Errorf("failed got:%q", diff)
+        }
+        if policy.Version != 3 {
+                t.Errorf("failed version got:%d want:3", policy.Version)
+        }
+}
+
 func createPolicy(members []string) []*crm.Binding {
         return []*crm.Binding{
                 {
@@ -179,4 +471,80 @@ func createMessage(member string) pubsub.Message {
                 },
                 "logName": "projects/carise-etdeng-joonix/logs/threatdetection.googleapis.com%2Fdetection"
         }`)}
+}
+
+func createSCCMessage(member string) pubsub.Message {
+        return pubsub.Message{Data: []byte(`{
+                "notificationConfigName": "organizations/154584661726/notificationConfigs/threat-findings",
+                "finding": {
+                        "name": "organizations/154584661726/sources/2299436883026055247/findings/f1",
+                        "resourceName": "//cloudresourcemanager.googleapis.com/projects/test-project-1-246321",
+                        "state": "ACTIVE",
+                        "category": "iam_anomalous_grant",
+                        "sourceProperties": {
+                                "detectionCategory_subRuleName": "external_member_added_to_policy",
+                                "properties_externalMembers": ["` + member + `"]
+                        }
+                }
+        }`)}
+}
+
+func createAuditMessage(member string) pubsub.Message {
+        return createAuditMessageOn("projects/test-project-1-246321", member)
+}
+
+func createAuditMessageOn(resourceName string, member string) pubsub.Message {
+        return pubsub.Message{Data: []byte(`{
+                "insertId": "-xyz123",
+                "logName": "projects/test-project-1-246321/logs/cloudaudit.googleapis.com%2Factivity",
+                "protoPayload": {
+                        "authenticationInfo": {"principalEmail": "admin@test.com"},
+                        "methodName": "SetIamPolicy",
+                        "resourceName": "` + resourceName + `",
+                        "serviceName": "cloudresourcemanager.googleapis.com",
+                        "serviceData": {
+                                "policyDelta": {
+                                        "bindingDeltas": [{"action": "ADD", "role": "roles/editor", "member": "` + member + `"}]
+                                }
+                        }
+                },
+                "resource": {"type": "project", "labels": {"project_id": "test-project-1-246321"}},
+                "severity": "NOTICE",
+                "timestamp": "2019-07-16T21:00:44.760Z"
+        }`)}
+}
+
+func TestRevokeExternalGrantsDedupGrants(t *testing.T) {
+        ctx := context.Background()
+        mock := &clients.MockClients{}
+        mock.AddGetProjectAncestryFake([]string{"projects/projectID", "folders/folderID", "organizations/organizationID"})
+        o := RevokeExternalGrantsOptions{FolderIDs: []string{"folderID"}, Disallowed: []string{"gmail.com"}, Dedup: dedup.New(dedup.NewMemoryStore(), time.Hour)}
+
+        mock.AddGetPolicyFake([]*crm.Binding{{Role: "roles/editor", Members: []string{"user:test@test.com", "user:tom@gmail.com"}}})
+        if err := RevokeExternalGrants(ctx, createAuditMessage("user:tom@gmail.com"), mock, o); err != nil {
+                t.Fatalf("failed to revoke first grant: %q", err)
+        }
+        mock.AddGetPolicyFake([]*crm.Binding{{Role: "roles/editor", Members: []string{"user:test@test.com", "user:eve@gmail.com"}}})
+        mock.SavedSetPolicies = nil
+        m := createAuditMessage("user:eve@gmail.com")
+        m.Data = bytes.Replace(m.Data, []byte("-xyz123"), []byte("-other"), 1)
+        if err := RevokeExternalGrants(ctx, m, mock, o); err != nil {
+                t.Fatalf("failed to revoke second grant: %q", err)
+        }
+        p, ok := mock.SavedSetPolicies[grantProject]
+        if !ok {
+                t.Fatalf("second grant on the project wasn't revoked")
+        }
+        expected := []*crm.Binding{{Role: "roles/editor", Members: []string{"user:test@test.com"}}}
+        if diff := pretty.Compare(p.Bindings, expected); diff != "" {
+                t.Errorf("failed got:%q", diff)
+        }
+}
+
+func TestRevokeExternalGrantsEmptyAllowlist(t *testing.T) {
+        mock := &clients.MockClients{}
+        err := RevokeExternalGrants(context.Background(), createAuditMessage("user:tom@gmail.com"), mock, RevokeExternalGrantsOptions{FolderIDs: []string{"folderID"}, Mode: RevokeAllowlist})
+        if want := `failed to build allowlist: "no approved domains"`; err == nil || err.Error() != want {
+                t.Errorf("failed got:%v want:%q", err, want)
+        }
 }
\ No newline at end of file
@@ -0,0 +1,108 @@
+/*
+Package actions provides the implementation of automated actions.
+
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+        https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package actions
+
+import (
+        "automation/clients"
+        "automation/dedup"
+        "automation/finding"
+        "automation/playbook"
+
+        "context"
+        "fmt"
+        "log"
+        "strings"
+
+        "cloud.google.com/go/pubsub"
+)
+
+// playbookAction prefixes the name findings are recorded under once a playbook handled
+// them, for example "run-playbooks/snapshot".
+const playbookAction = "run-playbooks"
+
+// RunPlaybooksOptions are the settings of RunPlaybooks.
+type RunPlaybooksOptions struct {
+        // Playbooks are the playbooks findings are matched against.
+        Playbooks []*playbook.Playbook
+        // Steps are the steps the playbooks were loaded with, see PlaybookSteps.
+        Steps playbook.Steps
+        // Dedup skips findings already handled within its window, nil to never skip them.
+        Dedup *dedup.Deduplicator
+}
+
+// RunPlaybooks runs the steps of every playbook matching the finding.
+//
+// Findings are validated and deduplicated only for the playbooks they match, an invalid
+// finding fails those playbooks alone. Each playbook skips the findings it already handled
+// within the deduplication window, a finding is recorded as handled by a playbook once the
+// playbook ran without failing. A playbook failing doesn't stop the others, its steps are
+// retried on redelivery while the playbooks that succeeded are skipped.
+func RunPlaybooks(ctx context.Context, m pubsub.Message, c clients.ClientInt, o RunPlaybooksOptions) error {
+        f := finding.NewFinding()
+        if err := f.ReadFinding(&m); err != nil {
+                return fmt.Errorf("failed to read finding: %q", err)
+        }
+        return runPlaybooks(ctx, c, f, o)
+}
+
+// RunPlaybooksHandler returns a Handler running the matching playbooks with the settings.
+func RunPlaybooksHandler(o RunPlaybooksOptions) Handler {
+        return func(ctx context.Context, c clients.ClientInt, f *finding.Finding) error {
+                return runPlaybooks(ctx, c, f, o)
+        }
+}
+
+// runPlaybooks responds to the parsed finding, see RunPlaybooks.
+func runPlaybooks(ctx context.Context, c clients.ClientInt, f *finding.Finding, o RunPlaybooksOptions) error {
+        e := playbook.NewEngine(c, o.Steps)
+        var ran, errs []string
+        for _, p := range o.Playbooks {
+                matched, err := e.Matches(p, f)
+                if err != nil {
+                        errs = append(errs, fmt.Sprintf("%s: %s", p.Name, err))
+                        continue
+                }
+                if !matched {
+                        continue
+                }
+                action := playbookAction + "/" + p.Name
+                ok, err := guard(action, f, finding.Threshold{}, o.Dedup)
+                if err != nil {
+                        errs = append(errs, fmt.Sprintf("%s: %s", p.Name, err))
+                        continue
+                }
+                if !ok {
+                        continue
+                }
+                if err := e.RunPlaybook(ctx, p, f); err != nil {
+                        errs = append(errs, fmt.Sprintf("%s: %s", p.Name, err))
+                        continue
+                }
+                ran = append(ran, p.Name)
+                if err := record(action, f, o.Dedup); err != nil {
+                        errs = append(errs, fmt.Sprintf("%s: %s", p.Name, err))
+                }
+        }
+        if len(ran) > 0 {
+                log.Printf("ran playbooks %q for %s finding %q", ran, f.RuleName(), f.InsertID())
+        }
+        if len(errs) > 0 {
+                return fmt.Errorf("failed to run playbooks: %s", strings.Join(errs, "; "))
+        }
+        return nil
+}
\ No newline at end of file
@@ -0,0 +1,227 @@
+/*
+Package actions provides the implementation of automated actions.
+
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+        https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package actions
+
+import (
+        "automation/clients"
+        "automation/dedup"
+        "automation/finding"
+        "automation/journal"
+        "automation/playbook"
+        "context"
+        "errors"
+        "io/ioutil"
+        "os"
+        "path/filepath"
+        "reflect"
+        "testing"
+        "time"
+
+        "cloud.google.com/go/pubsub"
+        crm "google.golang.org/api/cloudresourcemanager/v1"
+        cs "google.golang.org/api/compute/v1"
+)
+
+func TestRunPlaybooks(t *testing.T) {
+        ctx := context.Background()
+        steps := PlaybookSteps(PlaybookStepsOptions{})
+        pbs, err := playbook.Load([]byte(`{"playbooks": [
+                {"name": "close", "match": {"rules": ["OPEN_FIREWALL"]}, "steps": [{"action": "disable-firewall"}]}
+        ]}`), steps)
+        if err != nil {
+                t.Fatalf("failed to load playbooks: %q", err)
+        }
+        d := dedup.New(dedup.NewMemoryStore(), time.Hour)
+        m := createHealthMessage(finding.CategoryOpenFirewall, "//compute.googleapis.com/projects/test-project/global/firewalls/default-allow-ssh")
+        for i, exp := range []int{1, 0} {
+                mock := clients.NewMockClients()
+                if err := RunPlaybooks(ctx, m, mock, RunPlaybooksOptions{Playbooks: pbs, Steps: steps, Dedup: d}); err != nil {
+                        t.Fatalf("run %d failed: %q", i, err)
+                }
+                if got := len(mock.SavedFirewallRules); got != exp {
+                        t.Errorf("run %d failed got:%d want:%d", i, got, exp)
+                }
+        }
+}
+
+func TestRunPlaybooksDedupPerPlaybook(t *testing.T) {
+        ctx := context.Background()
+        flakyRuns := 0
+        steps := PlaybookSteps(PlaybookStepsOptions{})
+        steps["flaky"] = func(context.Context, clients.ClientInt, *finding.Finding, playbook.Params) error {
+                flakyRuns++
+                return errors.New("step failed")
+        }
+        pbs, err := playbook.Load([]byte(`{"playbooks": [
+                {"name": "close", "match": {"rules": ["OPEN_FIREWALL"]}, "steps": [{"action": "disable-firewall"}]},
+                {"name": "flaky", "steps": [{"action": "flaky"}]}
+        ]}`), steps)
+        if err != nil {
+                t.Fatalf("failed to load playbooks: %q", err)
+        }
+        d := dedup.New(dedup.NewMemoryStore(), time.Hour)
+        m := createHealthMessage(finding.CategoryOpenFirewall, "//compute.googleapis.com/projects/test-project/global/firewalls/default-allow-ssh")
+        exp := `failed to run playbooks: flaky: step 0 flaky failed: "step failed"`
+        for i, rules := range []int{1, 0} {
+                mock := clients.NewMockClients()
+                if err := RunPlaybooks(ctx, m, mock, RunPlaybooksOptions{Playbooks: pbs, Steps: steps, Dedup: d}); err == nil || err.Error() != exp {
+                        t.Errorf("run %d failed got:%v want:%q", i, err, exp)
+                }
+                if got := len(mock.SavedFirewallRules); got != rules {
+                        t.Errorf("run %d failed rules got:%d want:%d", i, got, rules)
+                }
+        }
+        if flakyRuns != 2 {
+                t.Errorf("failed flaky runs got:%d want:2", flakyRuns)
+        }
+        f := finding.NewFinding()
+        if err := f.ReadFinding(&m); err != nil {
+                t.Fatalf("failed to read finding: %q", err)
+        }
+        for action, exp := range map[string]bool{"run-playbooks/close": true, "run-playbooks/flaky": false} {
+                if seen, err := d.Seen(action, f); err != nil || seen != exp {
+                        t.Errorf("failed %s seen got:%v want:%v err:%v", action, seen, exp, err)
+                }
+        }
+}
+
+func TestRunPlaybooksInvalidFinding(t *testing.T) {
+        steps := PlaybookSteps(PlaybookStepsOptions{})
+        pbs, err := playbook.Load([]byte(`{"playbooks": [
+                {"name": "close", "match": {"rules": ["OPEN_FIREWALL"]}, "steps": [{"action": "disable-firewall"}]},
+                {"name": "capture", "match": {"rules": ["bad_ip"]}, "steps": [{"action": "snapshot-disks"}]}
+        ]}`), steps)
+        if err != nil {
+                t.Fatalf("failed to load playbooks: %q", err)
+        }
+        m := pubsub.Message{Data: []byte(`{
+                "jsonPayload": {"detectionCategory": {"ruleName": "bad_ip"}, "properties": {"ip": ["8.8.8.8"]}},
+                "logName": "projects/test-project/logs/threatdetection.googleapis.com%2Fdetection"
+        }`)}
+        exp := `failed to run playbooks: capture: invalid finding: "value not found: jsonPayload.affectedResources instance or jsonPayload.properties.sourceInstance"`
+        if err := RunPlaybooks(context.Background(), m, clients.NewMockClients(), RunPlaybooksOptions{Playbooks: pbs, Steps: steps}); err == nil || err.Error() != exp {
+                t.Errorf("failed got:%v want:%q", err, exp)
+        }
+}
+
+func TestRunPlaybooksSnapshotStep(t *testing.T) {
+        ctx := context.Background()
+        steps := PlaybookSteps(PlaybookStepsOptions{AllowSnapshotOlderThan: allowSnapshotOlderThan})
+        pbs, err := playbook.Load([]byte(`{"playbooks": [
+                {"name": "capture", "match": {"rules": ["bad_ip"]}, "steps": [{"action": "snapshot-disks"}]}
+        ]}`), steps)
+        if err != nil {
+                t.Fatalf("failed to load playbooks: %q", err)
+        }
+        tests := []struct {
+                name      string
+                snapshots []*cs.Snapshot
+                expected  int
+        }{
+                {
+                        name:     "snapshots disk",
+                        expected: 1,
+                },
+                {
+                        name: "skips disk snapshotted recently",
+                        snapshots: []*cs.Snapshot{{
+                                Name:              "forensic-snapshots-bad-ip-disk-1",
+                                SourceDisk:        "https://www.googleapis.com/compute/v1/projects/test-project/zones/test-zone/disks/disk-1",
+                                CreationTimestamp: time.Now().Format(time.RFC3339),
+                        }},
+                },
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        mock := clients.NewMockClients()
+                        mock.AddListDisksFake([]*cs.Disk{createDisk("disk-1", "instance1")})
+                        mock.AddListProjectSnapshotsFake(tt.snapshots)
+                        if err := RunPlaybooks(ctx, sampleFinding, mock, RunPlaybooksOptions{Playbooks: pbs, Steps: steps}); err != nil {
+                                t.Fatalf("%s failed: %q", tt.name, err)
+                        }
+                        if got := len(mock.SavedCreateSnapshots); got != tt.expected {
+                                t.Errorf("%s failed snapshots got:%d want:%d", tt.name, got, tt.expected)
+                        }
+                })
+        }
+}
+
+func TestRunPlaybooksRevokeSteps(t *testing.T) {
+        ctx := context.Background()
+        dir, err := ioutil.TempDir("", "journal")
+        if err != nil {
+                t.Fatalf("failed to create directory: %q", err)
+        }
+        defer os.RemoveAll(dir)
+        tests := []struct {
+                name     string
+                action   string
+                ancestry []string
+                expected []*crm.Binding
+        }{
+                {
+                        name:     "removes flagged member within folder",
+                        action:   "remove-members",
+                        ancestry: []string{"projects/" + grantProject, "folders/folderID", "organizations/organizationID"},
+                        expected: createPolicy([]string{"user:test@test.com"}),
+                },
+                {
+                        name:     "removes domain within folder",
+                        action:   "remove-domains",
+                        ancestry: []string{"projects/" + grantProject, "folders/folderID", "organizations/organizationID"},
+                        expected: createPolicy([]string{"user:test@test.com"}),
+                },
+                {
+                        name:     "skips project outside of folder",
+                        action:   "remove-domains",
+                        ancestry: []string{"projects/" + grantProject, "folders/other", "organizations/organizationID"},
+                },
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        s := journal.NewFileStore(filepath.Join(dir, tt.action))
+                        steps := PlaybookSteps(PlaybookStepsOptions{FolderIDs: []string{"folderID"}, Journal: s})
+                        pbs, err := playbook.Load([]byte(`{"playbooks": [
+                                {"name": "revoke", "steps": [{"action": "`+tt.action+`", "params": {"domains": ["gmail.com"]}}]}
+                        ]}`), steps)
+                        if err != nil {
+                                t.Fatalf("%s failed to load playbooks: %q", tt.name, err)
+                        }
+                        mock := clients.NewMockClients()
+                        mock.AddGetPolicyFake(createPolicy([]string{"user:test@test.com", "user:tom@gmail.com"}))
+                        mock.AddGetProjectAncestryFake(tt.ancestry)
+                        o := RunPlaybooksOptions{Playbooks: pbs, Steps: steps}
+                        if err := RunPlaybooks(ctx, createAuditMessage("user:tom@gmail.com"), mock, o); err != nil {
+                                t.Fatalf("%s failed: %q", tt.name, err)
+                        }
+                        p, ok := mock.SavedSetPolicies[grantProject]
+                        if tt.expected == nil {
+                                if ok {
+                                        t.Errorf("%s failed policy set outside of the folder", tt.name)
+                                }
+                                return
+                        }
+                        if !ok || !reflect.DeepEqual(p.Bindings, tt.expected) {
+                                t.Errorf("%s failed got:%+v want:%+v", tt.name, p, tt.expected)
+                        }
+                        if entries, err := s.FindByInsertID("-xyz123"); err != nil || len(entries) != 1 {
+                                t.Errorf("%s failed journal got:%+v err:%v", tt.name, entries, err)
+                        }
+                })
+        }
+}
\ No newline at end of file
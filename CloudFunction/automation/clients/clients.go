@@ -23,6 +23,7 @@ import (
         scc "cloud.google.com/go/securitycenter/apiv1beta1"
         stg "cloud.google.com/go/storage"
         crm "google.golang.org/api/cloudresourcemanager/v1"
+        crmv2 "google.golang.org/api/cloudresourcemanager/v2"
         cs "google.golang.org/api/compute/v1"
 )
 
@@ -42,9 +43,11 @@ type ClientInt interface {
 type Client struct {
         ctx context.Context
         crm *crm.Service
-        scc *scc.Client
-        cs  *cs.Service
-        stg *stg.Client
+        // crmv2 manages folders, which aren't available in v1.
+        crmv2 *crmv2.Service
+        scc   *scc.Client
+        cs    *cs.Service
+        stg   *stg.Client
 }
 
 // New returns a new instance of a client.
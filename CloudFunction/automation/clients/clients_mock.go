@@ -18,6 +18,8 @@ limitations under the License.
 package clients
 
 import (
+        "fmt"
+
         stg "cloud.google.com/go/storage"
         crm "google.golang.org/api/cloudresourcemanager/v1"
         cs "google.golang.org/api/compute/v1"
@@ -29,6 +31,11 @@ type CloudResourceManagerInt interface {
         GetPolicyProject(string) (*crm.Policy, error)
         SetPolicyProject(string, *crm.Policy) (*crm.Policy, error)
         GetProjectAncestry(string) ([]string, error)
+        GetPolicyFolder(string) (*crm.Policy, error)
+        SetPolicyFolder(string, *crm.Policy) (*crm.Policy, error)
+        GetFolderAncestry(string) ([]string, error)
+        GetPolicyOrganization(string) (*crm.Policy, error)
+        SetPolicyOrganization(string, *crm.Policy) (*crm.Policy, error)
 }
 
 // SecurityCommandCenterInt is the interface used by SCC.
@@ -43,23 +50,42 @@ type ComputeServiceInt interface {
         ListProjectSnapshots(string) (*cs.SnapshotList, error)
         ListDisks(string, string, string) (*cs.DiskList, error)
         SetLabels(string, string, *cs.GlobalSetLabelsRequest) (*cs.Operation, error)
+        GetFirewallRule(string, string) (*cs.Firewall, error)
+        InsertFirewallRule(string, *cs.Firewall) (*cs.Operation, error)
+        GetInstance(string, string, string) (*cs.Instance, error)
+        SetInstanceTags(string, string, string, *cs.Tags) (*cs.Operation, error)
+        WaitGlobalOperation(string, string) error
 }
 
 // StorageInt is the interface used by STG.
 type StorageInt interface {
         RemoveBucketUsers(string, stg.ACLEntity) error
+        ListBucketUsers(string) ([]stg.ACLRule, error)
 }
 
 // MockClients holds provides implementations of clients.
 type MockClients struct {
         fakeGetPolicyResponse    *crm.Policy
+        fakeSetPolicyErrors      []error
         fakeGetAncestryResponse  []string
         fakeListDisks            *cs.DiskList
         fakeListProjectSnapshots *cs.SnapshotList
-        SavedSetPolicy           *crm.Policy
-        SavedFirewallRule        *cs.Firewall
-        SavedRemoveBucketUsers   stg.ACLEntity
+        fakeCreateSnapshotErrors map[string]error
+        fakeListBucketUsers      []stg.ACLRule
+        fakeFirewallRules        map[string]*cs.Firewall
+        fakeFirewallRaces        map[string]*cs.Firewall
+        fakeInstances            map[string]*cs.Instance
+        fakeWaitOperationError   error
+        SavedSetPolicies         map[string]*crm.Policy
+        SavedFirewallRules       map[string]*cs.Firewall
+        SavedRemovedBucketUsers  []stg.ACLEntity
         SavedCreateSnapshots     map[string]cs.Snapshot
+        SavedInsertedFirewalls   []*cs.Firewall
+        // SavedInsertedProjects maps the name of an inserted firewall rule to its project.
+        SavedInsertedProjects map[string]string
+        // SavedWaitedOperations are the names of the operations waited for, in order.
+        SavedWaitedOperations []string
+        SavedInstanceTags     map[string]*cs.Tags
 }
 
 // NewMockClients requires a new instance of Clients.
@@ -74,6 +100,36 @@ func (m *MockClients) AddGetPolicyFake(b []*crm.Binding) {
         m.fakeGetPolicyResponse = &crm.Policy{Bindings: b}
 }
 
+// AddFirewallRuleFake adds an existing firewall rule for GetFirewallRule.
+func (m *MockClients) AddFirewallRuleFake(rule *cs.Firewall) {
+        if m.fakeFirewallRules == nil {
+                m.fakeFirewallRules = make(map[string]*cs.Firewall)
+        }
+        m.fakeFirewallRules[rule.Name] = rule
+}
+
+// AddFirewallRuleRaceFake makes the rule replace the rule of its name right after that rule
+// is next patched or inserted, as a concurrent change overwriting it would.
+func (m *MockClients) AddFirewallRuleRaceFake(rule *cs.Firewall) {
+        if m.fakeFirewallRaces == nil {
+                m.fakeFirewallRaces = make(map[string]*cs.Firewall)
+        }
+        m.fakeFirewallRaces[rule.Name] = rule
+}
+
+// AddGetInstanceFake adds an instance for GetInstance.
+func (m *MockClients) AddGetInstanceFake(instance *cs.Instance) {
+        if m.fakeInstances == nil {
+                m.fakeInstances = make(map[string]*cs.Instance)
+        }
+        m.fakeInstances[instance.Name] = instance
+}
+
+// AddSetPolicyErrorsFake adds errors returned by SetPolicy, one per call, before it succeeds.
+func (m *MockClients) AddSetPolicyErrorsFake(errs ...error) {
+        m.fakeSetPolicyErrors = errs
+}
+
 // AddGetProjectAncestryFake adds fake bindings for GetProjectAncestry.
 func (m *MockClients) AddGetProjectAncestryFake(r []string) {
         m.fakeGetAncestryResponse = r
@@ -89,15 +145,81 @@ func (m *MockClients) AddListProjectSnapshotsFake(s []*cs.Snapshot) {
         m.fakeListProjectSnapshots = &cs.SnapshotList{Items: s}
 }
 
+// AddCreateSnapshotErrorFake makes CreateSnapshot fail for the disk.
+func (m *MockClients) AddCreateSnapshotErrorFake(disk string, err error) {
+        if m.fakeCreateSnapshotErrors == nil {
+                m.fakeCreateSnapshotErrors = make(map[string]error)
+        }
+        m.fakeCreateSnapshotErrors[disk] = err
+}
+
+// AddWaitOperationErrorFake makes WaitGlobalOperation fail with the error.
+func (m *MockClients) AddWaitOperationErrorFake(err error) {
+        m.fakeWaitOperationError = err
+}
+
+// AddListBucketUsersFake adds fake ACL rules for ListBucketUsers.
+func (m *MockClients) AddListBucketUsersFake(r []stg.ACLRule) {
+        m.fakeListBucketUsers = r
+}
+
+// policyCopy returns a copy of the fake policy so each read sees it as it was added.
+func (m *MockClients) policyCopy() *crm.Policy {
+        if m.fakeGetPolicyResponse == nil {
+                return nil
+        }
+        p := *m.fakeGetPolicyResponse
+        p.Bindings = []*crm.Binding{}
+        for _, b := range m.fakeGetPolicyResponse.Bindings {
+                c := *b
+                c.Members = append([]string{}, b.Members...)
+                p.Bindings = append(p.Bindings, &c)
+        }
+        return &p
+}
+
 // GetPolicyProject is a fake implementation of Cloud Resource Manager's GetIamPolicy.
 func (m *MockClients) GetPolicyProject(projectID string) (*crm.Policy, error) {
-        return m.fakeGetPolicyResponse, nil
+        return m.policyCopy(), nil
 }
 
 // SetPolicyProject is a fake implementation of Cloud Resource Manager's SetIamPolicy.
 func (m *MockClients) SetPolicyProject(projectID string, p *crm.Policy) (*crm.Policy, error) {
-        m.SavedSetPolicy = p
-        return m.SavedSetPolicy, nil
+        if len(m.fakeSetPolicyErrors) > 0 {
+                err := m.fakeSetPolicyErrors[0]
+                m.fakeSetPolicyErrors = m.fakeSetPolicyErrors[1:]
+                return nil, err
+        }
+        if m.SavedSetPolicies == nil {
+                m.SavedSetPolicies = make(map[string]*crm.Policy)
+        }
+        m.SavedSetPolicies[projectID] = p
+        return p, nil
+}
+
+// GetPolicyFolder is a fake implementation of Cloud Resource Manager's folder GetIamPolicy.
+func (m *MockClients) GetPolicyFolder(_ string) (*crm.Policy, error) {
+        return m.policyCopy(), nil
+}
+
+// SetPolicyFolder is a fake implementation of Cloud Resource Manager's folder SetIamPolicy.
+func (m *MockClients) SetPolicyFolder(folderID string, p *crm.Policy) (*crm.Policy, error) {
+        return m.SetPolicyProject("folders/"+folderID, p)
+}
+
+// GetFolderAncestry is a fake implementation of walking a folder's parents.
+func (m *MockClients) GetFolderAncestry(_ string) ([]string, error) {
+        return m.fakeGetAncestryResponse, nil
+}
+
+// GetPolicyOrganization is a fake implementation of Cloud Resource Manager's organization GetIamPolicy.
+func (m *MockClients) GetPolicyOrganization(_ string) (*crm.Policy, error) {
+        return m.policyCopy(), nil
+}
+
+// SetPolicyOrganization is a fake implementation of Cloud Resource Manager's organization SetIamPolicy.
+func (m *MockClients) SetPolicyOrganization(organizationID string, p *crm.Policy) (*crm.Policy, error) {
+        return m.SetPolicyProject("organizations/"+organizationID, p)
 }
 
 // UpdateFinding is a fake implementation of SCC's Updatefinding.
@@ -110,20 +232,55 @@ func (m *MockClients) GetProjectAncestry(_ string) ([]string, error) {
         return m.fakeGetAncestryResponse, nil
 }
 
-// PatchFirewallRule updates the firewall rule for the given project.
-func (m *MockClients) PatchFirewallRule(_, _ string, rb *cs.Firewall) (*cs.Operation, error) {
-        m.SavedFirewallRule = rb
-        return nil, nil
+// PatchFirewallRule updates the firewall rule for the given project, the operation returned
+// is named "patch-" followed by the rule's name.
+//
+// A rule added with AddFirewallRuleFake or inserted is changed by the patch, the ranges and
+// description are only replaced when set.
+func (m *MockClients) PatchFirewallRule(_, name string, rb *cs.Firewall) (*cs.Operation, error) {
+        if m.SavedFirewallRules == nil {
+                m.SavedFirewallRules = make(map[string]*cs.Firewall)
+        }
+        m.SavedFirewallRules[name] = rb
+        if rule, ok := m.fakeFirewallRules[name]; ok {
+                patched := *rule
+                patched.Disabled = rb.Disabled
+                if rb.Description != "" {
+                        patched.Description = rb.Description
+                }
+                if rb.DestinationRanges != nil {
+                        patched.DestinationRanges = rb.DestinationRanges
+                }
+                m.fakeFirewallRules[name] = &patched
+        }
+        m.race(name)
+        return &cs.Operation{Name: "patch-" + name}, nil
+}
+
+// race replaces the rule with the one added by AddFirewallRuleRaceFake, once.
+func (m *MockClients) race(name string) {
+        if rule, ok := m.fakeFirewallRaces[name]; ok {
+                m.AddFirewallRuleFake(rule)
+                delete(m.fakeFirewallRaces, name)
+        }
 }
 
 // RemoveBucketUsers removes the users for the given bucket.
 func (m *MockClients) RemoveBucketUsers(_ string, entity stg.ACLEntity) error {
-        m.SavedRemoveBucketUsers = entity
+        m.SavedRemovedBucketUsers = append(m.SavedRemovedBucketUsers, entity)
         return nil
 }
 
+// ListBucketUsers returns the ACL rules of the bucket.
+func (m *MockClients) ListBucketUsers(_ string) ([]stg.ACLRule, error) {
+        return m.fakeListBucketUsers, nil
+}
+
 // CreateSnapshot creates a snapshot of a specified persistent disk.
 func (m *MockClients) CreateSnapshot(_, _, disk string, rb *cs.Snapshot) (*cs.Operation, error) {
+        if err, ok := m.fakeCreateSnapshotErrors[disk]; ok {
+                return nil, err
+        }
         m.SavedCreateSnapshots[disk] = *rb
         return nil, nil
 }
@@ -141,4 +298,52 @@ func (m *MockClients) ListDisks(_, _, _ string) (*cs.DiskList, error) {
 // SetLabels sets the labels on a snapshot.
 func (m *MockClients) SetLabels(_, _ string, rb *cs.GlobalSetLabelsRequest) (*cs.Operation, error) {
         return nil, nil
+}
+
+// GetFirewallRule returns a rule added with AddFirewallRuleFake or one inserted since.
+func (m *MockClients) GetFirewallRule(_, name string) (*cs.Firewall, error) {
+        if rule, ok := m.fakeFirewallRules[name]; ok {
+                return rule, nil
+        }
+        return nil, fmt.Errorf("firewall rule %q: %w", name, ErrNotFound)
+}
+
+// InsertFirewallRule saves the inserted rule and its project, the operation returned is
+// named "insert-" followed by the rule's name. It conflicts if a rule with the name exists.
+func (m *MockClients) InsertFirewallRule(projectID string, rb *cs.Firewall) (*cs.Operation, error) {
+        if _, ok := m.fakeFirewallRules[rb.Name]; ok {
+                return nil, fmt.Errorf("firewall rule %q exists: %w", rb.Name, ErrConflict)
+        }
+        m.SavedInsertedFirewalls = append(m.SavedInsertedFirewalls, rb)
+        if m.SavedInsertedProjects == nil {
+                m.SavedInsertedProjects = make(map[string]string)
+        }
+        m.SavedInsertedProjects[rb.Name] = projectID
+        m.AddFirewallRuleFake(rb)
+        m.race(rb.Name)
+        return &cs.Operation{Name: "insert-" + rb.Name}, nil
+}
+
+// WaitGlobalOperation saves the operation waited for, it fails with the error added by
+// AddWaitOperationErrorFake.
+func (m *MockClients) WaitGlobalOperation(_, name string) error {
+        m.SavedWaitedOperations = append(m.SavedWaitedOperations, name)
+        return m.fakeWaitOperationError
+}
+
+// GetInstance returns an instance added with AddGetInstanceFake.
+func (m *MockClients) GetInstance(_, _, instance string) (*cs.Instance, error) {
+        if i, ok := m.fakeInstances[instance]; ok {
+                return i, nil
+        }
+        return nil, fmt.Errorf("instance %q: %w", instance, ErrNotFound)
+}
+
+// SetInstanceTags saves the tags set on the instance.
+func (m *MockClients) SetInstanceTags(_, _, instance string, rb *cs.Tags) (*cs.Operation, error) {
+        if m.SavedInstanceTags == nil {
+                m.SavedInstanceTags = make(map[string]*cs.Tags)
+        }
+        m.SavedInstanceTags[instance] = rb
+        return nil, nil
 }
\ No newline at end of file
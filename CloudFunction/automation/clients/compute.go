@@ -18,12 +18,28 @@ limitations under the License.
 package clients
 
 import (
+        "errors"
         "fmt"
+        "net/http"
+        "strings"
+        "time"
 
         cs "google.golang.org/api/compute/v1"
+        "google.golang.org/api/googleapi"
         "google.golang.org/api/option"
 )
 
+// ErrNotFound is wrapped by errors getting a compute resource that doesn't exist.
+var ErrNotFound = errors.New("resource not found")
+
+const (
+        // operationPollInterval is how long WaitGlobalOperation waits between reads of an operation.
+        operationPollInterval = time.Second
+        // operationTimeout is how long WaitGlobalOperation waits for an operation to finish,
+        // it's within the function's timeout.
+        operationTimeout = 40 * time.Second
+)
+
 // InstantiateCompute instantiates a compute service.
 func InstantiateCompute(c *Client) error {
         cs, err := cs.NewService(c.ctx, option.WithCredentialsFile(authFile))
@@ -57,4 +73,65 @@ func (c *Client) ListProjectSnapshots(projectID string) (*cs.SnapshotList, error
 // SetLabels sets the labels on a snapshot.
 func (c *Client) SetLabels(projectID, resource string, rb *cs.GlobalSetLabelsRequest) (*cs.Operation, error) {
         return c.cs.Snapshots.SetLabels(projectID, resource, rb).Context(c.ctx).Do()
+}
+
+// GetFirewallRule returns the firewall rule, the error wraps ErrNotFound if it doesn't exist.
+func (c *Client) GetFirewallRule(projectID, name string) (*cs.Firewall, error) {
+        fw, err := c.cs.Firewalls.Get(projectID, name).Context(c.ctx).Do()
+        if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+                return nil, fmt.Errorf("failed to get firewall rule:%q: %w", err, ErrNotFound)
+        }
+        return fw, err
+}
+
+// InsertFirewallRule creates the firewall rule in the given project, the error wraps
+// ErrConflict if a rule with its name exists.
+func (c *Client) InsertFirewallRule(projectID string, rb *cs.Firewall) (*cs.Operation, error) {
+        op, err := c.cs.Firewalls.Insert(projectID, rb).Context(c.ctx).Do()
+        if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusConflict {
+                return nil, fmt.Errorf("failed to insert firewall rule:%q: %w", err, ErrConflict)
+        }
+        return op, err
+}
+
+// GetInstance returns the instance.
+func (c *Client) GetInstance(projectID, zone, instance string) (*cs.Instance, error) {
+        return c.cs.Instances.Get(projectID, zone, instance).Context(c.ctx).Do()
+}
+
+// SetInstanceTags sets the network tags of the instance, rb must carry the fingerprint of the
+// tags read.
+func (c *Client) SetInstanceTags(projectID, zone, instance string, rb *cs.Tags) (*cs.Operation, error) {
+        return c.cs.Instances.SetTags(projectID, zone, instance, rb).Context(c.ctx).Do()
+}
+
+// WaitGlobalOperation waits for the global operation to finish. It fails if the operation
+// did, or if it's still running after operationTimeout.
+func (c *Client) WaitGlobalOperation(projectID, name string) error {
+        deadline := time.Now().Add(operationTimeout)
+        for {
+                op, err := c.cs.GlobalOperations.Get(projectID, name).Context(c.ctx).Do()
+                if err != nil {
+                        return fmt.Errorf("failed to get operation: %q", err)
+                }
+                if op.Status == "DONE" {
+                        return operationError(op)
+                }
+                if time.Now().After(deadline) {
+                        return fmt.Errorf("operation %q still %s after %s", name, op.Status, operationTimeout)
+                }
+                time.Sleep(operationPollInterval)
+        }
+}
+
+// operationError returns the errors a finished operation reports, nil if it succeeded.
+func operationError(op *cs.Operation) error {
+        if op.Error == nil || len(op.Error.Errors) == 0 {
+                return nil
+        }
+        var errs []string
+        for _, e := range op.Error.Errors {
+                errs = append(errs, e.Code+": "+e.Message)
+        }
+        return fmt.Errorf("operation %q failed: %s", op.Name, strings.Join(errs, "; "))
 }
\ No newline at end of file
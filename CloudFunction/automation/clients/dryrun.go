@@ -0,0 +1,217 @@
+/*
+Package clients provides the required clients for taking automated actions.
+
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+        https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package clients
+
+import (
+        "sync"
+
+        stg "cloud.google.com/go/storage"
+        crm "google.golang.org/api/cloudresourcemanager/v1"
+        cs "google.golang.org/api/compute/v1"
+        pb "google.golang.org/genproto/googleapis/cloud/securitycenter/v1beta1"
+)
+
+// Change is a mutation DryRun recorded instead of making.
+type Change struct {
+        // Resource is the full name of the changed resource, for example
+        // "//compute.googleapis.com/projects/p/global/firewalls/f".
+        Resource string `json:"resource"`
+        // Operation is the API method that would have been called, for example "setIamPolicy".
+        Operation string `json:"operation"`
+        // Before is the resource as read before the change, if it could be read.
+        Before interface{} `json:"before,omitempty"`
+        // After is the resource, or the part of it, the change would have written.
+        After interface{} `json:"after,omitempty"`
+}
+
+// DryRun reads through the wrapped client and records every mutation in a plan instead of
+// making it.
+//
+// Actions and the user, host and firewall helpers run in dry-run mode when given a DryRun.
+// Deduplicators and journals don't know about dry runs, actions should be given nil ones so
+// planned findings aren't skipped later and no journal entries are written.
+type DryRun struct {
+        c       ClientInt
+        mu      sync.Mutex
+        changes []Change
+}
+
+// NewDryRun returns a DryRun reading through the client.
+func NewDryRun(c ClientInt) *DryRun {
+        return &DryRun{c: c}
+}
+
+// Plan returns the changes recorded so far, in the order they would have been made.
+func (d *DryRun) Plan() []Change {
+        d.mu.Lock()
+        defer d.mu.Unlock()
+        return append([]Change{}, d.changes...)
+}
+
+// record adds the change to the plan.
+func (d *DryRun) record(c Change) {
+        d.mu.Lock()
+        defer d.mu.Unlock()
+        d.changes = append(d.changes, c)
+}
+
+// recordPolicy records a policy change of the resource, the policy before is read with get.
+func (d *DryRun) recordPolicy(resource string, get func() (*crm.Policy, error), p *crm.Policy) (*crm.Policy, error) {
+        before, err := get()
+        if err != nil {
+                return nil, err
+        }
+        d.record(Change{Resource: resource, Operation: "setIamPolicy", Before: before, After: p})
+        return p, nil
+}
+
+// GetPolicyProject reads the project's policy through the wrapped client.
+func (d *DryRun) GetPolicyProject(projectID string) (*crm.Policy, error) {
+        return d.c.GetPolicyProject(projectID)
+}
+
+// SetPolicyProject records the project's policy change.
+func (d *DryRun) SetPolicyProject(projectID string, p *crm.Policy) (*crm.Policy, error) {
+        return d.recordPolicy("//cloudresourcemanager.googleapis.com/projects/"+projectID, func() (*crm.Policy, error) {
+                return d.c.GetPolicyProject(projectID)
+        }, p)
+}
+
+// GetProjectAncestry reads the project's ancestry through the wrapped client.
+func (d *DryRun) GetProjectAncestry(projectID string) ([]string, error) {
+        return d.c.GetProjectAncestry(projectID)
+}
+
+// GetPolicyFolder reads the folder's policy through the wrapped client.
+func (d *DryRun) GetPolicyFolder(folderID string) (*crm.Policy, error) {
+        return d.c.GetPolicyFolder(folderID)
+}
+
+// SetPolicyFolder records the folder's policy change.
+func (d *DryRun) SetPolicyFolder(folderID string, p *crm.Policy) (*crm.Policy, error) {
+        return d.recordPolicy("//cloudresourcemanager.googleapis.com/folders/"+folderID, func() (*crm.Policy, error) {
+                return d.c.GetPolicyFolder(folderID)
+        }, p)
+}
+
+// GetFolderAncestry reads the folder's ancestry through the wrapped client.
+func (d *DryRun) GetFolderAncestry(folderID string) ([]string, error) {
+        return d.c.GetFolderAncestry(folderID)
+}
+
+// GetPolicyOrganization reads the organization's policy through the wrapped client.
+func (d *DryRun) GetPolicyOrganization(organizationID string) (*crm.Policy, error) {
+        return d.c.GetPolicyOrganization(organizationID)
+}
+
+// SetPolicyOrganization records the organization's policy change.
+func (d *DryRun) SetPolicyOrganization(organizationID string, p *crm.Policy) (*crm.Policy, error) {
+        return d.recordPolicy("//cloudresourcemanager.googleapis.com/organizations/"+organizationID, func() (*crm.Policy, error) {
+                return d.c.GetPolicyOrganization(organizationID)
+        }, p)
+}
+
+// UpdateFinding records the finding's update.
+func (d *DryRun) UpdateFinding(req *pb.UpdateFindingRequest) (*pb.Finding, error) {
+        d.record(Change{Resource: "//securitycenter.googleapis.com/" + req.GetFinding().GetName(), Operation: "updateFinding", After: req.GetFinding()})
+        return req.GetFinding(), nil
+}
+
+// PatchFirewallRule records the firewall rule's patch.
+func (d *DryRun) PatchFirewallRule(projectID, name string, rb *cs.Firewall) (*cs.Operation, error) {
+        before, err := d.c.GetFirewallRule(projectID, name)
+        if err != nil {
+                return nil, err
+        }
+        d.record(Change{Resource: "//compute.googleapis.com/projects/" + projectID + "/global/firewalls/" + name, Operation: "patch", Before: before, After: rb})
+        return &cs.Operation{}, nil
+}
+
+// CreateSnapshot records the disk's snapshot.
+func (d *DryRun) CreateSnapshot(projectID, zone, disk string, rb *cs.Snapshot) (*cs.Operation, error) {
+        d.record(Change{Resource: "//compute.googleapis.com/projects/" + projectID + "/zones/" + zone + "/disks/" + disk, Operation: "createSnapshot", After: rb})
+        return &cs.Operation{}, nil
+}
+
+// ListProjectSnapshots lists the project's snapshots through the wrapped client.
+func (d *DryRun) ListProjectSnapshots(projectID string) (*cs.SnapshotList, error) {
+        return d.c.ListProjectSnapshots(projectID)
+}
+
+// ListDisks lists the disks through the wrapped client.
+func (d *DryRun) ListDisks(projectID, zone, instance string) (*cs.DiskList, error) {
+        return d.c.ListDisks(projectID, zone, instance)
+}
+
+// SetLabels records the snapshot's labels.
+func (d *DryRun) SetLabels(projectID, resource string, rb *cs.GlobalSetLabelsRequest) (*cs.Operation, error) {
+        d.record(Change{Resource: "//compute.googleapis.com/projects/" + projectID + "/global/snapshots/" + resource, Operation: "setLabels", After: rb})
+        return &cs.Operation{}, nil
+}
+
+// RemoveBucketUsers records the removal of the entity from the bucket's ACL.
+func (d *DryRun) RemoveBucketUsers(bucketName string, entity stg.ACLEntity) error {
+        before, err := d.c.ListBucketUsers(bucketName)
+        if err != nil {
+                return err
+        }
+        after := []stg.ACLRule{}
+        for _, r := range before {
+                if r.Entity != entity {
+                        after = append(after, r)
+                }
+        }
+        d.record(Change{Resource: "//storage.googleapis.com/" + bucketName, Operation: "deleteAcl", Before: before, After: after})
+        return nil
+}
+
+// ListBucketUsers lists the bucket's ACL through the wrapped client.
+func (d *DryRun) ListBucketUsers(bucketName string) ([]stg.ACLRule, error) {
+        return d.c.ListBucketUsers(bucketName)
+}
+
+// GetFirewallRule reads the firewall rule through the wrapped client.
+func (d *DryRun) GetFirewallRule(projectID, name string) (*cs.Firewall, error) {
+        return d.c.GetFirewallRule(projectID, name)
+}
+
+// InsertFirewallRule records the firewall rule's creation.
+func (d *DryRun) InsertFirewallRule(projectID string, rb *cs.Firewall) (*cs.Operation, error) {
+        d.record(Change{Resource: "//compute.googleapis.com/projects/" + projectID + "/global/firewalls/" + rb.Name, Operation: "insert", After: rb})
+        return &cs.Operation{}, nil
+}
+
+// WaitGlobalOperation returns at once, the operations of a dry run are never started.
+func (d *DryRun) WaitGlobalOperation(projectID, name string) error {
+        return nil
+}
+
+// GetInstance reads the instance through the wrapped client.
+func (d *DryRun) GetInstance(projectID, zone, instance string) (*cs.Instance, error) {
+        return d.c.GetInstance(projectID, zone, instance)
+}
+
+// SetInstanceTags records the instance's tags change.
+func (d *DryRun) SetInstanceTags(projectID, zone, instance string, rb *cs.Tags) (*cs.Operation, error) {
+        before, err := d.c.GetInstance(projectID, zone, instance)
+        if err != nil {
+                return nil, err
+        }
+        d.record(Change{Resource: "//compute.googleapis.com/projects/" + projectID + "/zones/" + zone + "/instances/" + instance, Operation: "setTags", Before: before.Tags, After: rb})
+        return &cs.Operation{}, nil
+}
\ No newline at end of file
@@ -18,13 +18,27 @@ limitations under the License.
 package clients
 
 import (
+        "encoding/json"
+        "errors"
         "fmt"
+        "net/http"
+        "strings"
 
         "google.golang.org/api/cloudresourcemanager/v1"
         crm "google.golang.org/api/cloudresourcemanager/v1"
+        crmv2 "google.golang.org/api/cloudresourcemanager/v2"
+        "google.golang.org/api/googleapi"
         "google.golang.org/api/option"
 )
 
+// PolicyVersion is the IAM policy version requested, it's the only version that includes
+// conditional bindings.
+const PolicyVersion = 3
+
+// ErrConflict is wrapped by errors setting an IAM policy that changed since it was read, and
+// by errors creating a firewall rule that exists.
+var ErrConflict = errors.New("concurrent change")
+
 // InstantiateCRM initalizes the CRM client.
 func InstantiateCRM(c *Client) error {
         crm, err := crm.NewService(c.ctx, option.WithCredentialsFile(authFile))
@@ -33,12 +47,20 @@ func InstantiateCRM(c *Client) error {
                 return fmt.Errorf("failed to init crm: %q", err)
         }
         c.crm = crm
+
+        crmv2, err := crmv2.NewService(c.ctx, option.WithCredentialsFile(authFile))
+        if err != nil {
+                return fmt.Errorf("failed to init crm v2: %q", err)
+        }
+        c.crmv2 = crmv2
         return nil
 }
 
-// GetPolicyProject returns the IAM policy for the given project resource.
+// GetPolicyProject returns the IAM policy for the given project resource, conditional bindings included.
 func (c *Client) GetPolicyProject(projectID string) (*cloudresourcemanager.Policy, error) {
-        rb := &cloudresourcemanager.GetIamPolicyRequest{}
+        rb := &cloudresourcemanager.GetIamPolicyRequest{
+                Options: &cloudresourcemanager.GetPolicyOptions{RequestedPolicyVersion: PolicyVersion},
+        }
         resp, err := c.crm.Projects.GetIamPolicy(projectID, rb).Context(c.ctx).Do()
         if err != nil {
                 return nil, fmt.Errorf("failed to get project IAM policy:%q", err)
@@ -51,7 +73,7 @@ func (c *Client) SetPolicyProject(projectID string, p *cloudresourcemanager.Poli
         rb := &cloudresourcemanager.SetIamPolicyRequest{Policy: p}
         resp, err := c.crm.Projects.SetIamPolicy(projectID, rb).Context(c.ctx).Do()
         if err != nil {
-                return nil, fmt.Errorf("failed to set project IAM policy:%q", err)
+                return nil, setPolicyError("project", err)
         }
         return resp, nil
 }
@@ -68,4 +90,117 @@ func (c *Client) GetProjectAncestry(projectID string) ([]string, error) {
                 s = append(s, a.ResourceId.Type+"s/"+a.ResourceId.Id)
         }
         return s, nil
+}
+
+// GetPolicyFolder returns the IAM policy for the given folder resource, conditional bindings included.
+func (c *Client) GetPolicyFolder(folderID string) (*cloudresourcemanager.Policy, error) {
+        rb := &crmv2.GetIamPolicyRequest{
+                Options: &crmv2.GetPolicyOptions{RequestedPolicyVersion: PolicyVersion},
+        }
+        resp, err := c.crmv2.Folders.GetIamPolicy("folders/"+folderID, rb).Context(c.ctx).Do()
+        if err != nil {
+                return nil, fmt.Errorf("failed to get folder IAM policy:%q", err)
+        }
+        p := &cloudresourcemanager.Policy{}
+        if err := convertPolicy(resp, p); err != nil {
+                return nil, err
+        }
+        return p, nil
+}
+
+// SetPolicyFolder sets an IAM policy for the given folder resource.
+func (c *Client) SetPolicyFolder(folderID string, p *cloudresourcemanager.Policy) (*cloudresourcemanager.Policy, error) {
+        v2 := &crmv2.Policy{}
+        if err := convertPolicy(p, v2); err != nil {
+                return nil, err
+        }
+        rb := &crmv2.SetIamPolicyRequest{Policy: v2}
+        resp, err := c.crmv2.Folders.SetIamPolicy("folders/"+folderID, rb).Context(c.ctx).Do()
+        if err != nil {
+                return nil, setPolicyError("folder", err)
+        }
+        setp := &cloudresourcemanager.Policy{}
+        if err := convertPolicy(resp, setp); err != nil {
+                return nil, err
+        }
+        return setp, nil
+}
+
+// GetFolderAncestry returns the folder followed by its parent folders and organization.
+func (c *Client) GetFolderAncestry(folderID string) ([]string, error) {
+        s := []string{}
+        name := "folders/" + folderID
+        for strings.HasPrefix(name, "folders/") {
+                s = append(s, name)
+                resp, err := c.crmv2.Folders.Get(name).Context(c.ctx).Do()
+                if err != nil {
+                        return nil, fmt.Errorf("failed to get folder: %q", err)
+                }
+                name = resp.Parent
+        }
+        if name != "" {
+                s = append(s, name)
+        }
+        return s, nil
+}
+
+// GetPolicyOrganization returns the IAM policy for the given organization resource, conditional bindings included.
+func (c *Client) GetPolicyOrganization(organizationID string) (*cloudresourcemanager.Policy, error) {
+        rb := &cloudresourcemanager.GetIamPolicyRequest{
+                Options: &cloudresourcemanager.GetPolicyOptions{RequestedPolicyVersion: PolicyVersion},
+        }
+        resp, err := c.crm.Organizations.GetIamPolicy("organizations/"+organizationID, rb).Context(c.ctx).Do()
+        if err != nil {
+                return nil, fmt.Errorf("failed to get organization IAM policy:%q", err)
+        }
+        return resp, nil
+}
+
+// SetPolicyOrganization sets an IAM policy for the given organization resource.
+func (c *Client) SetPolicyOrganization(organizationID string, p *cloudresourcemanager.Policy) (*cloudresourcemanager.Policy, error) {
+        rb := &cloudresourcemanager.SetIamPolicyRequest{Policy: p}
+        resp, err := c.crm.Organizations.SetIamPolicy("organizations/"+organizationID, rb).Context(c.ctx).Do()
+        if err != nil {
+                return nil, setPolicyError("organization", err)
+        }
+        return resp, nil
+}
+
+// convertPolicy copies a policy between API versions, they share the same JSON representation.
+func convertPolicy(from, to interface{}) error {
+        b, err := json.Marshal(from)
+        if err != nil {
+                return fmt.Errorf("failed to convert policy: %q", err)
+        }
+        if err := json.Unmarshal(b, to); err != nil {
+                return fmt.Errorf("failed to convert policy: %q", err)
+        }
+        return nil
+}
+
+// setPolicyError wraps an error from SetIamPolicy, conflicts wrap ErrConflict.
+//
+// The etag of the policy doesn't match when it was changed after being read, the API
+// then returns 409 with the ABORTED status.
+func setPolicyError(kind string, err error) error {
+        if e, ok := err.(*googleapi.Error); ok && isConflict(e) {
+                return fmt.Errorf("failed to set %s IAM policy:%q: %w", kind, err, ErrConflict)
+        }
+        return fmt.Errorf("failed to set %s IAM policy:%q", kind, err)
+}
+
+// conflictReasons are the error reasons the API reports for a stale etag.
+var conflictReasons = map[string]bool{"aborted": true, "conflict": true}
+
+// isConflict returns whether the error reports the policy changed since it was read.
+func isConflict(e *googleapi.Error) bool {
+        if e.Code == http.StatusConflict {
+                return true
+        }
+        for _, item := range e.Errors {
+                if conflictReasons[item.Reason] {
+                        return true
+                }
+        }
+        return false
 }
\ No newline at end of file
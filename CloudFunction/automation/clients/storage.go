@@ -18,6 +18,7 @@ limitations under the License.
 package clients
 
 import (
+        "context"
         "fmt"
 
         "cloud.google.com/go/storage"
@@ -26,18 +27,37 @@ import (
 
 // InstantiateStorage initializes the Storage client.
 func InstantiateStorage(c *Client) error {
-        stg, err := storage.NewClient(c.ctx, option.WithCredentialsFile(authFile))
+        stg, err := NewStorageClient(c.ctx)
         if err != nil {
-                return fmt.Errorf("failed to init storage: %q", err)
+                return err
         }
         c.stg = stg
         return nil
 }
 
+// NewStorageClient returns a Storage client authenticated like the other clients, for
+// packages that keep their own objects such as the policy journal.
+func NewStorageClient(ctx context.Context) (*storage.Client, error) {
+        stg, err := storage.NewClient(ctx, option.WithCredentialsFile(authFile))
+        if err != nil {
+                return nil, fmt.Errorf("failed to init storage: %q", err)
+        }
+        return stg, nil
+}
+
 // RemoveBucketUsers deletes the users for the given bucket.
 func (c *Client) RemoveBucketUsers(bucketName string, entity storage.ACLEntity) error {
         if err := c.stg.Bucket(bucketName).ACL().Delete(c.ctx, entity); err != nil {
                 return fmt.Errorf("failed to remove bucket users: %q", err)
         }
         return nil
+}
+
+// ListBucketUsers returns the ACL rules of the given bucket.
+func (c *Client) ListBucketUsers(bucketName string) ([]storage.ACLRule, error) {
+        rules, err := c.stg.Bucket(bucketName).ACL().List(c.ctx)
+        if err != nil {
+                return nil, fmt.Errorf("failed to list bucket users: %q", err)
+        }
+        return rules, nil
 }
\ No newline at end of file
@@ -14,16 +14,21 @@
 # See the License for the specific language governing permissions and
 # limitations under the License.
 resource "google_cloudfunctions_function" "function" {
-  name                  = "SnapshotDisk"
-  description           = "Revokes IAM Event Threat Detection anomalous IAM grants."
-  runtime               = "go112"
+  name                  = "Dispatch"
+  description           = "Runs the automated responses registered for each finding."
+  runtime               = "go113"
   available_memory_mb   = 128
   source_archive_bucket = "${google_storage_bucket.cloud_function_bucket.name}"
   source_archive_object = "${google_storage_bucket_object.cloud_function_zip.name}"
   timeout               = 60
   project               = "${var.automationProject}"
   region                = "${local.region}"
-  entry_point           = "SnapshotDisk"
+  entry_point           = "Dispatch"
+
+  environment_variables = {
+    FOLDER_IDS         = "${var.userFolder}"
+    DISALLOWED_DOMAINS = "${var.disallowedDomains}"
+  }
 
   event_trigger = {
     event_type = "providers/cloud.pubsub/eventTypes/topic.publish"
@@ -0,0 +1,435 @@
+/*
+Package config loads the runtime configuration of the automation Cloud Functions.
+
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+        https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package config
+
+import (
+        "automation/finding"
+        "bytes"
+        "encoding/json"
+        "fmt"
+        "io/ioutil"
+        "net"
+        "os"
+        "regexp"
+        "strconv"
+        "strings"
+        "time"
+)
+
+// Environment variables read by Load. List values are comma separated.
+const (
+        // EnvFile is the path of a JSON configuration file, relative paths are resolved against
+        // the function's working directory so a file bundled with the source can be used.
+        EnvFile = "AUTOMATION_CONFIG"
+        // EnvFolderIDs overrides the folders, or organizations, grants are revoked within.
+        EnvFolderIDs = "FOLDER_IDS"
+        // EnvDisallowed overrides the domains whose members are revoked.
+        EnvDisallowed = "DISALLOWED_DOMAINS"
+        // EnvAllowed overrides the approved domains members are kept of in allowlist mode.
+        EnvAllowed = "ALLOWED_DOMAINS"
+        // EnvRevokeMode overrides which members are revoked, "flagged", "domains" or "allowlist".
+        EnvRevokeMode = "REVOKE_MODE"
+        // EnvSupportedRules overrides the ETD rules disks are snapshotted for.
+        EnvSupportedRules = "SNAPSHOT_SUPPORTED_RULES"
+        // EnvAllowSnapshotOlderThan overrides how old a snapshot must be before another is taken,
+        // for example "5m".
+        EnvAllowSnapshotOlderThan = "ALLOW_SNAPSHOT_OLDER_THAN"
+        // EnvQuarantineRules overrides the ETD rules instances are quarantined for.
+        EnvQuarantineRules = "QUARANTINE_RULES"
+        // EnvQuarantineTag overrides the network tag quarantined instances are given.
+        EnvQuarantineTag = "QUARANTINE_TAG"
+        // EnvForensicsRanges overrides the CIDR ranges quarantined instances can still reach.
+        EnvForensicsRanges = "FORENSICS_RANGES"
+        // EnvBlockRules overrides the ETD rules whose bad IPs are blocked.
+        EnvBlockRules = "BLOCK_IP_RULES"
+        // EnvCloseFirewallRules overrides the Security Health Analytics categories open firewall
+        // rules are disabled for.
+        EnvCloseFirewallRules = "CLOSE_FIREWALL_RULES"
+        // EnvCloseBucketRules overrides the Security Health Analytics categories public access is
+        // removed from buckets for.
+        EnvCloseBucketRules = "CLOSE_BUCKET_RULES"
+        // EnvPlaybooks overrides the path of the playbooks file.
+        EnvPlaybooks = "PLAYBOOKS"
+        // EnvJournalBucket overrides the Cloud Storage bucket policies are journaled in.
+        EnvJournalBucket = "JOURNAL_BUCKET"
+        // EnvDryRun overrides whether changes are only planned, for example "true".
+        EnvDryRun = "DRY_RUN"
+)
+
+// Revoke modes.
+const (
+        // RevokeFlagged removes only the members a finding flags.
+        RevokeFlagged = "flagged"
+        // RevokeDomains removes every member of a disallowed domain.
+        RevokeDomains = "domains"
+        // RevokeAllowlist removes every member outside of the allowed domains.
+        RevokeAllowlist = "allowlist"
+)
+
+// Config is the runtime configuration of the automation Cloud Functions.
+type Config struct {
+        RevokeExternalGrants RevokeExternalGrants `json:"revokeExternalGrants"`
+        CreateSnapshot       CreateSnapshot       `json:"createSnapshot"`
+        QuarantineInstance   QuarantineInstance   `json:"quarantineInstance"`
+        BlockIndicatorIPs    BlockIndicatorIPs    `json:"blockIndicatorIps"`
+        CloseOpenFirewall    CloseOpenFirewall    `json:"closeOpenFirewall"`
+        ClosePublicBucket    ClosePublicBucket    `json:"closePublicBucket"`
+        // DedupWindow is how long a handled finding is skipped if delivered or emitted again.
+        DedupWindow Duration `json:"dedupWindow"`
+        // Playbooks is the path of the file declaring playbooks, none are run if it's empty.
+        Playbooks string `json:"playbooks,omitempty"`
+        // JournalBucket is the Cloud Storage bucket IAM policies are recorded in before the
+        // revoker changes them, so they can be restored. Policies aren't recorded if it's empty.
+        JournalBucket string `json:"journalBucket,omitempty"`
+        // DryRun logs the changes the actions would make instead of making them.
+        DryRun bool `json:"dryRun,omitempty"`
+}
+
+// RevokeExternalGrants configures the IAM revoker.
+type RevokeExternalGrants struct {
+        // FolderIDs are the folders grants are revoked within, an entry may also be an
+        // organization's resource name such as "organizations/154584661726".
+        FolderIDs []string `json:"folderIds"`
+        // Disallowed contains the external domains whose members are revoked.
+        Disallowed []string `json:"disallowed"`
+        // Allowed contains the approved domains, and their subdomains, whose members are kept
+        // in allowlist mode.
+        Allowed []string `json:"allowed,omitempty"`
+        // Mode is RevokeFlagged, RevokeDomains or RevokeAllowlist.
+        Mode string `json:"mode"`
+        // Threshold is the minimum severity and priority of a grant before it's revoked.
+        Threshold Threshold `json:"threshold,omitempty"`
+}
+
+// CreateSnapshot configures the disk snapshotter.
+type CreateSnapshot struct {
+        // SupportedRules are the ETD rules disks are snapshotted for.
+        SupportedRules []string `json:"supportedRules"`
+        // AllowSnapshotOlderThan is how old a snapshot must be before another is taken.
+        AllowSnapshotOlderThan Duration `json:"allowSnapshotOlderThan"`
+        // Threshold is the minimum severity and priority of a finding before disks are captured.
+        Threshold Threshold `json:"threshold,omitempty"`
+}
+
+// QuarantineInstance configures the instance quarantine.
+type QuarantineInstance struct {
+        // SupportedRules are the ETD rules instances are quarantined for, for example
+        // "bad_ip" or "bad_domain". Instances are only quarantined once it's set.
+        SupportedRules []string `json:"supportedRules,omitempty"`
+        // Tag is the network tag quarantined instances are given.
+        Tag string `json:"tag"`
+        // ForensicsRanges are the CIDR ranges quarantined instances can still be reached from
+        // and reach, for example a forensics subnet.
+        ForensicsRanges []string `json:"forensicsRanges,omitempty"`
+        // Threshold is the minimum severity and priority of a finding before instances are quarantined.
+        Threshold Threshold `json:"threshold,omitempty"`
+}
+
+// BlockIndicatorIPs configures the blocking of bad IPs.
+type BlockIndicatorIPs struct {
+        // SupportedRules are the ETD rules whose bad IPs are blocked, for example "bad_ip" or
+        // "cryptomining". Bad IPs are only blocked once it's set.
+        SupportedRules []string `json:"supportedRules,omitempty"`
+        // Threshold is the minimum severity and priority of a finding before its bad IPs are blocked.
+        Threshold Threshold `json:"threshold,omitempty"`
+}
+
+// CloseOpenFirewall configures disabling the firewall rules Security Health Analytics reports
+// as open.
+type CloseOpenFirewall struct {
+        // SupportedRules are the categories firewall rules are disabled for, only OPEN_FIREWALL
+        // is supported. Rules are only disabled once it's set.
+        SupportedRules []string `json:"supportedRules,omitempty"`
+        // Threshold is the minimum severity and priority of a finding before its rules are disabled.
+        Threshold Threshold `json:"threshold,omitempty"`
+}
+
+// ClosePublicBucket configures removing public access from the buckets Security Health
+// Analytics reports.
+type ClosePublicBucket struct {
+        // SupportedRules are the categories public access is removed for, only PUBLIC_BUCKET_ACL
+        // is supported. Buckets are only changed once it's set.
+        SupportedRules []string `json:"supportedRules,omitempty"`
+        // Threshold is the minimum severity and priority of a finding before public access is removed.
+        Threshold Threshold `json:"threshold,omitempty"`
+}
+
+// Threshold is the minimum severity and priority of a finding an action responds to, by
+// name such as "ERROR" and "HIGH". Either left empty accepts every finding.
+type Threshold struct {
+        // Severity is the name of the minimum log severity.
+        Severity string `json:"severity,omitempty"`
+        // Priority is the name of the minimum detection priority.
+        Priority string `json:"priority,omitempty"`
+}
+
+// Parse returns the threshold the names stand for.
+func (t Threshold) Parse() (finding.Threshold, error) {
+        var ft finding.Threshold
+        if t.Severity != "" {
+                s, err := finding.ParseSeverity(t.Severity)
+                if err != nil {
+                        return ft, err
+                }
+                ft.Severity = s
+        }
+        if t.Priority != "" {
+                p, err := finding.ParsePriority(t.Priority)
+                if err != nil {
+                        return ft, err
+                }
+                ft.Priority = p
+        }
+        return ft, nil
+}
+
+// Duration is a time.Duration read from a string such as "5m".
+type Duration struct {
+        time.Duration
+}
+
+// UnmarshalJSON parses the duration from a string.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+        var s string
+        if err := json.Unmarshal(b, &s); err != nil {
+                return fmt.Errorf("duration must be a string: %q", err)
+        }
+        v, err := time.ParseDuration(s)
+        if err != nil {
+                return err
+        }
+        d.Duration = v
+        return nil
+}
+
+// MarshalJSON writes the duration as a string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+        return json.Marshal(d.String())
+}
+
+// Default returns the configuration used when nothing overrides it.
+//
+// The folders grants are revoked within and the disallowed domains have no default, they
+// must be configured for the configuration to be valid. Instances aren't quarantined, bad
+// IPs aren't blocked and open firewalls and public buckets are left as they are unless their
+// rules are configured.
+func Default() *Config {
+        return &Config{
+                RevokeExternalGrants: RevokeExternalGrants{
+                        Mode: RevokeFlagged,
+                },
+                CreateSnapshot: CreateSnapshot{
+                        SupportedRules:         []string{"bad_ip", "cryptomining", "ssh_brute_force", "outgoing_dos"},
+                        AllowSnapshotOlderThan: Duration{5 * time.Minute},
+                },
+                QuarantineInstance: QuarantineInstance{
+                        Tag: "quarantine",
+                },
+                DedupWindow: Duration{time.Hour},
+        }
+}
+
+// Load returns the default configuration overridden by the file named by EnvFile and then
+// by the environment variables, it's validated before being returned.
+func Load() (*Config, error) {
+        return load(os.Getenv)
+}
+
+// load reads the configuration with getenv.
+func load(getenv func(string) string) (*Config, error) {
+        c := Default()
+        if path := getenv(EnvFile); path != "" {
+                b, err := ioutil.ReadFile(path)
+                if err != nil {
+                        return nil, fmt.Errorf("failed to read config: %q", err)
+                }
+                // Misspelled settings would otherwise silently keep their defaults.
+                d := json.NewDecoder(bytes.NewReader(b))
+                d.DisallowUnknownFields()
+                if err := d.Decode(c); err != nil {
+                        return nil, fmt.Errorf("failed to parse config: %q", err)
+                }
+        }
+        if v := getenv(EnvFolderIDs); v != "" {
+                c.RevokeExternalGrants.FolderIDs = split(v)
+        }
+        if v := getenv(EnvDisallowed); v != "" {
+                c.RevokeExternalGrants.Disallowed = split(v)
+        }
+        if v := getenv(EnvAllowed); v != "" {
+                c.RevokeExternalGrants.Allowed = split(v)
+        }
+        if v := getenv(EnvRevokeMode); v != "" {
+                c.RevokeExternalGrants.Mode = v
+        }
+        if v := getenv(EnvSupportedRules); v != "" {
+                c.CreateSnapshot.SupportedRules = split(v)
+        }
+        if v := getenv(EnvAllowSnapshotOlderThan); v != "" {
+                d, err := time.ParseDuration(v)
+                if err != nil {
+                        return nil, fmt.Errorf("invalid %s: %q", EnvAllowSnapshotOlderThan, err)
+                }
+                c.CreateSnapshot.AllowSnapshotOlderThan = Duration{d}
+        }
+        if v := getenv(EnvQuarantineRules); v != "" {
+                c.QuarantineInstance.SupportedRules = split(v)
+        }
+        if v := getenv(EnvQuarantineTag); v != "" {
+                c.QuarantineInstance.Tag = v
+        }
+        if v := getenv(EnvForensicsRanges); v != "" {
+                c.QuarantineInstance.ForensicsRanges = split(v)
+        }
+        if v := getenv(EnvBlockRules); v != "" {
+                c.BlockIndicatorIPs.SupportedRules = split(v)
+        }
+        if v := getenv(EnvCloseFirewallRules); v != "" {
+                c.CloseOpenFirewall.SupportedRules = split(v)
+        }
+        if v := getenv(EnvCloseBucketRules); v != "" {
+                c.ClosePublicBucket.SupportedRules = split(v)
+        }
+        if v := getenv(EnvPlaybooks); v != "" {
+                c.Playbooks = v
+        }
+        if v := getenv(EnvJournalBucket); v != "" {
+                c.JournalBucket = v
+        }
+        if v := getenv(EnvDryRun); v != "" {
+                b, err := strconv.ParseBool(v)
+                if err != nil {
+                        return nil, fmt.Errorf("invalid %s: %q", EnvDryRun, err)
+                }
+                c.DryRun = b
+        }
+        if err := c.Validate(); err != nil {
+                return nil, err
+        }
+        return c, nil
+}
+
+// split returns the comma separated values with blanks dropped.
+func split(v string) []string {
+        vs := []string{}
+        for _, s := range strings.Split(v, ",") {
+                if s = strings.TrimSpace(s); s != "" {
+                        vs = append(vs, s)
+                }
+        }
+        return vs
+}
+
+var (
+        // folderIDPattern matches a folder ID or a folder or organization resource name.
+        folderIDPattern = regexp.MustCompile(`^((folders|organizations)/)?[0-9]+$`)
+        // domainPattern matches a domain name.
+        domainPattern = regexp.MustCompile(`^([a-z0-9-]+\.)+[a-z]{2,}$`)
+        // namePattern matches a network tag or the name of a compute resource.
+        namePattern = regexp.MustCompile(`^[a-z]([-a-z0-9]{0,61}[a-z0-9])?$`)
+        // bucketPattern matches a Cloud Storage bucket name without dots.
+        bucketPattern = regexp.MustCompile(`^[a-z0-9][-_a-z0-9]{1,61}[a-z0-9]$`)
+)
+
+// Validate returns an error describing every invalid setting.
+func (c *Config) Validate() error {
+        var errs []string
+        r := c.RevokeExternalGrants
+        if len(r.FolderIDs) == 0 {
+                errs = append(errs, "revokeExternalGrants.folderIds is empty")
+        }
+        for _, id := range r.FolderIDs {
+                if !folderIDPattern.MatchString(id) {
+                        errs = append(errs, fmt.Sprintf("revokeExternalGrants.folderIds has invalid folder %q", id))
+                }
+        }
+        switch r.Mode {
+        case RevokeFlagged, RevokeDomains:
+                if len(r.Disallowed) == 0 {
+                        errs = append(errs, "revokeExternalGrants.disallowed is empty")
+                }
+        case RevokeAllowlist:
+                if len(r.Allowed) == 0 {
+                        errs = append(errs, "revokeExternalGrants.allowed is empty")
+                }
+        default:
+                errs = append(errs, fmt.Sprintf("revokeExternalGrants.mode %q must be %q, %q or %q", r.Mode, RevokeFlagged, RevokeDomains, RevokeAllowlist))
+        }
+        for _, d := range r.Disallowed {
+                if !domainPattern.MatchString(d) {
+                        errs = append(errs, fmt.Sprintf("revokeExternalGrants.disallowed has invalid domain %q", d))
+                }
+        }
+        for _, d := range r.Allowed {
+                if !domainPattern.MatchString(d) {
+                        errs = append(errs, fmt.Sprintf("revokeExternalGrants.allowed has invalid domain %q", d))
+                }
+        }
+        s := c.CreateSnapshot
+        if len(s.SupportedRules) == 0 {
+                errs = append(errs, "createSnapshot.supportedRules is empty")
+        }
+        if s.AllowSnapshotOlderThan.Duration <= 0 {
+                errs = append(errs, "createSnapshot.allowSnapshotOlderThan must be positive")
+        }
+        q := c.QuarantineInstance
+        if !namePattern.MatchString(q.Tag) {
+                errs = append(errs, fmt.Sprintf("quarantineInstance.tag %q isn't a valid network tag", q.Tag))
+        }
+        for _, r := range q.ForensicsRanges {
+                if _, _, err := net.ParseCIDR(r); err != nil {
+                        errs = append(errs, fmt.Sprintf("quarantineInstance.forensicsRanges has invalid range %q", r))
+                }
+        }
+        for _, r := range c.CloseOpenFirewall.SupportedRules {
+                if r != finding.CategoryOpenFirewall {
+                        errs = append(errs, fmt.Sprintf("closeOpenFirewall.supportedRules has unsupported category %q", r))
+                }
+        }
+        for _, r := range c.ClosePublicBucket.SupportedRules {
+                if r != finding.CategoryPublicBucketACL {
+                        errs = append(errs, fmt.Sprintf("closePublicBucket.supportedRules has unsupported category %q", r))
+                }
+        }
+        thresholds := []struct {
+                name string
+                t    Threshold
+        }{
+                {"revokeExternalGrants.threshold", r.Threshold},
+                {"createSnapshot.threshold", s.Threshold},
+                {"quarantineInstance.threshold", q.Threshold},
+                {"blockIndicatorIps.threshold", c.BlockIndicatorIPs.Threshold},
+                {"closeOpenFirewall.threshold", c.CloseOpenFirewall.Threshold},
+                {"closePublicBucket.threshold", c.ClosePublicBucket.Threshold},
+        }
+        for _, t := range thresholds {
+                if _, err := t.t.Parse(); err != nil {
+                        errs = append(errs, fmt.Sprintf("%s has %s", t.name, err))
+                }
+        }
+        if c.DedupWindow.Duration < 0 {
+                errs = append(errs, "dedupWindow can't be negative")
+        }
+        if c.JournalBucket != "" && !bucketPattern.MatchString(c.JournalBucket) {
+                errs = append(errs, fmt.Sprintf("journalBucket %q isn't a valid bucket name", c.JournalBucket))
+        }
+        if len(errs) > 0 {
+                return fmt.Errorf("invalid config: %s", strings.Join(errs, "; "))
+        }
+        return nil
+}
\ No newline at end of file
@@ -0,0 +1,246 @@
+/*
+Package config loads the runtime configuration of the automation Cloud Functions.
+
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+        https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package config
+
+import (
+        "io/ioutil"
+        "os"
+        "path/filepath"
+        "reflect"
+        "strings"
+        "testing"
+        "time"
+)
+
+func TestLoad(t *testing.T) {
+        dir, err := ioutil.TempDir("", "config")
+        if err != nil {
+                t.Fatalf("failed to create directory: %q", err)
+        }
+        defer os.RemoveAll(dir)
+        files := map[string]string{
+                "config.json": `{
+  "revokeExternalGrants": {"folderIds": ["organizations/154584661726"], "disallowed": ["evil.com"], "mode": "domains"},
+  "createSnapshot": {"allowSnapshotOlderThan": "1h", "threshold": {"severity": "ERROR", "priority": "HIGH"}},
+  "closeOpenFirewall": {"supportedRules": ["OPEN_FIREWALL"], "threshold": {"priority": "MEDIUM"}},
+  "dedupWindow": "30m"
+}`,
+                "unknown.json":   `{"revokeExternalGrants": {"folderId": ["123"]}}`,
+                "threshold.json": `{"quarantineInstance": {"threshold": {"severity": "SEVERE"}}}`,
+        }
+        for name, contents := range files {
+                if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0600); err != nil {
+                        t.Fatalf("failed to write config: %q", err)
+                }
+        }
+        file := filepath.Join(dir, "config.json")
+        // required are the settings without a default, tests clear them with an empty value.
+        required := map[string]string{EnvFolderIDs: "123", EnvDisallowed: "evil.com"}
+        valid := func() *Config {
+                c := Default()
+                c.RevokeExternalGrants.FolderIDs = []string{"123"}
+                c.RevokeExternalGrants.Disallowed = []string{"evil.com"}
+                return c
+        }
+        fromFile := Default()
+        fromFile.RevokeExternalGrants = RevokeExternalGrants{FolderIDs: []string{"organizations/154584661726"}, Disallowed: []string{"evil.com"}, Mode: RevokeDomains}
+        fromFile.CreateSnapshot.AllowSnapshotOlderThan = Duration{time.Hour}
+        fromFile.CreateSnapshot.Threshold = Threshold{Severity: "ERROR", Priority: "HIGH"}
+        fromFile.CloseOpenFirewall = CloseOpenFirewall{SupportedRules: []string{"OPEN_FIREWALL"}, Threshold: Threshold{Priority: "MEDIUM"}}
+        fromFile.DedupWindow = Duration{30 * time.Minute}
+        fromEnv := valid()
+        fromEnv.RevokeExternalGrants.FolderIDs = []string{"123", "folders/456"}
+        fromEnv.CreateSnapshot.SupportedRules = []string{"bad_ip"}
+        fromEnv.CreateSnapshot.AllowSnapshotOlderThan = Duration{10 * time.Minute}
+        fromEnv.Playbooks = "playbooks.json"
+        fromEnv.JournalBucket = "automation-journal"
+        fromEnv.DryRun = true
+        quarantine := valid()
+        quarantine.QuarantineInstance = QuarantineInstance{
+                SupportedRules:  []string{"bad_ip"},
+                Tag:             "isolated",
+                ForensicsRanges: []string{"10.128.0.0/24", "10.132.0.0/24"},
+        }
+        block := valid()
+        block.BlockIndicatorIPs = BlockIndicatorIPs{SupportedRules: []string{"bad_ip", "bad_domain"}}
+        health := valid()
+        health.CloseOpenFirewall.SupportedRules = []string{"OPEN_FIREWALL"}
+        health.ClosePublicBucket.SupportedRules = []string{"PUBLIC_BUCKET_ACL"}
+        allowlist := valid()
+        allowlist.RevokeExternalGrants.Allowed = []string{"google.com", "example.com"}
+        allowlist.RevokeExternalGrants.Mode = RevokeAllowlist
+        fileAndEnv := Default()
+        *fileAndEnv = *fromFile
+        fileAndEnv.RevokeExternalGrants.Disallowed = []string{"gmail.com", "test.com"}
+
+        tests := []struct {
+                name     string
+                env      map[string]string
+                expected *Config
+                errMatch string
+        }{
+                {
+                        name:     "defaults",
+                        expected: valid(),
+                },
+                {
+                        name:     "missing settings",
+                        env:      map[string]string{EnvFolderIDs: "", EnvDisallowed: ""},
+                        errMatch: "invalid config: revokeExternalGrants.folderIds is empty; revokeExternalGrants.disallowed is empty",
+                },
+                {
+                        name:     "file",
+                        env:      map[string]string{EnvFile: file, EnvFolderIDs: "", EnvDisallowed: ""},
+                        expected: fromFile,
+                },
+                {
+                        name:     "unknown setting",
+                        env:      map[string]string{EnvFile: filepath.Join(dir, "unknown.json")},
+                        errMatch: `failed to parse config: "json: unknown field \"folderId\""`,
+                },
+                {
+                        name:     "invalid threshold",
+                        env:      map[string]string{EnvFile: filepath.Join(dir, "threshold.json")},
+                        errMatch: `invalid config: quarantineInstance.threshold has unknown severity "SEVERE"`,
+                },
+                {
+                        name: "environment",
+                        env: map[string]string{
+                                EnvFolderIDs:              "123, folders/456",
+                                EnvSupportedRules:         "bad_ip",
+                                EnvAllowSnapshotOlderThan: "10m",
+                                EnvPlaybooks:              "playbooks.json",
+                                EnvJournalBucket:          "automation-journal",
+                                EnvDryRun:                 "true",
+                        },
+                        expected: fromEnv,
+                },
+                {
+                        name:     "environment overrides file",
+                        env:      map[string]string{EnvFile: file, EnvFolderIDs: "", EnvDisallowed: "gmail.com,test.com"},
+                        expected: fileAndEnv,
+                },
+                {
+                        name:     "missing file",
+                        env:      map[string]string{EnvFile: filepath.Join(dir, "missing.json")},
+                        errMatch: "failed to read config",
+                },
+                {
+                        name:     "invalid duration",
+                        env:      map[string]string{EnvAllowSnapshotOlderThan: "soon"},
+                        errMatch: "invalid " + EnvAllowSnapshotOlderThan,
+                },
+                {
+                        name: "quarantine",
+                        env: map[string]string{
+                                EnvQuarantineRules: "bad_ip",
+                                EnvQuarantineTag:   "isolated",
+                                EnvForensicsRanges: "10.128.0.0/24, 10.132.0.0/24",
+                        },
+                        expected: quarantine,
+                },
+                {
+                        name:     "invalid quarantine",
+                        env:      map[string]string{EnvQuarantineTag: "Isolated", EnvForensicsRanges: "10.128.0.0"},
+                        errMatch: `invalid config: quarantineInstance.tag "Isolated" isn't a valid network tag; quarantineInstance.forensicsRanges has invalid range "10.128.0.0"`,
+                },
+                {
+                        name:     "block indicator ips",
+                        env:      map[string]string{EnvBlockRules: "bad_ip,bad_domain"},
+                        expected: block,
+                },
+                {
+                        name:     "health remediation",
+                        env:      map[string]string{EnvCloseFirewallRules: "OPEN_FIREWALL", EnvCloseBucketRules: "PUBLIC_BUCKET_ACL"},
+                        expected: health,
+                },
+                {
+                        name:     "unsupported health category",
+                        env:      map[string]string{EnvCloseFirewallRules: "PUBLIC_BUCKET_ACL", EnvCloseBucketRules: "OPEN_FIREWALL"},
+                        errMatch: `invalid config: closeOpenFirewall.supportedRules has unsupported category "PUBLIC_BUCKET_ACL"; closePublicBucket.supportedRules has unsupported category "OPEN_FIREWALL"`,
+                },
+                {
+                        name:     "allowlist",
+                        env:      map[string]string{EnvRevokeMode: "allowlist", EnvAllowed: "google.com, example.com"},
+                        expected: allowlist,
+                },
+                {
+                        name:     "allowlist without domains",
+                        env:      map[string]string{EnvRevokeMode: "allowlist"},
+                        errMatch: "invalid config: revokeExternalGrants.allowed is empty",
+                },
+                {
+                        name:     "invalid allowed domain",
+                        env:      map[string]string{EnvRevokeMode: "allowlist", EnvAllowed: "*.google.com"},
+                        errMatch: `invalid config: revokeExternalGrants.allowed has invalid domain "*.google.com"`,
+                },
+                {
+                        name:     "invalid journal bucket",
+                        env:      map[string]string{EnvJournalBucket: "gs://automation-journal"},
+                        errMatch: `invalid config: journalBucket "gs://automation-journal" isn't a valid bucket name`,
+                },
+                {
+                        name:     "invalid dry run",
+                        env:      map[string]string{EnvDryRun: "maybe"},
+                        errMatch: "invalid " + EnvDryRun,
+                },
+                {
+                        name:     "invalid settings",
+                        env:      map[string]string{EnvFolderIDs: "projects/p", EnvDisallowed: "tom@gmail.com", EnvRevokeMode: "all"},
+                        errMatch: `invalid config: revokeExternalGrants.folderIds has invalid folder "projects/p"; revokeExternalGrants.mode "all" must be "flagged", "domains" or "allowlist"; revokeExternalGrants.disallowed has invalid domain "tom@gmail.com"`,
+                },
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        c, err := load(func(k string) string {
+                                if v, ok := tt.env[k]; ok {
+                                        return v
+                                }
+                                return required[k]
+                        })
+                        if tt.errMatch != "" {
+                                if err == nil || !strings.Contains(err.Error(), tt.errMatch) {
+                                        t.Errorf("%s failed got:%v want:%q", tt.name, err, tt.errMatch)
+                                }
+                                return
+                        }
+                        if err != nil {
+                                t.Fatalf("%s failed: %q", tt.name, err)
+                        }
+                        if !reflect.DeepEqual(c, tt.expected) {
+                                t.Errorf("%s failed got:%+v want:%+v", tt.name, c, tt.expected)
+                        }
+                })
+        }
+}
+
+// TestDefaultOptIn verifies actions changing resources in place only respond once configured.
+func TestDefaultOptIn(t *testing.T) {
+        c := Default()
+        rules := map[string][]string{
+                "closeOpenFirewall":  c.CloseOpenFirewall.SupportedRules,
+                "closePublicBucket":  c.ClosePublicBucket.SupportedRules,
+                "quarantineInstance": c.QuarantineInstance.SupportedRules,
+                "blockIndicatorIps":  c.BlockIndicatorIPs.SupportedRules,
+        }
+        for name, r := range rules {
+                if len(r) > 0 {
+                        t.Errorf("%s failed got:%q want:none", name, r)
+                }
+        }
+}
\ No newline at end of file
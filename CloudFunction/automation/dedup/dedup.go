@@ -0,0 +1,125 @@
+/*
+Package dedup skips findings an action has already handled.
+
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+        https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dedup
+
+import (
+        "automation/finding"
+
+        "fmt"
+        "sync"
+        "time"
+)
+
+// Store records when keys were last handled.
+type Store interface {
+        // Get returns when the key was recorded and whether it was found.
+        Get(key string) (time.Time, bool, error)
+        // Put records the key as handled at the given time.
+        Put(key string, t time.Time) error
+}
+
+// Deduplicator skips findings handled within a window.
+//
+// Pub/Sub delivers messages at least once and ETD re-emits findings for ongoing activity,
+// findings are therefore matched by both their insert ID and fingerprint. A finding with a new
+// insert ID is skipped when its fingerprint matches, see finding.Finding.Fingerprint for the
+// fields that tell incidents apart.
+type Deduplicator struct {
+        store  Store
+        window time.Duration
+        now    func() time.Time
+}
+
+// New returns a new Deduplicator skipping findings handled within the window.
+func New(s Store, window time.Duration) *Deduplicator {
+        return &Deduplicator{store: s, window: window, now: time.Now}
+}
+
+// Seen returns whether the action handled the finding within the window.
+//
+// A nil Deduplicator has seen nothing.
+func (d *Deduplicator) Seen(action string, f *finding.Finding) (bool, error) {
+        if d == nil {
+                return false, nil
+        }
+        for _, k := range keys(action, f) {
+                t, ok, err := d.store.Get(k)
+                if err != nil {
+                        return false, fmt.Errorf("failed to get %q: %q", k, err)
+                }
+                if ok && d.now().Sub(t) < d.window {
+                        return true, nil
+                }
+        }
+        return false, nil
+}
+
+// Record marks the finding as handled by the action.
+//
+// A nil Deduplicator records nothing.
+func (d *Deduplicator) Record(action string, f *finding.Finding) error {
+        if d == nil {
+                return nil
+        }
+        now := d.now()
+        for _, k := range keys(action, f) {
+                if err := d.store.Put(k, now); err != nil {
+                        return fmt.Errorf("failed to put %q: %q", k, err)
+                }
+        }
+        return nil
+}
+
+// keys returns the keys a finding is recorded under for the action.
+func keys(action string, f *finding.Finding) []string {
+        ks := []string{action + "/fingerprint/" + f.Fingerprint()}
+        if id := f.InsertID(); id != "" {
+                ks = append(ks, action+"/insertId/"+id)
+        }
+        return ks
+}
+
+// MemoryStore is a Store kept in memory.
+//
+// Cloud Functions reuse an instance for many invocations, so a MemoryStore skips most
+// repeats but not those delivered to another instance.
+type MemoryStore struct {
+        mu   sync.Mutex
+        seen map[string]time.Time
+}
+
+// NewMemoryStore returns a new, empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+        return &MemoryStore{seen: make(map[string]time.Time)}
+}
+
+// Get returns when the key was recorded and whether it was found.
+func (m *MemoryStore) Get(key string) (time.Time, bool, error) {
+        m.mu.Lock()
+        defer m.mu.Unlock()
+        t, ok := m.seen[key]
+        return t, ok, nil
+}
+
+// Put records the key as handled at the given time.
+func (m *MemoryStore) Put(key string, t time.Time) error {
+        m.mu.Lock()
+        defer m.mu.Unlock()
+        m.seen[key] = t
+        return nil
+}
\ No newline at end of file
@@ -0,0 +1,157 @@
+/*
+Package dedup skips findings an action has already handled.
+
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+        https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dedup
+
+import (
+        "automation/finding"
+        "testing"
+        "time"
+
+        "cloud.google.com/go/pubsub"
+)
+
+func TestDeduplicator(t *testing.T) {
+        now := time.Date(2019, 7, 16, 21, 0, 0, 0, time.UTC)
+        test := []struct {
+                name string
+                // handled is the message previously handled by the action.
+                handled string
+                // after is how long after the handled message the incoming message arrives.
+                after    time.Duration
+                incoming string
+                action   string
+                exp      bool
+        }{
+                {
+                        name:     "redelivered message",
+                        handled:  createFinding("a", "instance-1", "52.8.47.33"),
+                        after:    time.Minute,
+                        incoming: createFinding("a", "instance-1", "52.8.47.33"),
+                        action:   "snapshot",
+                        exp:      true,
+                },
+                {
+                        name:     "re-emitted finding",
+                        handled:  createFinding("a", "instance-1", "52.8.47.33"),
+                        after:    time.Minute,
+                        incoming: createFinding("b", "instance-1", "52.8.47.33"),
+                        action:   "snapshot",
+                        exp:      true,
+                },
+                {
+                        name:     "different finding",
+                        handled:  createFinding("a", "instance-1", "52.8.47.33"),
+                        after:    time.Minute,
+                        incoming: createFinding("b", "instance-2", "52.8.47.33"),
+                        action:   "snapshot",
+                        exp:      false,
+                },
+                {
+                        name:     "different indicator on same instance",
+                        handled:  createFinding("a", "instance-1", "52.8.47.33"),
+                        after:    time.Minute,
+                        incoming: createFinding("b", "instance-1", "52.8.47.34"),
+                        action:   "snapshot",
+                        exp:      false,
+                },
+                {
+                        name:     "different member on same project",
+                        handled:  createMemberFinding("a", "user:tom@gmail.com"),
+                        after:    time.Minute,
+                        incoming: createMemberFinding("b", "user:eve@gmail.com"),
+                        action:   "snapshot",
+                        exp:      false,
+                },
+                {
+                        name:     "same member re-emitted",
+                        handled:  createMemberFinding("a", "user:tom@gmail.com"),
+                        after:    time.Minute,
+                        incoming: createMemberFinding("b", "user:tom@gmail.com"),
+                        action:   "snapshot",
+                        exp:      true,
+                },
+                {
+                        name:     "outside window",
+                        handled:  createFinding("a", "instance-1", "52.8.47.33"),
+                        after:    time.Hour,
+                        incoming: createFinding("a", "instance-1", "52.8.47.33"),
+                        action:   "snapshot",
+                        exp:      false,
+                },
+                {
+                        name:     "handled by another action",
+                        handled:  createFinding("a", "instance-1", "52.8.47.33"),
+                        after:    time.Minute,
+                        incoming: createFinding("a", "instance-1", "52.8.47.33"),
+                        action:   "revoke",
+                        exp:      false,
+                },
+        }
+        for _, tt := range test {
+                t.Run(tt.name, func(t *testing.T) {
+                        d := New(NewMemoryStore(), 30*time.Minute)
+                        d.now = func() time.Time { return now }
+                        if err := d.Record("snapshot", readFinding(t, tt.handled)); err != nil {
+                                t.Fatalf("failed to record: %q", err)
+                        }
+                        d.now = func() time.Time { return now.Add(tt.after) }
+                        seen, err := d.Seen(tt.action, readFinding(t, tt.incoming))
+                        if err != nil {
+                                t.Fatalf("failed to check: %q", err)
+                        }
+                        if seen != tt.exp {
+                                t.Errorf("%s failed got:%v want:%v", tt.name, seen, tt.exp)
+                        }
+                })
+        }
+}
+
+func readFinding(t *testing.T, data string) *finding.Finding {
+        f := finding.NewFinding()
+        if err := f.ReadFinding(&pubsub.Message{Data: []byte(data)}); err != nil {
+                t.Fatalf("failed reading finding: %q", err)
+        }
+        return f
+}
+
+func createFinding(insertID, instance, ip string) string {
+        return `{
+                "insertId": "` + insertID + `",
+                "jsonPayload": {
+                        "detectionCategory": {"ruleName": "bad_ip"},
+                        "properties": {
+                                "location": "us-central1-c",
+                                "sourceInstance": "/projects/test-project/zones/us-central1-c/instances/` + instance + `",
+                                "ip": ["` + ip + `"]
+                        }
+                },
+                "logName": "projects/test-project/logs/threatdetection.googleapis.com%2Fdetection"
+        }`
+}
+
+func createMemberFinding(insertID, member string) string {
+        return `{
+                "insertId": "` + insertID + `",
+                "jsonPayload": {
+                        "affectedResources": [{"gcpResourceName": "//cloudresourcemanager.googleapis.com/projects/test-project"}],
+                        "detectionCategory": {"ruleName": "iam_anomalous_grant", "subRuleName": "external_member_added_to_policy"},
+                        "properties": {"project_id": "test-project", "externalMembers": ["` + member + `"]}
+                },
+                "logName": "projects/test-project/logs/threatdetection.googleapis.com%2Fdetection"
+        }`
+}
\ No newline at end of file
@@ -20,7 +20,16 @@ package automation
 import (
         "automation/actions"
         "automation/clients"
+        "automation/config"
+        "automation/dedup"
+        "automation/finding"
+        "automation/journal"
+        "automation/playbook"
+        "encoding/json"
         "fmt"
+        "io/ioutil"
+        "log"
+        "sync"
 
         "context"
 
@@ -28,42 +37,226 @@ import (
 )
 
 var (
-        // folderID specifies which folder to remove members from.
-        folderIDs = []string{"760347836977"}
-        // disallowed contains a list of external domains used to remove members.
-        disallowed = []string{"test.com", "gmail.com"}
+        // conf is read once per function instance, see config.Load for the files and
+        // environment variables it's read from.
+        conf *config.Config
+        // registry routes findings to the actions registered from conf.
+        registry *actions.Registry
+        confErr  error
+        confOnce sync.Once
 )
 
-// RevokeExternalGrants is the entry point for IAM revoker Cloud Function.
+// loadConfig returns the configuration, loading it and registering the actions it
+// configures on first use.
+func loadConfig() (*config.Config, error) {
+        confOnce.Do(func() {
+                conf, confErr = config.Load()
+                if confErr != nil {
+                        return
+                }
+                registry, confErr = newRegistry(conf)
+        })
+        if confErr != nil {
+                return nil, fmt.Errorf("failed to load config: %q", confErr)
+        }
+        return conf, nil
+}
+
+// newRegistry registers every action with its settings from the configuration.
 //
-// This sample Cloud Function will be triggered when Event Threat Detection
-// detects an anomalous IAM grant. Once triggered this function will
-// attempt to revoke the external members added to the policy if they contain
-// domains considered disallowed. These members must also be in the folder
-// specified within main.tf. This configuration allows you to take a
-// remediation action only certain specific members and folders. For example,
-// maybe you have a folder "development" where users can experiment and a folder
-// "production". You may want to restrict and revoke external grants to the
-// "production" folder and not restrict activity within "development".
+// Adding a response only needs it registered here, every action shares the one function.
+func newRegistry(cfg *config.Config) (*actions.Registry, error) {
+        r := actions.NewRegistry()
+
+        // The deduplicator is shared by invocations handled by this function instance.
+        // Dry runs neither record findings as handled nor journal policies.
+        d := dedup.New(dedup.NewMemoryStore(), cfg.DedupWindow.Duration)
+        var j journal.Store
+        switch {
+        case cfg.DryRun:
+                d = nil
+        case cfg.JournalBucket != "":
+                ctx := context.Background()
+                stg, err := clients.NewStorageClient(ctx)
+                if err != nil {
+                        return nil, fmt.Errorf("failed to create journal: %q", err)
+                }
+                j = journal.NewGCSStore(ctx, stg, cfg.JournalBucket)
+        default:
+                log.Printf("no journal bucket configured, revoked IAM policies aren't journaled")
+        }
+
+        thresholds := map[string]config.Threshold{
+                "revokeExternalGrants": cfg.RevokeExternalGrants.Threshold,
+                "createSnapshot":       cfg.CreateSnapshot.Threshold,
+                "quarantineInstance":   cfg.QuarantineInstance.Threshold,
+                "blockIndicatorIps":    cfg.BlockIndicatorIPs.Threshold,
+                "closeOpenFirewall":    cfg.CloseOpenFirewall.Threshold,
+                "closePublicBucket":    cfg.ClosePublicBucket.Threshold,
+        }
+        threshold := map[string]finding.Threshold{}
+        for name, t := range thresholds {
+                ft, err := t.Parse()
+                if err != nil {
+                        return nil, fmt.Errorf("invalid %s threshold: %q", name, err)
+                }
+                threshold[name] = ft
+        }
+
+        rv := cfg.RevokeExternalGrants
+        mode := actions.RevokeFlagged
+        switch rv.Mode {
+        case config.RevokeDomains:
+                mode = actions.RevokeDomains
+        case config.RevokeAllowlist:
+                mode = actions.RevokeAllowlist
+        }
+        r.Register("revoke-external-grants", actions.RevokeExternalGrantsRules,
+                actions.RevokeExternalGrantsHandler(actions.RevokeExternalGrantsOptions{
+                        FolderIDs:  rv.FolderIDs,
+                        Disallowed: rv.Disallowed,
+                        Allowed:    rv.Allowed,
+                        Mode:       mode,
+                        Threshold:  threshold["revokeExternalGrants"],
+                        Dedup:      d,
+                        Journal:    j,
+                }))
+
+        s := cfg.CreateSnapshot
+        r.Register("create-snapshot", s.SupportedRules,
+                actions.CreateSnapshotHandler(actions.CreateSnapshotOptions{
+                        SupportedRules: s.SupportedRules,
+                        AllowOlderThan: s.AllowSnapshotOlderThan.Duration,
+                        Threshold:      threshold["createSnapshot"],
+                        Dedup:          d,
+                }))
+
+        // Quarantining cuts instances off the network, it's only done once opted in.
+        if q := cfg.QuarantineInstance; len(q.SupportedRules) > 0 {
+                r.Register("quarantine-instance", q.SupportedRules,
+                        actions.QuarantineInstanceHandler(actions.QuarantineInstanceOptions{
+                                SupportedRules:  q.SupportedRules,
+                                Tag:             q.Tag,
+                                ForensicsRanges: q.ForensicsRanges,
+                                Threshold:       threshold["quarantineInstance"],
+                                Dedup:           d,
+                        }))
+        }
+
+        // Blocking adds egress deny rules ahead of the network's own, it's only done once opted in.
+        if b := cfg.BlockIndicatorIPs; len(b.SupportedRules) > 0 {
+                r.Register("block-indicator-ips", b.SupportedRules,
+                        actions.BlockIndicatorIPsHandler(actions.BlockIndicatorIPsOptions{
+                                SupportedRules: b.SupportedRules,
+                                Threshold:      threshold["blockIndicatorIps"],
+                                Dedup:          d,
+                        }))
+        }
+
+        // Remediating health findings changes resources in place, it's only done once opted in.
+        if fw := cfg.CloseOpenFirewall; len(fw.SupportedRules) > 0 {
+                r.Register("close-open-firewall", fw.SupportedRules,
+                        actions.CloseOpenFirewallHandler(actions.CloseOpenFirewallOptions{
+                                SupportedRules: fw.SupportedRules,
+                                Threshold:      threshold["closeOpenFirewall"],
+                                Dedup:          d,
+                        }))
+        }
+        if pb := cfg.ClosePublicBucket; len(pb.SupportedRules) > 0 {
+                r.Register("close-public-bucket", pb.SupportedRules,
+                        actions.ClosePublicBucketHandler(actions.ClosePublicBucketOptions{
+                                SupportedRules: pb.SupportedRules,
+                                Threshold:      threshold["closePublicBucket"],
+                                Dedup:          d,
+                        }))
+        }
+
+        if cfg.Playbooks != "" {
+                b, err := ioutil.ReadFile(cfg.Playbooks)
+                if err != nil {
+                        return nil, fmt.Errorf("failed to read playbooks: %q", err)
+                }
+                steps := actions.PlaybookSteps(actions.PlaybookStepsOptions{
+                        AllowSnapshotOlderThan: s.AllowSnapshotOlderThan.Duration,
+                        FolderIDs:              rv.FolderIDs,
+                        Journal:                j,
+                })
+                playbooks, err := playbook.Load(b, steps)
+                if err != nil {
+                        return nil, err
+                }
+                r.Register("run-playbooks", nil, actions.RunPlaybooksHandler(actions.RunPlaybooksOptions{
+                        Playbooks: playbooks,
+                        Steps:     steps,
+                        Dedup:     d,
+                }))
+        }
+        return r, nil
+}
+
+// Dispatch is the entry point of the automation Cloud Function.
+//
+// Triggered by every finding published to the findings topic, the finding is read once
+// and each registered action interested in its rule, sub rule or audit log method is run:
+//
+//   - Event Threat Detection anomalous IAM grants and Cloud Audit Log SetIamPolicy entries
+//     revoke the external members added to the policy if they're of domains considered
+//     disallowed. These members must also be in one of the configured folders. This
+//     configuration allows you to take a remediation action only certain specific members
+//     and folders. For example, maybe you have a folder "development" where users can
+//     experiment and a folder "production". You may want to restrict and revoke external
+//     grants to the "production" folder and not restrict activity within "development".
+//   - Event Threat Detection findings of the configured rules snapshot the affected disks.
+//   - Event Threat Detection findings of the configured rules quarantine the affected
+//     instances, tagging them so deny-all firewall rules cut them off the network, if
+//     any rules are configured.
+//   - Event Threat Detection findings of the configured rules have their bad IPs added to
+//     egress deny firewall rules on the affected instances' networks, if any rules are
+//     configured.
+//   - Security Health Analytics OPEN_FIREWALL findings disable the reported firewall rules,
+//     if the category is configured.
+//   - Security Health Analytics PUBLIC_BUCKET_ACL findings remove allUsers and
+//     allAuthenticatedUsers from the reported bucket's ACL, if the category is configured.
+//   - Every finding is run through the configured playbooks, if any.
 //
-// In order for this revoke to be possible the generated service account must have the
+// When the configuration sets a dry run, reads still happen but the changes the actions
+// would make are logged as a plan instead of being made.
+//
+// In order for the revoke to be possible the generated service account must have the
 // appropriate permissions required. This can be accomplished in a few ways,
 // grant the service account permission at the orgainization, folder or
 // project level. For more information see README.md.
-func RevokeExternalGrants(ctx context.Context, m pubsub.Message) error {
+func Dispatch(ctx context.Context, m pubsub.Message) error {
         c := clients.New()
         if err := c.Initialize(); err != nil {
                 return fmt.Errorf("client initialize failed: %q", err)
         }
+        cfg, err := loadConfig()
+        if err != nil {
+                return err
+        }
+        if !cfg.DryRun {
+                results, err := registry.Dispatch(ctx, m, c)
+                logResults(results)
+                return err
+        }
 
-        return actions.RevokeExternalGrants(ctx, m, c, folderIDs, disallowed)
+        dry := clients.NewDryRun(c)
+        results, err := registry.Dispatch(ctx, m, dry)
+        logResults(results)
+        plan, merr := json.Marshal(dry.Plan())
+        if merr != nil {
+                return fmt.Errorf("failed to marshal plan: %q", merr)
+        }
+        log.Printf("dry run planned changes: %s", plan)
+        return err
 }
 
-// SnapshotDisk sets the entry point for cloud function.
-func SnapshotDisk(ctx context.Context, m pubsub.Message) error {
-        c := clients.New()
-        if err := c.Initialize(); err != nil {
-                return fmt.Errorf("client initialize failed: %q", err)
+// logResults logs the actions that succeeded, failures are returned by Dispatch.
+func logResults(results []actions.Result) {
+        for _, r := range results {
+                if r.Err == nil {
+                        log.Printf("action %s succeeded", r.Action)
+                }
         }
-        return actions.CreateSnapshot(ctx, m, c)
 }
\ No newline at end of file
@@ -0,0 +1,83 @@
+/*
+Package finding contains methods to deserialize and extract fields from findings.
+
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+        https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package finding
+
+import (
+        "bytes"
+        "encoding/json"
+        "strconv"
+)
+
+// stringList decodes a field ETD reports either as a single string or an array of strings.
+type stringList []string
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (s *stringList) UnmarshalJSON(b []byte) error {
+        if bytes.Equal(b, []byte("null")) {
+                return nil
+        }
+        var one string
+        if err := json.Unmarshal(b, &one); err == nil {
+                *s = stringList{one}
+                return nil
+        }
+        var many []string
+        if err := json.Unmarshal(b, &many); err != nil {
+                return err
+        }
+        *s = many
+        return nil
+}
+
+// flexString decodes a field ETD reports either as a string or a number.
+type flexString string
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (s *flexString) UnmarshalJSON(b []byte) error {
+        if bytes.Equal(b, []byte("null")) {
+                return nil
+        }
+        var str string
+        if err := json.Unmarshal(b, &str); err == nil {
+                *s = flexString(str)
+                return nil
+        }
+        var n json.Number
+        if err := json.Unmarshal(b, &n); err != nil {
+                return err
+        }
+        *s = flexString(n.String())
+        return nil
+}
+
+// flexInt decodes a field ETD reports either as a number or a numeric string.
+type flexInt int
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (i *flexInt) UnmarshalJSON(b []byte) error {
+        var s flexString
+        if err := s.UnmarshalJSON(b); err != nil || s == "" {
+                return err
+        }
+        n, err := strconv.Atoi(string(s))
+        if err != nil {
+                return err
+        }
+        *i = flexInt(n)
+        return nil
+}
\ No newline at end of file
@@ -0,0 +1,57 @@
+/*
+Package finding contains methods to deserialize and extract fields from findings.
+
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+        https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package finding
+
+import (
+        "encoding/json"
+        "reflect"
+        "testing"
+)
+
+// TestTolerantDecoding verifies fields reported with varying JSON types are decoded.
+func TestTolerantDecoding(t *testing.T) {
+        type fields struct {
+                List   stringList
+                String flexString
+                Int    flexInt
+        }
+        test := []struct {
+                name    string
+                input   string
+                exp     fields
+                wantErr bool
+        }{
+                {"scalar values", `{"list": "a", "string": "b", "int": 80}`, fields{stringList{"a"}, "b", 80}, false},
+                {"array and numeric string", `{"list": ["a", "b"], "string": 42, "int": "443"}`, fields{stringList{"a", "b"}, "42", 443}, false},
+                {"null values", `{"list": null, "string": null, "int": null}`, fields{}, false},
+                {"not a number", `{"int": "http"}`, fields{}, true},
+                {"not a list", `{"list": 1}`, fields{}, true},
+        }
+        for _, tt := range test {
+                t.Run(tt.name, func(t *testing.T) {
+                        var got fields
+                        err := json.Unmarshal([]byte(tt.input), &got)
+                        if (err != nil) != tt.wantErr {
+                                t.Fatalf("%s failed err:%v wantErr:%v", tt.name, err, tt.wantErr)
+                        }
+                        if !tt.wantErr && !reflect.DeepEqual(got, tt.exp) {
+                                t.Errorf("%s failed got:%+v want:%+v", tt.name, got, tt.exp)
+                        }
+                })
+        }
+}
\ No newline at end of file
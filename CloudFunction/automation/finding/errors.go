@@ -0,0 +1,209 @@
+/*
+Package finding contains methods to deserialize and extract fields from findings.
+
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+        https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package finding
+
+import (
+        "encoding/json"
+        "fmt"
+        "reflect"
+        "sort"
+        "strings"
+)
+
+// ParseError records why a finding, or one of its fields, could not be read.
+//
+// ParseError matches ErrorUnmarshal, ErrorParsing or ErrorValueNotFound with errors.Is.
+type ParseError struct {
+        // Field is the path of the offending field, for example "jsonPayload.properties.ip".
+        Field string
+        // Err is the sentinel error describing the kind of failure.
+        Err error
+        // Cause is the underlying error, if any.
+        Cause error
+}
+
+// Error implements the error interface.
+func (e *ParseError) Error() string {
+        msg := e.Err.Error()
+        if e.Field != "" {
+                msg += " " + e.Field
+        }
+        if e.Cause != nil {
+                msg += ": " + e.Cause.Error()
+        }
+        return msg
+}
+
+// Is reports whether the target is the sentinel error describing this failure.
+func (e *ParseError) Is(target error) bool {
+        return target == e.Err
+}
+
+// Unwrap returns the underlying error.
+func (e *ParseError) Unwrap() error {
+        return e.Cause
+}
+
+// decode unmarshals the data into v, returning a ParseError naming the offending field on failure.
+func decode(data []byte, v interface{}) error {
+        if err := json.Unmarshal(data, v); err != nil {
+                return unmarshalError(err, data, v)
+        }
+        return nil
+}
+
+// unmarshalError returns a ParseError for a failure to decode the data into v.
+//
+// The offending field is found by walking the data alongside v's type, the field
+// reported by encoding/json varies between Go releases. It is left empty if v is
+// nil or the data is not valid JSON.
+func unmarshalError(err error, data []byte, v interface{}) *ParseError {
+        pe := &ParseError{Err: ErrorUnmarshal, Cause: err}
+        if v == nil {
+                return pe
+        }
+        var doc interface{}
+        if json.Unmarshal(data, &doc) != nil {
+                return pe
+        }
+        if path, ok := mismatch(doc, reflect.TypeOf(v), ""); ok {
+                pe.Field = path
+        }
+        return pe
+}
+
+// unmarshalerType is the type of values decoding themselves.
+var unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// mismatch returns the path of the first value that cannot be decoded into its Go type.
+//
+// Paths use the keys of the data, elements of arrays are indexed, for example
+// "jsonPayload.affectedResources[0].gcpResourceName".
+func mismatch(v interface{}, t reflect.Type, path string) (string, bool) {
+        for t.Kind() == reflect.Ptr {
+                t = t.Elem()
+        }
+        if v == nil {
+                return "", false
+        }
+        if reflect.PtrTo(t).Implements(unmarshalerType) {
+                return leafMismatch(v, t, path)
+        }
+        switch t.Kind() {
+        case reflect.Struct:
+                obj, ok := v.(map[string]interface{})
+                if !ok {
+                        return path, true
+                }
+                for _, k := range sortedKeys(obj) {
+                        ft, ok := structField(t, k)
+                        if !ok {
+                                continue
+                        }
+                        if p, ok := mismatch(obj[k], ft, joinPath(path, k)); ok {
+                                return p, true
+                        }
+                }
+                return "", false
+        case reflect.Map:
+                obj, ok := v.(map[string]interface{})
+                if !ok {
+                        return path, true
+                }
+                for _, k := range sortedKeys(obj) {
+                        if p, ok := mismatch(obj[k], t.Elem(), joinPath(path, k)); ok {
+                                return p, true
+                        }
+                }
+                return "", false
+        case reflect.Slice, reflect.Array:
+                if t.Elem().Kind() == reflect.Uint8 {
+                        return leafMismatch(v, t, path)
+                }
+                list, ok := v.([]interface{})
+                if !ok {
+                        return path, true
+                }
+                for i, e := range list {
+                        if p, ok := mismatch(e, t.Elem(), fmt.Sprintf("%s[%d]", path, i)); ok {
+                                return p, true
+                        }
+                }
+                return "", false
+        }
+        return leafMismatch(v, t, path)
+}
+
+// leafMismatch returns the path if the value cannot be decoded into the type.
+func leafMismatch(v interface{}, t reflect.Type, path string) (string, bool) {
+        b, err := json.Marshal(v)
+        if err != nil {
+                return path, true
+        }
+        if err := json.Unmarshal(b, reflect.New(t).Interface()); err != nil {
+                return path, true
+        }
+        return "", false
+}
+
+// structField returns the type of the struct field encoding/json decodes the key into.
+func structField(t reflect.Type, key string) (reflect.Type, bool) {
+        for i := 0; i < t.NumField(); i++ {
+                f := t.Field(i)
+                name := f.Name
+                if tag := f.Tag.Get("json"); tag != "" {
+                        if tag == "-" {
+                                continue
+                        }
+                        if n := strings.Split(tag, ",")[0]; n != "" {
+                                name = n
+                        }
+                }
+                if f.Anonymous && f.Tag.Get("json") == "" && f.Type.Kind() == reflect.Struct {
+                        if ft, ok := structField(f.Type, key); ok {
+                                return ft, true
+                        }
+                        continue
+                }
+                if f.PkgPath != "" {
+                        continue
+                }
+                if strings.EqualFold(name, key) {
+                        return f.Type, true
+                }
+        }
+        return nil, false
+}
+
+// sortedKeys returns the keys of the object in order.
+func sortedKeys(obj map[string]interface{}) []string {
+        keys := make([]string, 0, len(obj))
+        for k := range obj {
+                keys = append(keys, k)
+        }
+        sort.Strings(keys)
+        return keys
+}
+
+// joinPath appends the key to the path.
+func joinPath(path, key string) string {
+        if path == "" {
+                return key
+        }
+        return path + "." + key
+}
\ No newline at end of file
@@ -0,0 +1,108 @@
+/*
+Package finding contains methods to deserialize and extract fields from findings.
+
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+        https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package finding
+
+import (
+        "encoding/json"
+        "time"
+)
+
+// EventVersion is the version of the Event schema, bumped on any incompatible change.
+const EventVersion = "1"
+
+// Sources of an Event.
+const (
+        SourceETD   = "event_threat_detection"
+        SourceSCC   = "security_command_center"
+        SourceAudit = "cloud_audit_log"
+)
+
+// Event is a finding normalized into a stable schema for consumers such as a SIEM.
+type Event struct {
+        Version     string          `json:"version"`
+        ID          string          `json:"id"`
+        Source      string          `json:"source"`
+        Category    string          `json:"category"`
+        SubCategory string          `json:"subCategory,omitempty"`
+        Severity    string          `json:"severity"`
+        Priority    string          `json:"priority"`
+        Time        time.Time       `json:"time"`
+        Principal   string          `json:"principal,omitempty"`
+        Members     []string        `json:"members,omitempty"`
+        Resources   []Resource      `json:"resources"`
+        Network     *EventNetwork   `json:"network,omitempty"`
+        Raw         json.RawMessage `json:"raw"`
+}
+
+// EventNetwork holds the network indicators of an Event.
+type EventNetwork struct {
+        Instance       string   `json:"instance,omitempty"`
+        IPs            []string `json:"ips,omitempty"`
+        Domains        []string `json:"domains,omitempty"`
+        SrcIP          string   `json:"srcIp,omitempty"`
+        SrcPort        int      `json:"srcPort,omitempty"`
+        DestIP         string   `json:"destIp,omitempty"`
+        DestPort       int      `json:"destPort,omitempty"`
+        Protocol       int      `json:"protocol,omitempty"`
+        SubnetworkID   string   `json:"subnetworkId,omitempty"`
+        SubnetworkName string   `json:"subnetworkName,omitempty"`
+}
+
+// Event returns the finding normalized into an Event.
+func (f *Finding) Event() *Event {
+        e := &Event{
+                Version:     EventVersion,
+                ID:          f.InsertID(),
+                Source:      SourceETD,
+                Category:    f.RuleName(),
+                SubCategory: f.SubRuleName(),
+                Severity:    f.Severity().String(),
+                Priority:    f.Priority().String(),
+                Time:        f.EventTime(),
+                Resources:   f.AffectedResources(),
+                Raw:         json.RawMessage(f.raw),
+        }
+        switch {
+        case f.sd.LogName == "":
+                e.Source = SourceSCC
+        case f.MethodName() != "":
+                e.Source = SourceAudit
+                e.Category = f.MethodName()
+                e.Principal = f.PrincipalEmail()
+                e.Members = f.AddedMembers()
+        }
+        if ext, ok := f.subRuleProperties.(*externalMemberAdded); ok {
+                e.Principal = ext.JSONPayload.Properties.PrincipalEmail
+                e.Members = ext.JSONPayload.Properties.ExternalMembers
+        }
+        if f.isNetworkFinding() {
+                e.Network = &EventNetwork{
+                        Instance:       f.Instance(),
+                        IPs:            f.BadIPs(),
+                        Domains:        f.Domains(),
+                        SrcIP:          f.SrcIP(),
+                        SrcPort:        f.SrcPort(),
+                        DestIP:         f.DestIP(),
+                        DestPort:       f.DestPort(),
+                        Protocol:       f.Protocol(),
+                        SubnetworkID:   f.SubnetworkID(),
+                        SubnetworkName: f.SubnetworkName(),
+                }
+        }
+        return e
+}
\ No newline at end of file
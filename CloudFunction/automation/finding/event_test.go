@@ -0,0 +1,146 @@
+/*
+Package finding contains methods to deserialize and extract fields from findings.
+
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+        https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package finding
+
+import (
+        "encoding/json"
+        "reflect"
+        "testing"
+
+        "cloud.google.com/go/pubsub"
+)
+
+const badDomainFinding = `{
+        "insertId": "qnjgp6a7",
+        "jsonPayload": {
+                "affectedResources": [{"gcpResourceName": "//cloudresourcemanager.googleapis.com/projects/aerial-jigsaw-235219"}],
+                "detectionCategory": {"indicator": "domain", "ruleName": "bad_domain", "technique": "Malware"},
+                "detectionPriority": "HIGH",
+                "eventTime": "2019-07-16T16:27:49.644Z",
+                "properties": {
+                        "destIp": "118.184.176.25",
+                        "destPort": 80,
+                        "domain": ["3322.org"],
+                        "ip": "118.184.176.25",
+                        "location": "us-central1-c",
+                        "project_id": "aerial-jigsaw-235219",
+                        "protocol": 6,
+                        "sourceInstance": "/projects/aerial-jigsaw-235219/zones/us-central1-c/instances/instance-2",
+                        "srcIp": "10.128.0.2",
+                        "srcPort": 40208,
+                        "subnetwork_id": "288355645352614400",
+                        "subnetwork_name": "default"
+                }
+        },
+        "logName": "projects/aerial-jigsaw-235219/logs/threatdetection.googleapis.com%2Fdetection",
+        "severity": "CRITICAL"
+}`
+
+// TestEvent verifies findings are normalized into the event schema.
+func TestEvent(t *testing.T) {
+        test := []struct {
+                name    string
+                message *pubsub.Message
+                exp     string
+        }{
+                {
+                        "bad domain",
+                        &pubsub.Message{Data: []byte(badDomainFinding)},
+                        `{
+                                "version": "1",
+                                "id": "qnjgp6a7",
+                                "source": "event_threat_detection",
+                                "category": "bad_domain",
+                                "severity": "CRITICAL",
+                                "priority": "HIGH",
+                                "time": "2019-07-16T16:27:49.644Z",
+                                "resources": [{
+                                        "fullName": "//cloudresourcemanager.googleapis.com/projects/aerial-jigsaw-235219",
+                                        "type": "projects",
+                                        "project": "aerial-jigsaw-235219",
+                                        "name": "aerial-jigsaw-235219"
+                                }],
+                                "network": {
+                                        "instance": "instance-2",
+                                        "ips": ["118.184.176.25"],
+                                        "domains": ["3322.org"],
+                                        "srcIp": "10.128.0.2",
+                                        "srcPort": 40208,
+                                        "destIp": "118.184.176.25",
+                                        "destPort": 80,
+                                        "protocol": 6,
+                                        "subnetworkId": "288355645352614400",
+                                        "subnetworkName": "default"
+                                },
+                                "raw": ` + badDomainFinding + `
+                        }`,
+                },
+                {
+                        "external member from scc",
+                        genSCCMessage("iam_anomalous_grant", `{
+                                "detectionCategory_subRuleName": "external_member_added_to_policy",
+                                "properties_principalEmail": "admin@example.com",
+                                "properties_externalMembers": ["user:tom@gmail.com"]
+                        }`),
+                        `{
+                                "version": "1",
+                                "id": "organizations/154584661726/sources/2299436883026055247/findings/f1",
+                                "source": "security_command_center",
+                                "category": "iam_anomalous_grant",
+                                "subCategory": "external_member_added_to_policy",
+                                "severity": "ERROR",
+                                "priority": "HIGH",
+                                "time": "2019-07-16T21:00:44.76Z",
+                                "principal": "admin@example.com",
+                                "members": ["user:tom@gmail.com"],
+                                "resources": [{
+                                        "fullName": "//cloudresourcemanager.googleapis.com/projects/997507777601",
+                                        "type": "projects",
+                                        "project": "997507777601",
+                                        "name": "997507777601"
+                                }]
+                        }`,
+                },
+        }
+        for _, tt := range test {
+                t.Run(tt.name, func(t *testing.T) {
+                        f := NewFinding()
+                        if err := f.ReadFinding(tt.message); err != nil {
+                                t.Fatalf("failed reading finding: %q", err)
+                        }
+                        b, err := json.Marshal(f.Event())
+                        if err != nil {
+                                t.Fatalf("failed to marshal event: %q", err)
+                        }
+                        var got, exp map[string]interface{}
+                        if err := json.Unmarshal(b, &got); err != nil {
+                                t.Fatalf("failed to unmarshal event: %q", err)
+                        }
+                        if err := json.Unmarshal([]byte(tt.exp), &exp); err != nil {
+                                t.Fatalf("failed to unmarshal expected event: %q", err)
+                        }
+                        // The raw payload is only compared when it's expected.
+                        if _, ok := exp["raw"]; !ok {
+                                delete(got, "raw")
+                        }
+                        if !reflect.DeepEqual(got, exp) {
+                                t.Errorf("%s failed got:%s", tt.name, b)
+                        }
+                })
+        }
+}
\ No newline at end of file
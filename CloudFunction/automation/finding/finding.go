@@ -20,9 +20,11 @@ package finding
 import (
         "encoding/json"
         "errors"
+        "fmt"
 
         "regexp"
         "strings"
+        "time"
 
         "cloud.google.com/go/pubsub"
 )
@@ -30,8 +32,20 @@ import (
 const (
         // ETDFindingSuffix is the log name suffix used by Event Threat Detection's findings.
         ETDFindingSuffix = "/logs/threatdetection.googleapis.com%2Fdetection"
+        // AuditLogSuffix is the log name suffix used by Cloud Audit Logs' admin activity entries.
+        AuditLogSuffix = "/logs/cloudaudit.googleapis.com%2Factivity"
+        // MethodSetIamPolicy is the audit log method name of an IAM policy change.
+        MethodSetIamPolicy = "SetIamPolicy"
+        // DeltaAdd is the action of a binding delta granting a role to a member.
+        DeltaAdd = "ADD"
+        // DeltaRemove is the action of a binding delta revoking a role from a member.
+        DeltaRemove = "REMOVE"
 )
 
+// payloadGroups contains the ETD payload objects SCC flattens into source properties,
+// for example "properties_project_id" holds the finding's "properties.project_id".
+var payloadGroups = []string{"properties", "detectionCategory", "sourceId"}
+
 var (
         // ErrorUnmarshal thrown when unable to unmarshal.
         ErrorUnmarshal = errors.New("failed to unmarshal")
@@ -45,6 +59,8 @@ var (
         extractResource = regexp.MustCompile(`/([^/]+?/[^/]+?)?$`)
         // extractInstance used to extract a instance.
         extractInstance = regexp.MustCompile(`/instances/(.*)$`)
+        // extractZone used to extract the zone of an instance.
+        extractZone = regexp.MustCompile(`/zones/([^/]+)/`)
 )
 
 // stackdriverLog struct is the struct fit in only for the log from SD.
@@ -53,11 +69,16 @@ type stackdriverLog struct {
 }
 
 type etdLog struct {
+        Severity    string
         JSONPayload struct {
                 DetectionCategory struct {
                         SubRuleName string
                         RuleName    string
+                        Technique   string
+                        Indicator   string
                 }
+                DetectionPriority string
+                EventTime         string
                 AffectedResources []struct {
                         GCPResourceName string
                 }
@@ -67,11 +88,68 @@ type etdLog struct {
         }
 }
 
+// sccNotification is a finding published by Security Command Center's continuous export.
+type sccNotification struct {
+        NotificationConfigName string
+        Finding                struct {
+                Name             string
+                Parent           string
+                ResourceName     string
+                State            string
+                Category         string
+                Severity         string
+                EventTime        string
+                SourceProperties map[string]json.RawMessage
+        }
+}
+
+// auditLog is a Cloud Audit Log admin activity entry.
+type auditLog struct {
+        Timestamp string
+        Resource  struct {
+                Labels struct {
+                        ProjectID string `json:"project_id"`
+                }
+        }
+        ProtoPayload struct {
+                MethodName         string
+                ServiceName        string
+                ResourceName       string
+                AuthenticationInfo struct {
+                        PrincipalEmail string
+                }
+                ServiceData struct {
+                        PolicyDelta struct {
+                                BindingDeltas []BindingDelta
+                        }
+                }
+        }
+}
+
+// BindingDelta is a single change made to an IAM policy.
+type BindingDelta struct {
+        // Action is either DeltaAdd or DeltaRemove.
+        Action string `json:"action"`
+        Role   string `json:"role"`
+        Member string `json:"member"`
+        // Condition is set when the change was made to a conditional binding.
+        Condition *Condition `json:"condition,omitempty"`
+}
+
+// Condition is the condition of a conditional IAM binding.
+type Condition struct {
+        Expression  string `json:"expression"`
+        Title       string `json:"title,omitempty"`
+        Description string `json:"description,omitempty"`
+}
+
 // Anomalous IAM grant external member added sub rule properties.
 type externalMemberAdded struct {
         JSONPayload struct {
                 Properties struct {
                         ExternalMembers []string
+                        PrincipalEmail  string
+                        BindingDeltas   []BindingDelta
                 }
         }
 }
@@ -82,21 +160,91 @@ type badNetworkFinding struct {
                 Properties struct {
                         Location       string
                         SourceInstance string
-                        IP             []string
+                        IP             stringList
+                        Domain         stringList
+                        DestIP         string
+                        DestPort       flexInt
+                        SrcIP          string
+                        SrcPort        flexInt
+                        Protocol       flexInt
+                        SubnetworkID   flexString `json:"subnetwork_id"`
+                        SubnetworkName string     `json:"subnetwork_name"`
+                }
+        }
+}
+
+// sshBruteForceFinding contains the properties of ETD's SSH brute force rule.
+type sshBruteForceFinding struct {
+        JSONPayload struct {
+                Properties struct {
+                        ProjectID      string `json:"project_id"`
+                        Location       string
+                        SourceInstance string
+                        LoginAttempts  []struct {
+                                AuthResult string
+                                SourceIP   string
+                                UserName   string
+                                VMName     string
+                        }
+                        DestPort flexInt
+                        Protocol flexInt
                 }
         }
 }
 
+// network returns the brute force attempts as a network finding with the attackers as bad IPs.
+func (s *sshBruteForceFinding) network() *badNetworkFinding {
+        const (
+                sshPort     = 22
+                tcpProtocol = 6
+        )
+        p := s.JSONPayload.Properties
+        bn := &badNetworkFinding{}
+        np := &bn.JSONPayload.Properties
+        np.Location = p.Location
+        np.SourceInstance = p.SourceInstance
+        np.DestPort, np.Protocol = p.DestPort, p.Protocol
+        if np.DestPort == 0 {
+                np.DestPort = sshPort
+        }
+        if np.Protocol == 0 {
+                np.Protocol = tcpProtocol
+        }
+        seen := map[string]bool{}
+        for _, a := range p.LoginAttempts {
+                if np.SourceInstance == "" && a.VMName != "" {
+                        np.SourceInstance = fmt.Sprintf("/projects/%s/zones/%s/instances/%s", p.ProjectID, p.Location, a.VMName)
+                }
+                if a.SourceIP == "" || seen[a.SourceIP] {
+                        continue
+                }
+                seen[a.SourceIP] = true
+                np.IP = append(np.IP, a.SourceIP)
+        }
+        if len(np.IP) > 0 {
+                np.SrcIP = np.IP[0]
+        }
+        return bn
+}
+
 // Finding struct setting.
 type Finding struct {
+        // Message data as it was received.
+        raw []byte
+        // Log entry the properties are read from, SCC notifications are converted to this shape.
+        data []byte
         // Properties associated with Stackdriver.
         sd stackdriverLog
+        // Properties associated with a Security Command Center notification.
+        scc sccNotification
         // Properties associated with an ETD finding.
         etd etdLog
-        // Properties for ETD's anomalous IAM grant detector sub rule 'external member added to policy'.
-        ext externalMemberAdded
-        // Properties for any ETD finding based off VPC flow logs.
-        badNetwork badNetworkFinding
+        // Properties associated with a Cloud Audit Log entry.
+        audit auditLog
+        // Properties decoded by the parser registered for the finding's rule.
+        ruleProperties interface{}
+        // Properties decoded by the parser registered for the finding's sub rule.
+        subRuleProperties interface{}
 }
 
 // NewFinding returns a new finding.
@@ -105,51 +253,164 @@ func NewFinding() *Finding {
 }
 
 // ReadFinding unmarshals a finding from PubSub.
+//
+// The message may either be an ETD finding exported from Stackdriver, a
+// Security Command Center notification or a Cloud Audit Log entry. They are read
+// into the same fields so the accessors behave the same regardless of how the
+// finding was delivered. Audit log entries have no rule, see MethodName.
 func (f *Finding) ReadFinding(m *pubsub.Message) error {
-        if err := json.Unmarshal(m.Data, &f.sd); err != nil {
-                return ErrorUnmarshal
+        if err := decode(m.Data, &f.sd); err != nil {
+                return err
+        }
+
+        f.raw = m.Data
+        data := m.Data
+        switch {
+        case strings.HasSuffix(f.sd.LogName, AuditLogSuffix):
+                if err := decode(m.Data, &f.audit); err != nil {
+                        return err
+                }
+                if f.audit.ProtoPayload.MethodName == "" {
+                        return &ParseError{Field: "protoPayload.methodName", Err: ErrorValueNotFound, Cause: errors.New("audit log entry has no method")}
+                }
+        case f.sd.LogName != "":
+                if !strings.HasSuffix(f.sd.LogName, ETDFindingSuffix) {
+                        return &ParseError{Field: "logName", Err: ErrorParsing, Cause: fmt.Errorf("%q is not an ETD finding", f.sd.LogName)}
+                }
+        default:
+                if err := decode(m.Data, &f.scc); err != nil {
+                        return err
+                }
+                if f.scc.Finding.Name == "" {
+                        return &ParseError{Field: "logName", Err: ErrorParsing, Cause: errors.New("neither a Stackdriver log nor an SCC notification")}
+                }
+                b, err := f.scc.log()
+                if err != nil {
+                        return &ParseError{Field: "finding.sourceProperties", Err: ErrorUnmarshal, Cause: err}
+                }
+                data = b
         }
+        f.data = data
 
-        if f.sd.LogName == "" {
-                return ErrorParsing
+        if err := decode(data, &f.etd); err != nil {
+                return err
         }
 
-        if !strings.HasSuffix(f.sd.LogName, ETDFindingSuffix) {
-                return ErrorParsing
+        dc := f.etd.JSONPayload.DetectionCategory
+        p, err := parse(ruleParser(dc.RuleName), data)
+        if err != nil {
+                return err
         }
+        f.ruleProperties = p
 
-        if err := json.Unmarshal(m.Data, &f.etd); err != nil {
-                return ErrorUnmarshal
+        sp, err := parse(subRuleParser(dc.SubRuleName), data)
+        if err != nil {
+                return err
         }
+        f.subRuleProperties = sp
+
+        return nil
+}
 
-        switch f.etd.JSONPayload.DetectionCategory.SubRuleName {
-        // case for external user granted as project editor.
-        case "external_member_added_to_policy":
-                if err := json.Unmarshal(m.Data, &f.ext); err != nil {
-                        return ErrorUnmarshal
+// log returns the notification's finding in the shape of an ETD log entry.
+//
+// SCC stores the ETD payload as flattened source properties, these are nested
+// back into their payload objects. The finding's category, resource name and severity
+// are used when the source properties do not carry a rule name, affected resources or
+// detection priority.
+func (n *sccNotification) log() ([]byte, error) {
+        payload := map[string]interface{}{}
+        for k, v := range n.Finding.SourceProperties {
+                group, key := splitSourceProperty(k)
+                if group == "" {
+                        payload[k] = v
+                        continue
                 }
-        // case for external user granted as project owner.
-        case "external_member_invited_to_policy":
-                if err := json.Unmarshal(m.Data, &f.ext); err != nil {
-                        return ErrorUnmarshal
+                g, ok := payload[group].(map[string]interface{})
+                if !ok {
+                        g = map[string]interface{}{}
+                        payload[group] = g
                 }
+                g[key] = v
         }
 
-        switch f.etd.JSONPayload.DetectionCategory.RuleName {
-        case "bad_ip":
-                fallthrough
-        case "bad_domain":
-                if err := json.Unmarshal(m.Data, &f.badNetwork); err != nil {
-                        return ErrorUnmarshal
+        dc, ok := payload["detectionCategory"].(map[string]interface{})
+        if !ok {
+                dc = map[string]interface{}{}
+                payload["detectionCategory"] = dc
+        }
+        if _, ok := dc["ruleName"]; !ok {
+                dc["ruleName"] = n.Finding.Category
+        }
+        if _, ok := payload["affectedResources"]; !ok && n.Finding.ResourceName != "" {
+                payload["affectedResources"] = []map[string]string{{"gcpResourceName": n.Finding.ResourceName}}
+        }
+        if _, ok := payload["eventTime"]; !ok && n.Finding.EventTime != "" {
+                payload["eventTime"] = n.Finding.EventTime
+        }
+        entry := map[string]interface{}{"jsonPayload": payload}
+        if s, ok := sccSeverities[n.Finding.Severity]; ok {
+                entry["severity"] = s.severity.String()
+                if _, ok := payload["detectionPriority"]; !ok {
+                        payload["detectionPriority"] = s.priority.String()
                 }
         }
+        return json.Marshal(entry)
+}
 
-        return nil
+// splitSourceProperty splits a flattened source property into its payload group and key.
+func splitSourceProperty(k string) (string, string) {
+        for _, g := range payloadGroups {
+                if strings.HasPrefix(k, g+"_") {
+                        return g, strings.TrimPrefix(k, g+"_")
+                }
+        }
+        return "", k
+}
+
+// InsertID returns the unique ID of the finding's log entry.
+//
+// Findings from Security Command Center have no log entry and use the finding's name instead.
+func (f *Finding) InsertID() string {
+        if f.sd.InsertID == "" {
+                return f.scc.Finding.Name
+        }
+        return f.sd.InsertID
+}
+
+// SubRuleName returns the sub rule name.
+func (f *Finding) SubRuleName() string {
+        return f.etd.JSONPayload.DetectionCategory.SubRuleName
+}
+
+// Name returns the name of the finding within Security Command Center.
+func (f *Finding) Name() string {
+        return f.scc.Finding.Name
+}
+
+// State returns the Security Command Center state of the finding.
+func (f *Finding) State() string {
+        return f.scc.Finding.State
+}
+
+// Category returns the Security Command Center category of the finding.
+func (f *Finding) Category() string {
+        return f.scc.Finding.Category
 }
 
 // ProjectID returns the projectID of the affected project.
 func (f *Finding) ProjectID() string {
-        return f.etd.JSONPayload.Properties.ProjectID
+        if id := f.etd.JSONPayload.Properties.ProjectID; id != "" {
+                return id
+        }
+        if id := f.healthProperties().JSONPayload.ProjectID; id != "" {
+                return id
+        }
+        if id := f.audit.Resource.Labels.ProjectID; id != "" {
+                return id
+        }
+        // SCC notifications may omit the project properties, their resource still names it.
+        return parseResource(f.scc.Finding.ResourceName).Project
 }
 
 // ProjectNumber returns the project number of the affected resource.
@@ -179,17 +440,120 @@ func (f *Finding) Resource() string {
 
 }
 
+// AffectedResources returns every resource affected by the finding.
+func (f *Finding) AffectedResources() []Resource {
+        rs := []Resource{}
+        for _, a := range f.etd.JSONPayload.AffectedResources {
+                rs = append(rs, parseResource(a.GCPResourceName))
+        }
+        if p := f.audit.ProtoPayload; p.ResourceName != "" {
+                rs = append(rs, parseResource("//"+p.ServiceName+"/"+p.ResourceName))
+        }
+        return rs
+}
+
+// RuleProperties returns the properties decoded by the parser registered for the finding's rule.
+//
+// The value is nil if no parser is registered, otherwise it's whatever the parser returned.
+func (f *Finding) RuleProperties() interface{} {
+        return f.ruleProperties
+}
+
+// SubRuleProperties returns the properties decoded by the parser registered for the finding's sub rule.
+//
+// The value is nil if no parser is registered, otherwise it's whatever the parser returned.
+func (f *Finding) SubRuleProperties() interface{} {
+        return f.subRuleProperties
+}
+
 // ExternalUsers returns the external members found from an anomalous IAM grant.
 func (f *Finding) ExternalUsers() []string {
-        if f.ext.JSONPayload.Properties.ExternalMembers == nil {
+        ext, ok := f.subRuleProperties.(*externalMemberAdded)
+        if !ok || ext.JSONPayload.Properties.ExternalMembers == nil {
                 return []string{}
         }
-        return f.ext.JSONPayload.Properties.ExternalMembers
+        return ext.JSONPayload.Properties.ExternalMembers
+}
+
+// MethodName returns the method of an audit log entry, for example MethodSetIamPolicy.
+//
+// It's empty for findings that aren't audit log entries.
+func (f *Finding) MethodName() string {
+        return f.audit.ProtoPayload.MethodName
+}
+
+// PrincipalEmail returns the email of the principal that made the audit logged change.
+func (f *Finding) PrincipalEmail() string {
+        return f.audit.ProtoPayload.AuthenticationInfo.PrincipalEmail
+}
+
+// BindingDeltas returns the IAM policy changes recorded by an audit log entry or
+// reported by an anomalous IAM grant.
+func (f *Finding) BindingDeltas() []BindingDelta {
+        if f.MethodName() != "" {
+                return f.audit.ProtoPayload.ServiceData.PolicyDelta.BindingDeltas
+        }
+        if ext, ok := f.subRuleProperties.(*externalMemberAdded); ok {
+                return ext.JSONPayload.Properties.BindingDeltas
+        }
+        return nil
+}
+
+// AddedMembers returns the distinct members granted a role by the binding deltas.
+func (f *Finding) AddedMembers() []string {
+        members := []string{}
+        seen := map[string]bool{}
+        for _, d := range f.BindingDeltas() {
+                if d.Action != DeltaAdd || seen[d.Member] {
+                        continue
+                }
+                seen[d.Member] = true
+                members = append(members, d.Member)
+        }
+        return members
+}
+
+// GrantedRoles returns the distinct roles the binding deltas granted to the member.
+func (f *Finding) GrantedRoles(member string) []string {
+        roles := []string{}
+        seen := map[string]bool{}
+        for _, d := range f.BindingDeltas() {
+                if d.Action != DeltaAdd || d.Member != member || seen[d.Role] {
+                        continue
+                }
+                seen[d.Role] = true
+                roles = append(roles, d.Role)
+        }
+        return roles
+}
+
+// AddedRoles returns the distinct roles granted by the binding deltas.
+func (f *Finding) AddedRoles() []string {
+        roles := []string{}
+        seen := map[string]bool{}
+        for _, d := range f.BindingDeltas() {
+                if d.Action != DeltaAdd || seen[d.Role] {
+                        continue
+                }
+                seen[d.Role] = true
+                roles = append(roles, d.Role)
+        }
+        return roles
 }
 
 // Zone returns the zone of affected project.
+//
+// The zone of the source instance is used when the finding has no location.
 func (f *Finding) Zone() string {
-        return f.badNetwork.JSONPayload.Properties.Location
+        p := f.badNetworkProperties().JSONPayload.Properties
+        if p.Location != "" {
+                return p.Location
+        }
+        z := extractZone.FindStringSubmatch(p.SourceInstance)
+        if len(z) != 2 {
+                return ""
+        }
+        return z[1]
 }
 
 // RuleName returns the rule name.
@@ -197,9 +561,52 @@ func (f *Finding) RuleName() string {
         return f.etd.JSONPayload.DetectionCategory.RuleName
 }
 
+// Technique returns the technique ETD attributes to the finding, for example "Malware".
+func (f *Finding) Technique() string {
+        return f.etd.JSONPayload.DetectionCategory.Technique
+}
+
+// Indicator returns the kind of indicator that triggered the finding, for example "domain".
+func (f *Finding) Indicator() string {
+        return f.etd.JSONPayload.DetectionCategory.Indicator
+}
+
+// Severity returns the severity of the finding's log entry.
+func (f *Finding) Severity() Severity {
+        s, err := ParseSeverity(f.etd.Severity)
+        if err != nil {
+                return SeverityDefault
+        }
+        return s
+}
+
+// Priority returns the detection priority of the finding.
+func (f *Finding) Priority() Priority {
+        p, err := ParsePriority(f.etd.JSONPayload.DetectionPriority)
+        if err != nil {
+                return f.healthPriority()
+        }
+        return p
+}
+
+// EventTime returns the time the detected activity occurred.
+//
+// The zero time is returned if the finding has no event time or it cannot be parsed.
+func (f *Finding) EventTime() time.Time {
+        ts := f.etd.JSONPayload.EventTime
+        if ts == "" {
+                ts = f.audit.Timestamp
+        }
+        t, err := time.Parse(time.RFC3339Nano, ts)
+        if err != nil {
+                return time.Time{}
+        }
+        return t
+}
+
 // Instance returns the instance of affected project.
 func (f *Finding) Instance() string {
-        aff := f.badNetwork.JSONPayload.Properties.SourceInstance
+        aff := f.badNetworkProperties().JSONPayload.Properties.SourceInstance
         if aff == "" {
                 return ""
         }
@@ -211,6 +618,79 @@ func (f *Finding) Instance() string {
 }
 
 // BadIPs returns a slice of bad ip.
+//
+// These are the remote hosts of the finding: the bad IPs resolved, the mining pools, the DoS
+// targets or the SSH brute force attackers.
 func (f *Finding) BadIPs() []string {
-        return f.badNetwork.JSONPayload.Properties.IP
+        return f.badNetworkProperties().JSONPayload.Properties.IP
+}
+
+// badNetworkProperties returns the properties of a finding based off VPC flow logs.
+//
+// Rules reporting activity between an instance and remote hosts are read as a network finding.
+func (f *Finding) badNetworkProperties() *badNetworkFinding {
+        switch p := f.ruleProperties.(type) {
+        case *badNetworkFinding:
+                return p
+        case *sshBruteForceFinding:
+                return p.network()
+        }
+        return &badNetworkFinding{}
+}
+
+// isNetworkFinding returns whether the finding reports activity between an instance and remote hosts.
+func (f *Finding) isNetworkFinding() bool {
+        switch f.ruleProperties.(type) {
+        case *badNetworkFinding, *sshBruteForceFinding:
+                return true
+        }
+        return false
+}
+
+// AttackerIPs returns the IPs that attempted to log in to the instance during an SSH brute force.
+func (f *Finding) AttackerIPs() []string {
+        if _, ok := f.ruleProperties.(*sshBruteForceFinding); !ok {
+                return nil
+        }
+        return f.BadIPs()
+}
+
+// Domains returns the bad domains the instance resolved.
+func (f *Finding) Domains() []string {
+        return f.badNetworkProperties().JSONPayload.Properties.Domain
+}
+
+// SrcIP returns the source IP of the reported connection.
+func (f *Finding) SrcIP() string {
+        return f.badNetworkProperties().JSONPayload.Properties.SrcIP
+}
+
+// SrcPort returns the source port of the reported connection.
+func (f *Finding) SrcPort() int {
+        return int(f.badNetworkProperties().JSONPayload.Properties.SrcPort)
+}
+
+// DestIP returns the destination IP of the reported connection.
+func (f *Finding) DestIP() string {
+        return f.badNetworkProperties().JSONPayload.Properties.DestIP
+}
+
+// DestPort returns the destination port of the reported connection.
+func (f *Finding) DestPort() int {
+        return int(f.badNetworkProperties().JSONPayload.Properties.DestPort)
+}
+
+// Protocol returns the IP protocol number of the reported connection.
+func (f *Finding) Protocol() int {
+        return int(f.badNetworkProperties().JSONPayload.Properties.Protocol)
+}
+
+// SubnetworkID returns the ID of the subnetwork the connection was observed in.
+func (f *Finding) SubnetworkID() string {
+        return string(f.badNetworkProperties().JSONPayload.Properties.SubnetworkID)
+}
+
+// SubnetworkName returns the name of the subnetwork the connection was observed in.
+func (f *Finding) SubnetworkName() string {
+        return f.badNetworkProperties().JSONPayload.Properties.SubnetworkName
 }
\ No newline at end of file
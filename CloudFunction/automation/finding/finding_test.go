@@ -18,6 +18,7 @@ limitations under the License.
 package finding
 
 import (
+        "errors"
         "fmt"
         "reflect"
         "testing"
@@ -47,16 +48,91 @@ func TestForFailures(t *testing.T) {
                         &pubsub.Message{Data: []byte(`{"logName": "projects/foo-123/logs/something-else"}`)},
                         ErrorParsing,
                 },
+                {
+                        "not a scc finding",
+                        &pubsub.Message{Data: []byte(`{"notificationConfigName": "organizations/123/notificationConfigs/foo", "finding": {}}`)},
+                        ErrorParsing,
+                },
+                {
+                        "audit log without method",
+                        &pubsub.Message{Data: []byte(`{"logName": "projects/foo-123/logs/cloudaudit.googleapis.com%2Factivity", "protoPayload": {}}`)},
+                        ErrorValueNotFound,
+                },
         }
         for _, tt := range test {
                 t.Run(tt.name, func(t *testing.T) {
-                        if err := NewFinding().ReadFinding(tt.message); err.Error() != tt.exp.Error() {
+                        if err := NewFinding().ReadFinding(tt.message); !errors.Is(err, tt.exp) {
                                 t.Errorf("exp:%q got: %q", tt.exp, err)
                         }
                 })
         }
 }
 
+// TestParseErrorField verifies parse errors name the offending field and keep the cause.
+func TestParseErrorField(t *testing.T) {
+        test := []struct {
+                name    string
+                message *pubsub.Message
+                exp     error
+                field   string
+        }{
+                {
+                        "wrong type for external members",
+                        genMessage("external_member_added_to_policy", `"externalMembers": "user:tom@gmail.com"`),
+                        ErrorUnmarshal,
+                        "jsonPayload.properties.externalMembers",
+                },
+                {
+                        "wrong type for rule name",
+                        &pubsub.Message{Data: []byte(`{"logName": "projects/foo-123/logs/threatdetection.googleapis.com%2Fdetection", "jsonPayload": {"detectionCategory": {"ruleName": 1}}}`)},
+                        ErrorUnmarshal,
+                        "jsonPayload.detectionCategory.ruleName",
+                },
+                {
+                        "wrong type for an affected resource",
+                        &pubsub.Message{Data: []byte(`{"logName": "projects/foo-123/logs/threatdetection.googleapis.com%2Fdetection", "jsonPayload": {"affectedResources": [{"gcpResourceName": "//a"}, {"gcpResourceName": 1}]}}`)},
+                        ErrorUnmarshal,
+                        "jsonPayload.affectedResources[1].gcpResourceName",
+                },
+                {
+                        "wrong type for bad ips",
+                        genNetworkMessage("bad_ip", `"ip": 5`),
+                        ErrorUnmarshal,
+                        "jsonPayload.properties.ip",
+                },
+                {
+                        "not json",
+                        &pubsub.Message{Data: []byte(`{"logName": `)},
+                        ErrorUnmarshal,
+                        "",
+                },
+                {
+                        "not a finding",
+                        &pubsub.Message{Data: []byte(`{"logName": "projects/foo-123/logs/something-else"}`)},
+                        ErrorParsing,
+                        "logName",
+                },
+        }
+        for _, tt := range test {
+                t.Run(tt.name, func(t *testing.T) {
+                        err := NewFinding().ReadFinding(tt.message)
+                        if !errors.Is(err, tt.exp) {
+                                t.Fatalf("exp:%q got: %q", tt.exp, err)
+                        }
+                        var pe *ParseError
+                        if !errors.As(err, &pe) {
+                                t.Fatalf("%q is not a ParseError", err)
+                        }
+                        if pe.Field != tt.field {
+                                t.Errorf("%s failed field got:%q want:%q", tt.name, pe.Field, tt.field)
+                        }
+                        if pe.Cause == nil {
+                                t.Errorf("%s failed cause is nil", tt.name)
+                        }
+                })
+        }
+}
+
 // TestSuccess verifies reading a finding without an error.
 func TestSuccess(t *testing.T) {
         test := []struct {
@@ -231,7 +307,7 @@ func TestSubRule(t *testing.T) {
                                 t.Errorf("%s failed got:%q want:nil", tt.name, err)
                                 return
                         }
-                        p := f.ext.JSONPayload.Properties.ExternalMembers
+                        p := f.ExternalUsers()
                         if !reflect.DeepEqual(p, tt.exp) {
                                 t.Errorf("%s failed got:%q want:%q", tt.name, p, tt.exp)
                         }
@@ -283,6 +359,51 @@ func TestExUsers(t *testing.T) {
                 })
         }
 }
+
+func TestGrantedRoles(t *testing.T) {
+        const member = "user:tom@gmail.com"
+        test := []struct {
+                name    string
+                message *pubsub.Message
+                exp     []string
+        }{
+                {
+                        "no binding deltas",
+                        genMessage("external_member_added_to_policy", `"externalMembers": ["user:tom@gmail.com"]`),
+                        []string{},
+                },
+                {
+                        "anomalous grant deltas",
+                        genMessage("external_member_added_to_policy", `"externalMembers": ["user:tom@gmail.com"],
+                                "bindingDeltas": [
+                                        {"action": "ADD", "role": "roles/editor", "member": "user:tom@gmail.com"},
+                                        {"action": "ADD", "role": "roles/owner", "member": "user:other@gmail.com"},
+                                        {"action": "REMOVE", "role": "roles/viewer", "member": "user:tom@gmail.com"}
+                                ]`),
+                        []string{"roles/editor"},
+                },
+                {
+                        "audit log deltas",
+                        genAuditMessage(`[
+                                {"action": "ADD", "role": "roles/editor", "member": "user:tom@gmail.com"},
+                                {"action": "ADD", "role": "roles/viewer", "member": "user:tom@gmail.com"}
+                        ]`),
+                        []string{"roles/editor", "roles/viewer"},
+                },
+        }
+        for _, tt := range test {
+                t.Run(tt.name, func(t *testing.T) {
+                        f := NewFinding()
+                        if err := f.ReadFinding(tt.message); err != nil {
+                                t.Fatalf("failed reading finding: %q", err)
+                        }
+                        if got := f.GrantedRoles(member); !reflect.DeepEqual(got, tt.exp) {
+                                t.Errorf("%s failed got:%q want:%q", tt.name, got, tt.exp)
+                        }
+                })
+        }
+}
+
 func genMessage(subRule string, members string) *pubsub.Message {
         return &pubsub.Message{Data: []byte(fmt.Sprintf(`{
                 "jsonPayload": {
@@ -321,6 +442,11 @@ func TestReadZone(t *testing.T) {
                         genBadNetworkMessage("", "bad_ip"),
                         "",
                 },
+                {
+                        "zone of source instance",
+                        genNetworkMessage("bad_ip", `"sourceInstance": "/projects/test-project/zones/us-central1-a/instances/instance-2"`),
+                        "us-central1-a",
+                },
         }
         for _, tt := range test {
                 t.Run(tt.name, func(t *testing.T) {
@@ -387,4 +513,398 @@ func TestReadInstance(t *testing.T) {
                         }
                 })
         }
+}
+
+// TestSCCNotification verifies findings delivered by Security Command Center are read the same as ETD logs.
+func TestSCCNotification(t *testing.T) {
+        test := []struct {
+                name          string
+                message       *pubsub.Message
+                ruleName      string
+                projectID     string
+                instance      string
+                badIPs        []string
+                externalUsers []string
+                severity      Severity
+                priority      Priority
+        }{
+                {
+                        "bad ip",
+                        genSCCMessage("bad_ip", `{
+                                "detectionCategory_ruleName": "bad_ip",
+                                "properties_project_id": "test-project",
+                                "properties_location": "us-central1-c",
+                                "properties_sourceInstance": "/projects/test-project/zones/us-central1-c/instances/instance-2",
+                                "properties_ip": ["52.8.47.33"]
+                        }`),
+                        "bad_ip",
+                        "test-project",
+                        "instance-2",
+                        []string{"52.8.47.33"},
+                        []string{},
+                        SeverityError,
+                        PriorityHigh,
+                },
+                {
+                        "bad ip without project properties",
+                        genSCCMessage("bad_ip", `{
+                                "detectionCategory_ruleName": "bad_ip",
+                                "detectionPriority": "LOW",
+                                "properties_ip": ["52.8.47.33"]
+                        }`),
+                        "bad_ip",
+                        "997507777601",
+                        "",
+                        []string{"52.8.47.33"},
+                        []string{},
+                        SeverityError,
+                        PriorityLow,
+                },
+                {
+                        "external member added",
+                        genSCCMessage("iam_anomalous_grant", `{
+                                "detectionCategory_subRuleName": "external_member_added_to_policy",
+                                "properties_project_id": "test-project",
+                                "properties_externalMembers": ["user:external-member@gmail.com"]
+                        }`),
+                        "iam_anomalous_grant",
+                        "test-project",
+                        "",
+                        nil,
+                        []string{"user:external-member@gmail.com"},
+                        SeverityError,
+                        PriorityHigh,
+                },
+        }
+        for _, tt := range test {
+                t.Run(tt.name, func(t *testing.T) {
+                        f := NewFinding()
+                        if err := f.ReadFinding(tt.message); err != nil {
+                                t.Fatalf("failed reading finding: %q", err)
+                        }
+                        if got := f.RuleName(); got != tt.ruleName {
+                                t.Errorf("%s failed rule name got:%q want:%q", tt.name, got, tt.ruleName)
+                        }
+                        if got := f.ProjectID(); got != tt.projectID {
+                                t.Errorf("%s failed project got:%q want:%q", tt.name, got, tt.projectID)
+                        }
+                        if got := f.Instance(); got != tt.instance {
+                                t.Errorf("%s failed instance got:%q want:%q", tt.name, got, tt.instance)
+                        }
+                        if got := f.BadIPs(); !reflect.DeepEqual(got, tt.badIPs) {
+                                t.Errorf("%s failed bad ips got:%q want:%q", tt.name, got, tt.badIPs)
+                        }
+                        if got := f.ExternalUsers(); !reflect.DeepEqual(got, tt.externalUsers) {
+                                t.Errorf("%s failed external users got:%q want:%q", tt.name, got, tt.externalUsers)
+                        }
+                        if got := f.State(); got != "ACTIVE" {
+                                t.Errorf("%s failed state got:%q want:%q", tt.name, got, "ACTIVE")
+                        }
+                        if got := f.ProjectNumber(); got != "997507777601" {
+                                t.Errorf("%s failed project number got:%q want:%q", tt.name, got, "997507777601")
+                        }
+                        if got := f.Severity(); got != tt.severity {
+                                t.Errorf("%s failed severity got:%q want:%q", tt.name, got, tt.severity)
+                        }
+                        if got := f.Priority(); got != tt.priority {
+                                t.Errorf("%s failed priority got:%q want:%q", tt.name, got, tt.priority)
+                        }
+                })
+        }
+}
+
+func genSCCMessage(category string, sourceProperties string) *pubsub.Message {
+        return &pubsub.Message{Data: []byte(`{
+                "notificationConfigName": "organizations/154584661726/notificationConfigs/threat-findings",
+                "finding": {
+                        "name": "organizations/154584661726/sources/2299436883026055247/findings/f1",
+                        "parent": "organizations/154584661726/sources/2299436883026055247",
+                        "resourceName": "//cloudresourcemanager.googleapis.com/projects/997507777601",
+                        "state": "ACTIVE",
+                        "category": "` + category + `",
+                        "severity": "HIGH",
+                        "sourceProperties": ` + sourceProperties + `,
+                        "eventTime": "2019-07-16T21:00:44.760Z"
+                }
+        }`)}
+}
+
+// TestBadNetworkProperties verifies the network properties reported by ETD's bad domain and bad IP rules.
+func TestBadNetworkProperties(t *testing.T) {
+        type network struct {
+                badIPs, domains              []string
+                srcIP, destIP                string
+                srcPort, destPort, protocol  int
+                subnetworkID, subnetworkName string
+        }
+        test := []struct {
+                name    string
+                message *pubsub.Message
+                exp     network
+        }{
+                {
+                        "bad domain with scalar ip",
+                        genNetworkMessage("bad_domain", `
+                                "destIp": "118.184.176.25",
+                                "destPort": 80,
+                                "domain": ["3322.org"],
+                                "ip": "118.184.176.25",
+                                "location": "us-central1-c",
+                                "protocol": 6,
+                                "srcIp": "10.128.0.2",
+                                "srcPort": 40208,
+                                "subnetwork_id": "288355645352614400",
+                                "subnetwork_name": "default"`),
+                        network{
+                                badIPs:         []string{"118.184.176.25"},
+                                domains:        []string{"3322.org"},
+                                srcIP:          "10.128.0.2",
+                                destIP:         "118.184.176.25",
+                                srcPort:        40208,
+                                destPort:       80,
+                                protocol:       6,
+                                subnetworkID:   "288355645352614400",
+                                subnetworkName: "default",
+                        },
+                },
+                {
+                        "bad ip with array and string ports",
+                        genNetworkMessage("bad_ip", `
+                                "ip": ["52.8.47.33", "52.8.47.34"],
+                                "destPort": "443",
+                                "subnetwork_id": 288355645352614400`),
+                        network{
+                                badIPs:       []string{"52.8.47.33", "52.8.47.34"},
+                                destPort:     443,
+                                subnetworkID: "288355645352614400",
+                        },
+                },
+                {
+                        "not a network finding",
+                        genNetworkMessage("", `"ip": "118.184.176.25"`),
+                        network{},
+                },
+        }
+        for _, tt := range test {
+                t.Run(tt.name, func(t *testing.T) {
+                        f := NewFinding()
+                        if err := f.ReadFinding(tt.message); err != nil {
+                                t.Fatalf("failed reading finding: %q", err)
+                        }
+                        got := network{
+                                badIPs:         f.BadIPs(),
+                                domains:        f.Domains(),
+                                srcIP:          f.SrcIP(),
+                                destIP:         f.DestIP(),
+                                srcPort:        f.SrcPort(),
+                                destPort:       f.DestPort(),
+                                protocol:       f.Protocol(),
+                                subnetworkID:   f.SubnetworkID(),
+                                subnetworkName: f.SubnetworkName(),
+                        }
+                        if !reflect.DeepEqual(got, tt.exp) {
+                                t.Errorf("%s failed got:%+v want:%+v", tt.name, got, tt.exp)
+                        }
+                })
+        }
+}
+
+func genNetworkMessage(ruleName string, properties string) *pubsub.Message {
+        return &pubsub.Message{Data: []byte(`{
+                "jsonPayload": {
+                        "properties": {` + properties + `},
+                        "detectionCategory": {
+                                "ruleName": "` + ruleName + `"
+                        }
+                },
+                "logName": "projects/dfoo-123/logs/threatdetection.googleapis.com%2Fdetection"
+        }`)}
+}
+
+// TestDetectorProperties verifies the instance and network details of ETD's cryptomining, SSH brute force and outgoing DoS rules.
+func TestDetectorProperties(t *testing.T) {
+        test := []struct {
+                name        string
+                message     *pubsub.Message
+                zone        string
+                instance    string
+                badIPs      []string
+                attackerIPs []string
+                destPort    int
+                protocol    int
+        }{
+                {
+                        name: "cryptomining",
+                        message: genNetworkMessage("cryptomining", `
+                                "location": "us-central1-c",
+                                "sourceInstance": "/projects/test-project/zones/us-central1-c/instances/miner",
+                                "ip": ["52.8.47.33"],
+                                "destPort": 3333,
+                                "protocol": 6`),
+                        zone:     "us-central1-c",
+                        instance: "miner",
+                        badIPs:   []string{"52.8.47.33"},
+                        destPort: 3333,
+                        protocol: 6,
+                },
+                {
+                        name: "ssh brute force",
+                        message: genNetworkMessage("ssh_brute_force", `
+                                "project_id": "test-project",
+                                "location": "us-central1-a",
+                                "loginAttempts": [
+                                        {"authResult": "FAIL", "sourceIp": "10.200.0.2", "userName": "okokok", "vmName": "ssh-password-auth-debian-9"},
+                                        {"authResult": "FAIL", "sourceIp": "10.200.0.3", "userName": "okokok", "vmName": "ssh-password-auth-debian-9"},
+                                        {"authResult": "SUCCESS", "sourceIp": "10.200.0.2", "userName": "okokok", "vmName": "ssh-password-auth-debian-9"}
+                                ]`),
+                        zone:        "us-central1-a",
+                        instance:    "ssh-password-auth-debian-9",
+                        badIPs:      []string{"10.200.0.2", "10.200.0.3"},
+                        attackerIPs: []string{"10.200.0.2", "10.200.0.3"},
+                        destPort:    22,
+                        protocol:    6,
+                },
+                {
+                        name: "outgoing dos",
+                        message: genNetworkMessage("outgoing_dos", `
+                                "location": "us-east1-b",
+                                "sourceInstance": "/projects/test-project/zones/us-east1-b/instances/instance-1",
+                                "ip": ["203.0.113.9"],
+                                "destPort": "53",
+                                "protocol": 17`),
+                        zone:     "us-east1-b",
+                        instance: "instance-1",
+                        badIPs:   []string{"203.0.113.9"},
+                        destPort: 53,
+                        protocol: 17,
+                },
+        }
+        for _, tt := range test {
+                t.Run(tt.name, func(t *testing.T) {
+                        f := NewFinding()
+                        if err := f.ReadFinding(tt.message); err != nil {
+                                t.Fatalf("failed reading finding: %q", err)
+                        }
+                        if err := f.Validate(); err != nil {
+                                t.Errorf("%s failed validation: %q", tt.name, err)
+                        }
+                        if got := f.Zone(); got != tt.zone {
+                                t.Errorf("%s failed zone got:%q want:%q", tt.name, got, tt.zone)
+                        }
+                        if got := f.Instance(); got != tt.instance {
+                                t.Errorf("%s failed instance got:%q want:%q", tt.name, got, tt.instance)
+                        }
+                        if got := f.BadIPs(); !reflect.DeepEqual(got, tt.badIPs) {
+                                t.Errorf("%s failed bad ips got:%q want:%q", tt.name, got, tt.badIPs)
+                        }
+                        if got := f.AttackerIPs(); !reflect.DeepEqual(got, tt.attackerIPs) {
+                                t.Errorf("%s failed attacker ips got:%q want:%q", tt.name, got, tt.attackerIPs)
+                        }
+                        if got := f.DestPort(); got != tt.destPort {
+                                t.Errorf("%s failed dest port got:%d want:%d", tt.name, got, tt.destPort)
+                        }
+                        if got := f.Protocol(); got != tt.protocol {
+                                t.Errorf("%s failed protocol got:%d want:%d", tt.name, got, tt.protocol)
+                        }
+                })
+        }
+}
+
+func TestAuditLog(t *testing.T) {
+        test := []struct {
+                name         string
+                deltas       string
+                addedMembers []string
+                addedRoles   []string
+        }{
+                {
+                        "members added",
+                        `[
+                                {"action": "ADD", "role": "roles/editor", "member": "user:bad@gmail.com"},
+                                {"action": "ADD", "role": "roles/viewer", "member": "user:bad@gmail.com"},
+                                {"action": "ADD", "role": "roles/viewer", "member": "user:ok@google.com"}
+                        ]`,
+                        []string{"user:bad@gmail.com", "user:ok@google.com"},
+                        []string{"roles/editor", "roles/viewer"},
+                },
+                {
+                        "members removed",
+                        `[{"action": "REMOVE", "role": "roles/editor", "member": "user:bad@gmail.com"}]`,
+                        []string{},
+                        []string{},
+                },
+        }
+        for _, tt := range test {
+                t.Run(tt.name, func(t *testing.T) {
+                        f := NewFinding()
+                        if err := f.ReadFinding(genAuditMessage(tt.deltas)); err != nil {
+                                t.Fatalf("failed reading finding: %q", err)
+                        }
+                        if got := f.MethodName(); got != MethodSetIamPolicy {
+                                t.Errorf("%s failed method got:%q want:%q", tt.name, got, MethodSetIamPolicy)
+                        }
+                        if got := f.ProjectID(); got != "test-project" {
+                                t.Errorf("%s failed project got:%q want:%q", tt.name, got, "test-project")
+                        }
+                        if got := f.PrincipalEmail(); got != "admin@google.com" {
+                                t.Errorf("%s failed principal got:%q want:%q", tt.name, got, "admin@google.com")
+                        }
+                        if got := f.AddedMembers(); !reflect.DeepEqual(got, tt.addedMembers) {
+                                t.Errorf("%s failed added members got:%q want:%q", tt.name, got, tt.addedMembers)
+                        }
+                        if got := f.AddedRoles(); !reflect.DeepEqual(got, tt.addedRoles) {
+                                t.Errorf("%s failed added roles got:%q want:%q", tt.name, got, tt.addedRoles)
+                        }
+                        want := []Resource{{FullName: "//cloudresourcemanager.googleapis.com/projects/test-project", Type: "projects", Project: "test-project", Name: "test-project"}}
+                        if got := f.AffectedResources(); !reflect.DeepEqual(got, want) {
+                                t.Errorf("%s failed resources got:%+v want:%+v", tt.name, got, want)
+                        }
+                        if got := f.EventTime(); got.IsZero() {
+                                t.Errorf("%s failed event time got zero", tt.name)
+                        }
+                })
+        }
+}
+
+func TestBindingDeltaCondition(t *testing.T) {
+        f := NewFinding()
+        m := genAuditMessage(`[{
+                "action": "ADD",
+                "role": "roles/editor",
+                "member": "user:bad@gmail.com",
+                "condition": {"title": "expires", "expression": "request.time < timestamp('2020-01-01T00:00:00Z')"}
+        }]`)
+        if err := f.ReadFinding(m); err != nil {
+                t.Fatalf("failed reading finding: %q", err)
+        }
+        want := []BindingDelta{{
+                Action:    DeltaAdd,
+                Role:      "roles/editor",
+                Member:    "user:bad@gmail.com",
+                Condition: &Condition{Title: "expires", Expression: "request.time < timestamp('2020-01-01T00:00:00Z')"},
+        }}
+        if got := f.BindingDeltas(); !reflect.DeepEqual(got, want) {
+                t.Errorf("failed binding deltas got:%+v want:%+v", got, want)
+        }
+}
+
+func genAuditMessage(deltas string) *pubsub.Message {
+        return &pubsub.Message{Data: []byte(`{
+                "insertId": "-xyz123",
+                "logName": "projects/test-project/logs/cloudaudit.googleapis.com%2Factivity",
+                "protoPayload": {
+                        "@type": "type.googleapis.com/google.cloud.audit.AuditLog",
+                        "authenticationInfo": {"principalEmail": "admin@google.com"},
+                        "methodName": "SetIamPolicy",
+                        "resourceName": "projects/test-project",
+                        "serviceName": "cloudresourcemanager.googleapis.com",
+                        "serviceData": {
+                                "@type": "type.googleapis.com/google.iam.v1.logging.AuditData",
+                                "policyDelta": {"bindingDeltas": ` + deltas + `}
+                        }
+                },
+                "resource": {"type": "project", "labels": {"project_id": "test-project"}},
+                "severity": "NOTICE",
+                "timestamp": "2019-07-16T21:00:44.760Z"
+        }`)}
 }
\ No newline at end of file
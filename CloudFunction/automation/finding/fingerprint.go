@@ -0,0 +1,59 @@
+/*
+Package finding contains methods to deserialize and extract fields from findings.
+
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+        https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package finding
+
+import (
+        "crypto/sha256"
+        "encoding/hex"
+        "sort"
+        "strings"
+)
+
+// Fingerprint returns a digest of the rule, resources and indicators of the finding.
+//
+// ETD re-emits findings for the same activity with new insert IDs and event times, these
+// findings share a fingerprint. Findings a deduplicator matches by fingerprint are skipped,
+// so every field telling incidents apart is part of it: the instance, bad IPs and domains of
+// network findings, the external members of IAM grants, the member and role changes of audit
+// log entries and the source ranges an open firewall allows.
+func (f *Finding) Fingerprint() string {
+        resources := []string{}
+        for _, r := range f.AffectedResources() {
+                resources = append(resources, r.FullName)
+        }
+        deltas := []string{}
+        for _, d := range f.BindingDeltas() {
+                deltas = append(deltas, d.Action+" "+d.Role+" "+d.Member)
+        }
+        parts := [][]string{
+                {f.RuleName(), f.SubRuleName(), f.MethodName(), f.Instance()},
+                resources,
+                f.BadIPs(),
+                f.Domains(),
+                f.ExternalUsers(),
+                f.ExternalSourceRanges(),
+                deltas,
+        }
+        h := sha256.New()
+        for _, p := range parts {
+                p = append([]string{}, p...)
+                sort.Strings(p)
+                h.Write([]byte(strings.Join(p, "\x1f") + "\x1e"))
+        }
+        return hex.EncodeToString(h.Sum(nil))
+}
\ No newline at end of file
@@ -0,0 +1,92 @@
+/*
+Package finding contains methods to deserialize and extract fields from findings.
+
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+        https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package finding
+
+import (
+        "fmt"
+        "testing"
+
+        "cloud.google.com/go/pubsub"
+)
+
+// TestFingerprint verifies findings reporting the same activity share a fingerprint.
+func TestFingerprint(t *testing.T) {
+        const base = `{
+                "insertId": "%s",
+                "jsonPayload": {
+                        "affectedResources": [{"gcpResourceName": "//cloudresourcemanager.googleapis.com/projects/test-project"}],
+                        "detectionCategory": {"ruleName": "bad_ip"},
+                        "eventTime": "%s",
+                        "properties": {
+                                "location": "us-central1-c",
+                                "sourceInstance": "/projects/test-project/zones/us-central1-c/instances/%s",
+                                "ip": %s
+                        }
+                },
+                "logName": "projects/test-project/logs/threatdetection.googleapis.com%%2Fdetection"
+        }`
+        fingerprint := func(insertID, eventTime, instance, ips string) string {
+                f := NewFinding()
+                if err := f.ReadFinding(&pubsub.Message{Data: []byte(fmt.Sprintf(base, insertID, eventTime, instance, ips))}); err != nil {
+                        t.Fatalf("failed reading finding: %q", err)
+                }
+                return f.Fingerprint()
+        }
+
+        orig := fingerprint("a", "2019-07-16T21:00:44.760Z", "instance-2", `["52.8.47.33", "52.8.47.34"]`)
+        test := []struct {
+                name  string
+                fp    string
+                equal bool
+        }{
+                {"re-emitted finding", fingerprint("b", "2019-07-16T22:00:44.760Z", "instance-2", `["52.8.47.33", "52.8.47.34"]`), true},
+                {"indicators in another order", fingerprint("c", "2019-07-16T21:00:44.760Z", "instance-2", `["52.8.47.34", "52.8.47.33"]`), true},
+                {"different indicator", fingerprint("d", "2019-07-16T21:00:44.760Z", "instance-2", `["52.8.47.35"]`), false},
+                {"different instance", fingerprint("e", "2019-07-16T21:00:44.760Z", "instance-3", `["52.8.47.33", "52.8.47.34"]`), false},
+        }
+        for _, tt := range test {
+                t.Run(tt.name, func(t *testing.T) {
+                        if got := tt.fp == orig; got != tt.equal {
+                                t.Errorf("%s failed got:%v want:%v", tt.name, got, tt.equal)
+                        }
+                })
+        }
+}
+
+// TestFingerprintHealth verifies open firewalls allowing other ranges don't share a fingerprint.
+func TestFingerprintHealth(t *testing.T) {
+        fingerprint := func(ranges string) string {
+                f := NewFinding()
+                m := &pubsub.Message{Data: []byte(`{
+                        "jsonPayload": {
+                                "affectedResources": [{"gcpResourceName": "//compute.googleapis.com/projects/test-project/global/firewalls/default-allow-ssh"}],
+                                "detectionCategory": {"ruleName": "OPEN_FIREWALL"},
+                                "ProjectId": "test-project",
+                                "ExternalSourceRanges": ` + ranges + `
+                        },
+                        "logName": "projects/test-project/logs/threatdetection.googleapis.com%2Fdetection"
+                }`)}
+                if err := f.ReadFinding(m); err != nil {
+                        t.Fatalf("failed reading finding: %q", err)
+                }
+                return f.Fingerprint()
+        }
+        if fingerprint(`["0.0.0.0/0"]`) == fingerprint(`["10.0.0.0/8"]`) {
+                t.Errorf("open firewall findings allowing other ranges share a fingerprint")
+        }
+}
\ No newline at end of file
@@ -0,0 +1,91 @@
+/*
+Package finding contains methods to deserialize and extract fields from findings.
+
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+        https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package finding
+
+import "strings"
+
+// Security Health Analytics finding categories.
+const (
+        CategoryOpenFirewall        = "OPEN_FIREWALL"
+        CategoryPublicBucketACL     = "PUBLIC_BUCKET_ACL"
+        CategoryPublicIPAddress     = "PUBLIC_IP_ADDRESS"
+        CategoryAdminServiceAccount = "ADMIN_SERVICE_ACCOUNT"
+)
+
+// healthCategories contains the Security Health Analytics categories findings are parsed for.
+var healthCategories = []string{CategoryOpenFirewall, CategoryPublicBucketACL, CategoryPublicIPAddress, CategoryAdminServiceAccount}
+
+// healthFinding is a Security Health Analytics finding.
+//
+// Its source properties aren't grouped so they're found directly in the payload.
+type healthFinding struct {
+        JSONPayload struct {
+                ProjectID            string `json:"ProjectId"`
+                ScannerName          string
+                SeverityLevel        string
+                Explanation          string
+                Recommendation       string
+                ExternalSourceRanges stringList
+        }
+}
+
+// parseHealth decodes the properties of a Security Health Analytics finding.
+func parseHealth(b []byte) (interface{}, error) {
+        var p healthFinding
+        if err := decode(b, &p); err != nil {
+                return nil, err
+        }
+        return &p, nil
+}
+
+// healthProperties returns the Security Health Analytics properties, empty for other findings.
+func (f *Finding) healthProperties() *healthFinding {
+        if h, ok := f.ruleProperties.(*healthFinding); ok {
+                return h
+        }
+        return &healthFinding{}
+}
+
+// ScannerName returns the Security Health Analytics scanner that reported the finding, for example "FIREWALL_SCANNER".
+func (f *Finding) ScannerName() string {
+        return f.healthProperties().JSONPayload.ScannerName
+}
+
+// Explanation returns Security Health Analytics' explanation of the finding.
+func (f *Finding) Explanation() string {
+        return f.healthProperties().JSONPayload.Explanation
+}
+
+// Recommendation returns Security Health Analytics' recommended fix for the finding.
+func (f *Finding) Recommendation() string {
+        return f.healthProperties().JSONPayload.Recommendation
+}
+
+// ExternalSourceRanges returns the source ranges an open firewall allows.
+func (f *Finding) ExternalSourceRanges() []string {
+        return f.healthProperties().JSONPayload.ExternalSourceRanges
+}
+
+// healthPriority returns the severity level Security Health Analytics reports as a priority.
+func (f *Finding) healthPriority() Priority {
+        p, err := ParsePriority(strings.ToUpper(f.healthProperties().JSONPayload.SeverityLevel))
+        if err != nil {
+                return PriorityUnspecified
+        }
+        return p
+}
\ No newline at end of file
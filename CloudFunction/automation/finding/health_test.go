@@ -0,0 +1,129 @@
+/*
+Package finding contains methods to deserialize and extract fields from findings.
+
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+        https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package finding
+
+import (
+        "reflect"
+        "testing"
+
+        "cloud.google.com/go/pubsub"
+)
+
+// TestHealthFinding verifies Security Health Analytics findings are parsed.
+func TestHealthFinding(t *testing.T) {
+        test := []struct {
+                name         string
+                category     string
+                resourceName string
+                resource     Resource
+                scanner      string
+                priority     Priority
+        }{
+                {
+                        "open firewall",
+                        CategoryOpenFirewall,
+                        "//compute.googleapis.com/projects/test-project/global/firewalls/default-allow-ssh",
+                        Resource{FullName: "//compute.googleapis.com/projects/test-project/global/firewalls/default-allow-ssh", Type: "firewalls", Project: "test-project", Name: "default-allow-ssh"},
+                        "FIREWALL_SCANNER",
+                        PriorityHigh,
+                },
+                {
+                        "public bucket",
+                        CategoryPublicBucketACL,
+                        "//storage.googleapis.com/public-bucket",
+                        Resource{FullName: "//storage.googleapis.com/public-bucket", Type: "buckets", Name: "public-bucket"},
+                        "STORAGE_SCANNER",
+                        PriorityHigh,
+                },
+                {
+                        "public ip address",
+                        CategoryPublicIPAddress,
+                        "//compute.googleapis.com/projects/test-project/zones/us-central1-a/instances/instance-1",
+                        Resource{FullName: "//compute.googleapis.com/projects/test-project/zones/us-central1-a/instances/instance-1", Type: "instances", Project: "test-project", Zone: "us-central1-a", Name: "instance-1"},
+                        "COMPUTE_INSTANCE_SCANNER",
+                        PriorityHigh,
+                },
+                {
+                        "admin service account",
+                        CategoryAdminServiceAccount,
+                        "//iam.googleapis.com/projects/test-project/serviceAccounts/admin@test-project.iam.gserviceaccount.com",
+                        Resource{FullName: "//iam.googleapis.com/projects/test-project/serviceAccounts/admin@test-project.iam.gserviceaccount.com", Type: "serviceAccounts", Project: "test-project", Name: "admin@test-project.iam.gserviceaccount.com"},
+                        "IAM_SCANNER",
+                        PriorityHigh,
+                },
+        }
+        for _, tt := range test {
+                t.Run(tt.name, func(t *testing.T) {
+                        f := NewFinding()
+                        if err := f.ReadFinding(genHealthMessage(tt.category, tt.resourceName, tt.scanner)); err != nil {
+                                t.Fatalf("failed reading finding: %q", err)
+                        }
+                        if err := f.Validate(); err != nil {
+                                t.Errorf("%s failed validation: %q", tt.name, err)
+                        }
+                        if got := f.RuleName(); got != tt.category {
+                                t.Errorf("%s failed rule name got:%q want:%q", tt.name, got, tt.category)
+                        }
+                        if got := f.ProjectID(); got != "test-project" {
+                                t.Errorf("%s failed project got:%q want:%q", tt.name, got, "test-project")
+                        }
+                        if got := f.ScannerName(); got != tt.scanner {
+                                t.Errorf("%s failed scanner got:%q want:%q", tt.name, got, tt.scanner)
+                        }
+                        if got := f.Priority(); got != tt.priority {
+                                t.Errorf("%s failed priority got:%q want:%q", tt.name, got, tt.priority)
+                        }
+                        if got := f.AffectedResources(); !reflect.DeepEqual(got, []Resource{tt.resource}) {
+                                t.Errorf("%s failed resources got:%+v want:%+v", tt.name, got, tt.resource)
+                        }
+                })
+        }
+}
+
+func TestExternalSourceRanges(t *testing.T) {
+        f := NewFinding()
+        m := genHealthMessage(CategoryOpenFirewall, "//compute.googleapis.com/projects/test-project/global/firewalls/default-allow-ssh", "FIREWALL_SCANNER")
+        if err := f.ReadFinding(m); err != nil {
+                t.Fatalf("failed reading finding: %q", err)
+        }
+        if got, want := f.ExternalSourceRanges(), []string{"0.0.0.0/0"}; !reflect.DeepEqual(got, want) {
+                t.Errorf("failed external source ranges got:%q want:%q", got, want)
+        }
+}
+
+func genHealthMessage(category string, resourceName string, scanner string) *pubsub.Message {
+        return &pubsub.Message{Data: []byte(`{
+                "notificationConfigName": "organizations/154584661726/notificationConfigs/health-findings",
+                "finding": {
+                        "name": "organizations/154584661726/sources/1986930501971458034/findings/f2",
+                        "parent": "organizations/154584661726/sources/1986930501971458034",
+                        "resourceName": "` + resourceName + `",
+                        "state": "ACTIVE",
+                        "category": "` + category + `",
+                        "sourceProperties": {
+                                "ProjectId": "test-project",
+                                "ScannerName": "` + scanner + `",
+                                "SeverityLevel": "High",
+                                "Explanation": "Resource is publicly accessible.",
+                                "Recommendation": "Restrict access to the resource.",
+                                "ExternalSourceRanges": ["0.0.0.0/0"]
+                        },
+                        "eventTime": "2019-07-16T21:00:44.760Z"
+                }
+        }`)}
+}
\ No newline at end of file
@@ -0,0 +1,162 @@
+/*
+Package finding contains methods to deserialize and extract fields from findings.
+
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+        https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package finding
+
+import (
+        "encoding/json"
+        "fmt"
+        "strings"
+)
+
+// FromLogsExplorer converts a finding copied from the Logs Explorer into the JSON ReadFinding accepts.
+//
+// The Logs Explorer pretty prints log entries with unquoted keys, no commas and arrays
+// written as objects keyed by index, for example:
+//
+//	{
+//	 insertId:  "qnjgp6a7"
+//	 jsonPayload: {
+//	  properties: {
+//	   domain: [
+//	    0:  "3322.org"
+//	   ]
+//	  }
+//	 }
+//	}
+func FromLogsExplorer(b []byte) ([]byte, error) {
+        p := &explorerParser{}
+        for _, l := range strings.Split(string(b), "\n") {
+                if l = strings.TrimSpace(l); l != "" {
+                        p.lines = append(p.lines, l)
+                }
+        }
+        if len(p.lines) == 0 || p.lines[0] != "{" {
+                return nil, p.errorf("", "expected {")
+        }
+        p.pos++
+        v, err := p.object("")
+        if err != nil {
+                return nil, err
+        }
+        p.pos++
+        if p.pos != len(p.lines) {
+                return nil, p.errorf("", "unexpected %q after the log entry", p.lines[p.pos])
+        }
+        return json.Marshal(v)
+}
+
+// explorerParser holds the state of a Logs Explorer conversion.
+type explorerParser struct {
+        lines []string
+        pos   int
+}
+
+// object reads "key: value" lines up to the closing brace.
+func (p *explorerParser) object(path string) (map[string]interface{}, error) {
+        obj := map[string]interface{}{}
+        for ; p.pos < len(p.lines); p.pos++ {
+                if p.lines[p.pos] == "}" {
+                        return obj, nil
+                }
+                key, rest, err := p.field(path)
+                if err != nil {
+                        return nil, err
+                }
+                if obj[key], err = p.value(join(path, key), rest); err != nil {
+                        return nil, err
+                }
+        }
+        return nil, p.errorf(path, "missing }")
+}
+
+// array reads "index: value" lines up to the closing bracket.
+func (p *explorerParser) array(path string) ([]interface{}, error) {
+        arr := []interface{}{}
+        for ; p.pos < len(p.lines); p.pos++ {
+                if p.lines[p.pos] == "]" {
+                        return arr, nil
+                }
+                index, rest, err := p.field(path)
+                if err != nil {
+                        return nil, err
+                }
+                if index != fmt.Sprint(len(arr)) {
+                        return nil, p.errorf(path, "expected index %d got %q", len(arr), index)
+                }
+                v, err := p.value(join(path, index), rest)
+                if err != nil {
+                        return nil, err
+                }
+                arr = append(arr, v)
+        }
+        return nil, p.errorf(path, "missing ]")
+}
+
+// field splits the current line into its key and value.
+func (p *explorerParser) field(path string) (string, string, error) {
+        l := p.lines[p.pos]
+        i := strings.Index(l, ":")
+        if i <= 0 {
+                return "", "", p.errorf(path, "expected key: value got %q", l)
+        }
+        return strings.Trim(l[:i], `"`), strings.TrimSpace(l[i+1:]), nil
+}
+
+// value converts the value of a field, reading nested objects and arrays.
+func (p *explorerParser) value(path, v string) (interface{}, error) {
+        switch v {
+        case "{":
+                p.pos++
+                return p.object(path)
+        case "[":
+                p.pos++
+                return p.array(path)
+        case "{}":
+                return map[string]interface{}{}, nil
+        case "[]":
+                return []interface{}{}, nil
+        case "true", "false", "null":
+                return json.RawMessage(v), nil
+        }
+        if strings.HasPrefix(v, `"`) {
+                var s string
+                if err := json.Unmarshal([]byte(v), &s); err != nil {
+                        return nil, &ParseError{Field: path, Err: ErrorParsing, Cause: err}
+                }
+                return s, nil
+        }
+        var n json.Number
+        if err := json.Unmarshal([]byte(v), &n); err == nil {
+                return n, nil
+        }
+        // Anything else is an unquoted string, enum values for example.
+        return v, nil
+}
+
+// errorf returns a ParseError for the current line.
+func (p *explorerParser) errorf(path, format string, a ...interface{}) error {
+        return &ParseError{Field: path, Err: ErrorParsing, Cause: fmt.Errorf("line %d: %s", p.pos+1, fmt.Sprintf(format, a...))}
+}
+
+// join appends the key to the field path.
+func join(path, key string) string {
+        if path == "" {
+                return key
+        }
+        return path + "." + key
+}
\ No newline at end of file
@@ -0,0 +1,119 @@
+/*
+Package finding contains methods to deserialize and extract fields from findings.
+
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+        https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package finding
+
+import (
+        "errors"
+        "io/ioutil"
+        "reflect"
+        "testing"
+
+        "cloud.google.com/go/pubsub"
+)
+
+// TestFromLogsExplorer verifies the sample fixtures can be read as findings.
+func TestFromLogsExplorer(t *testing.T) {
+        test := []struct {
+                name     string
+                file     string
+                ruleName string
+                instance string
+                badIPs   []string
+                domains  []string
+                destPort int
+        }{
+                {
+                        name:     "bad ip",
+                        file:     "bad_ip_finding.txt",
+                        ruleName: "bad_ip",
+                        instance: "instance-2",
+                        badIPs:   []string{"52.8.47.33"},
+                },
+                {
+                        name:     "bad domain",
+                        file:     "bad_domain_sample.txt",
+                        ruleName: "bad_domain",
+                        badIPs:   []string{"118.184.176.25"},
+                        domains:  []string{"3322.org"},
+                        destPort: 80,
+                },
+        }
+        for _, tt := range test {
+                t.Run(tt.name, func(t *testing.T) {
+                        b, err := ioutil.ReadFile(tt.file)
+                        if err != nil {
+                                t.Fatalf("failed to read %q: %q", tt.file, err)
+                        }
+                        data, err := FromLogsExplorer(b)
+                        if err != nil {
+                                t.Fatalf("failed to convert %q: %q", tt.file, err)
+                        }
+                        f := NewFinding()
+                        if err := f.ReadFinding(&pubsub.Message{Data: data}); err != nil {
+                                t.Fatalf("failed reading finding: %q", err)
+                        }
+                        if got := f.RuleName(); got != tt.ruleName {
+                                t.Errorf("%s failed rule name got:%q want:%q", tt.name, got, tt.ruleName)
+                        }
+                        if got := f.ProjectID(); got != "aerial-jigsaw-235219" {
+                                t.Errorf("%s failed project got:%q", tt.name, got)
+                        }
+                        if got := f.Zone(); got != "us-central1-c" {
+                                t.Errorf("%s failed zone got:%q", tt.name, got)
+                        }
+                        if got := f.Instance(); got != tt.instance {
+                                t.Errorf("%s failed instance got:%q want:%q", tt.name, got, tt.instance)
+                        }
+                        if got := f.BadIPs(); !reflect.DeepEqual(got, tt.badIPs) {
+                                t.Errorf("%s failed bad ips got:%q want:%q", tt.name, got, tt.badIPs)
+                        }
+                        if got := f.Domains(); !reflect.DeepEqual(got, tt.domains) {
+                                t.Errorf("%s failed domains got:%q want:%q", tt.name, got, tt.domains)
+                        }
+                        if got := f.DestPort(); got != tt.destPort {
+                                t.Errorf("%s failed dest port got:%d want:%d", tt.name, got, tt.destPort)
+                        }
+                        if got := f.Severity(); got != SeverityCritical {
+                                t.Errorf("%s failed severity got:%v", tt.name, got)
+                        }
+                })
+        }
+}
+
+// TestFromLogsExplorerFailures verifies malformed input is rejected.
+func TestFromLogsExplorerFailures(t *testing.T) {
+        test := []struct {
+                name  string
+                input string
+        }{
+                {"empty", ""},
+                {"not an object", "insertId: \"a\""},
+                {"missing brace", "{\n insertId: \"a\"\n jsonPayload: {\n}"},
+                {"missing bracket", "{\n ip: [\n  0: \"a\"\n}"},
+                {"out of order index", "{\n ip: [\n  1: \"a\"\n ]\n}"},
+                {"no key", "{\n \"a\"\n}"},
+                {"trailing input", "{\n}\n}"},
+        }
+        for _, tt := range test {
+                t.Run(tt.name, func(t *testing.T) {
+                        if _, err := FromLogsExplorer([]byte(tt.input)); !errors.Is(err, ErrorParsing) {
+                                t.Errorf("%s failed exp:%q got:%q", tt.name, ErrorParsing, err)
+                        }
+                })
+        }
+}
\ No newline at end of file
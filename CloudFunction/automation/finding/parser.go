@@ -0,0 +1,157 @@
+/*
+Package finding contains methods to deserialize and extract fields from findings.
+
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+        https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package finding
+
+import (
+        "sync"
+)
+
+// Parser decodes the properties specific to a rule or sub rule from a finding's log entry.
+//
+// The log entry is always in the shape of an ETD finding, notifications from Security
+// Command Center are converted before being handed to a parser.
+type Parser func(b []byte) (interface{}, error)
+
+var (
+        parsersMu sync.RWMutex
+        // ruleParsers contains the parsers keyed by rule name.
+        ruleParsers = make(map[string]Parser)
+        // subRuleParsers contains the parsers keyed by sub rule name.
+        subRuleParsers = make(map[string]Parser)
+)
+
+func init() {
+        // ETD's anomalous IAM grant detector sub rules.
+        RegisterSubRuleParser("external_member_added_to_policy", parseExternalMemberAdded)
+        RegisterSubRuleParser("external_member_invited_to_policy", parseExternalMemberAdded)
+        // ETD findings based off VPC flow logs.
+        RegisterRuleParser("bad_ip", parseBadNetwork)
+        RegisterRuleParser("bad_domain", parseBadNetwork)
+        RegisterRuleParser("cryptomining", parseBadNetwork)
+        RegisterRuleParser("outgoing_dos", parseBadNetwork)
+        RegisterRuleParser("ssh_brute_force", parseSSHBruteForce)
+        // Security Health Analytics findings, their category is used as the rule name.
+        for _, c := range healthCategories {
+                RegisterRuleParser(c, parseHealth)
+                RequireFields(c, "jsonPayload.affectedResources")
+        }
+
+        RequireFields("external_member_added_to_policy", "jsonPayload.properties.externalMembers")
+        RequireFields("external_member_invited_to_policy", "jsonPayload.properties.externalMembers")
+        RequireFields("ssh_brute_force", "jsonPayload.properties.location", "jsonPayload.properties.loginAttempts")
+        for _, r := range []string{"bad_ip", "bad_domain", "cryptomining", "outgoing_dos"} {
+                // Either the instances are listed as affected resources or the activity is attributed
+                // to one, the project is always listed so any affected resource isn't enough.
+                RequireCheck(r, instanceField, hasInstance)
+        }
+}
+
+// instanceField describes where hasInstance looks for an instance.
+const instanceField = "jsonPayload.affectedResources instance or jsonPayload.properties.sourceInstance"
+
+// hasInstance returns whether the finding lists an affected instance or attributes its
+// activity to one.
+func hasInstance(f *Finding) bool {
+        for _, r := range f.AffectedResources() {
+                if r.Type == "instances" {
+                        return true
+                }
+        }
+        return f.Instance() != ""
+}
+
+// RegisterRuleParser makes a parser available for findings with the given rule name.
+// If RegisterRuleParser is called twice with the same rule name or if p is nil, it panics.
+func RegisterRuleParser(ruleName string, p Parser) {
+        register(ruleParsers, ruleName, p)
+}
+
+// RegisterSubRuleParser makes a parser available for findings with the given sub rule name.
+// If RegisterSubRuleParser is called twice with the same sub rule name or if p is nil, it panics.
+func RegisterSubRuleParser(subRuleName string, p Parser) {
+        register(subRuleParsers, subRuleName, p)
+}
+
+// register adds the parser to the given registry.
+func register(parsers map[string]Parser, name string, p Parser) {
+        parsersMu.Lock()
+        defer parsersMu.Unlock()
+        if p == nil {
+                panic("finding: register parser is nil for " + name)
+        }
+        if _, dup := parsers[name]; dup {
+                panic("finding: register called twice for " + name)
+        }
+        parsers[name] = p
+}
+
+// ruleParser returns the parser registered for the rule name, if any.
+func ruleParser(ruleName string) Parser {
+        parsersMu.RLock()
+        defer parsersMu.RUnlock()
+        return ruleParsers[ruleName]
+}
+
+// subRuleParser returns the parser registered for the sub rule name, if any.
+func subRuleParser(subRuleName string) Parser {
+        parsersMu.RLock()
+        defer parsersMu.RUnlock()
+        return subRuleParsers[subRuleName]
+}
+
+// parse runs the parser if one was registered.
+func parse(p Parser, b []byte) (interface{}, error) {
+        if p == nil {
+                return nil, nil
+        }
+        v, err := p(b)
+        if err != nil {
+                if pe, ok := err.(*ParseError); ok {
+                        return nil, pe
+                }
+                return nil, unmarshalError(err, b, nil)
+        }
+        return v, nil
+}
+
+// parseExternalMemberAdded decodes the properties of an anomalous IAM grant's external member sub rules.
+func parseExternalMemberAdded(b []byte) (interface{}, error) {
+        var p externalMemberAdded
+        if err := decode(b, &p); err != nil {
+                return nil, err
+        }
+        return &p, nil
+}
+
+// parseBadNetwork decodes the properties of findings based off VPC flow logs.
+func parseBadNetwork(b []byte) (interface{}, error) {
+        var p badNetworkFinding
+        if err := decode(b, &p); err != nil {
+                return nil, err
+        }
+        return &p, nil
+}
+
+// parseSSHBruteForce decodes the properties of ETD's SSH brute force rule.
+func parseSSHBruteForce(b []byte) (interface{}, error) {
+        var p sshBruteForceFinding
+        if err := decode(b, &p); err != nil {
+                return nil, err
+        }
+        return &p, nil
+}
\ No newline at end of file
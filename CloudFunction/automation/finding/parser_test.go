@@ -0,0 +1,121 @@
+/*
+Package finding contains methods to deserialize and extract fields from findings.
+
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+        https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package finding
+
+import (
+        "encoding/json"
+        "reflect"
+        "testing"
+
+        "cloud.google.com/go/pubsub"
+)
+
+// customDetector holds properties of a detector registered outside of the finding package.
+type customDetector struct {
+        JSONPayload struct {
+                Properties struct {
+                        Score int
+                }
+        }
+}
+
+func init() {
+        RegisterRuleParser("custom_detector", func(b []byte) (interface{}, error) {
+                var p customDetector
+                if err := json.Unmarshal(b, &p); err != nil {
+                        return nil, err
+                }
+                return &p, nil
+        })
+        RegisterSubRuleParser("custom_sub_rule", func(b []byte) (interface{}, error) {
+                return "sub rule properties", nil
+        })
+}
+
+// TestRegisteredParser verifies properties decoded by a registered parser are reachable from the finding.
+func TestRegisteredParser(t *testing.T) {
+        test := []struct {
+                name              string
+                message           *pubsub.Message
+                ruleProperties    interface{}
+                subRuleProperties interface{}
+        }{
+                {
+                        "registered rule",
+                        &pubsub.Message{Data: []byte(`{
+                                "logName": "projects/foo-123/logs/threatdetection.googleapis.com%2Fdetection",
+                                "jsonPayload": {
+                                        "detectionCategory": {"ruleName": "custom_detector"},
+                                        "properties": {"score": 7}
+                                }
+                        }`)},
+                        func() interface{} {
+                                p := &customDetector{}
+                                p.JSONPayload.Properties.Score = 7
+                                return p
+                        }(),
+                        nil,
+                },
+                {
+                        "registered sub rule",
+                        &pubsub.Message{Data: []byte(`{
+                                "logName": "projects/foo-123/logs/threatdetection.googleapis.com%2Fdetection",
+                                "jsonPayload": {
+                                        "detectionCategory": {"ruleName": "unknown", "subRuleName": "custom_sub_rule"}
+                                }
+                        }`)},
+                        nil,
+                        "sub rule properties",
+                },
+                {
+                        "no registered parser",
+                        &pubsub.Message{Data: []byte(`{
+                                "logName": "projects/foo-123/logs/threatdetection.googleapis.com%2Fdetection",
+                                "jsonPayload": {
+                                        "detectionCategory": {"ruleName": "unknown"}
+                                }
+                        }`)},
+                        nil,
+                        nil,
+                },
+        }
+        for _, tt := range test {
+                t.Run(tt.name, func(t *testing.T) {
+                        f := NewFinding()
+                        if err := f.ReadFinding(tt.message); err != nil {
+                                t.Fatalf("failed reading finding: %q", err)
+                        }
+                        if got := f.RuleProperties(); !reflect.DeepEqual(got, tt.ruleProperties) {
+                                t.Errorf("%s failed got:%v want:%v", tt.name, got, tt.ruleProperties)
+                        }
+                        if got := f.SubRuleProperties(); !reflect.DeepEqual(got, tt.subRuleProperties) {
+                                t.Errorf("%s failed got:%v want:%v", tt.name, got, tt.subRuleProperties)
+                        }
+                })
+        }
+}
+
+// TestRegisterTwice verifies registering a second parser for a rule panics.
+func TestRegisterTwice(t *testing.T) {
+        defer func() {
+                if recover() == nil {
+                        t.Errorf("expected panic registering bad_ip twice")
+                }
+        }()
+        RegisterRuleParser("bad_ip", parseBadNetwork)
+}
\ No newline at end of file
@@ -0,0 +1,73 @@
+/*
+Package finding contains methods to deserialize and extract fields from findings.
+
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+        https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package finding
+
+import "strings"
+
+// Resource is a GCP resource affected by a finding.
+type Resource struct {
+        // FullName is the full resource name, for example
+        // "//compute.googleapis.com/projects/p/zones/us-central1-a/instances/i".
+        FullName string `json:"fullName"`
+        // Type is the collection the resource belongs to, for example "instances" or "projects".
+        Type string `json:"type,omitempty"`
+        // Project is the project containing the resource, or the resource itself if it's a project.
+        Project string `json:"project,omitempty"`
+        // Zone is the zone containing the resource, if any.
+        Zone string `json:"zone,omitempty"`
+        // Name is the name of the resource within its collection.
+        Name string `json:"name"`
+}
+
+// parseResource splits a full resource name into its parts.
+func parseResource(fullName string) Resource {
+        r := Resource{FullName: fullName}
+        path := strings.TrimPrefix(fullName, "//")
+        // Drop the service name, "compute.googleapis.com" for example.
+        service := path
+        if i := strings.Index(path, "/"); i != -1 {
+                service, path = path[:i], path[i+1:]
+        } else {
+                path = ""
+        }
+        segments := []string{}
+        for _, s := range strings.Split(path, "/") {
+                // Global resources have no location, "projects/p/global/firewalls/f" for example.
+                if s != "" && s != "global" {
+                        segments = append(segments, s)
+                }
+        }
+        for i := 0; i+1 < len(segments); i += 2 {
+                collection, id := segments[i], segments[i+1]
+                switch collection {
+                case "projects":
+                        r.Project = id
+                case "zones":
+                        r.Zone = id
+                }
+                r.Type, r.Name = collection, id
+        }
+        if len(segments)%2 == 1 {
+                r.Type, r.Name = "", segments[len(segments)-1]
+        }
+        // Buckets are named directly under the service, "//storage.googleapis.com/b" for example.
+        if service == "storage.googleapis.com" && len(segments) == 1 {
+                r.Type = "buckets"
+        }
+        return r
+}
\ No newline at end of file
@@ -0,0 +1,106 @@
+/*
+Package finding contains methods to deserialize and extract fields from findings.
+
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+        https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package finding
+
+import (
+        "reflect"
+        "testing"
+
+        "cloud.google.com/go/pubsub"
+)
+
+// TestAffectedResources verifies every affected resource is parsed.
+func TestAffectedResources(t *testing.T) {
+        test := []struct {
+                name    string
+                message *pubsub.Message
+                exp     []Resource
+        }{
+                {
+                        "multiple resources",
+                        &pubsub.Message{Data: []byte(`{
+                                "logName": "projects/foo-123/logs/threatdetection.googleapis.com%2Fdetection",
+                                "jsonPayload": {
+                                "affectedResources": [
+                                        {"gcpResourceName": "//cloudresourcemanager.googleapis.com/projects/project-1"},
+                                        {"gcpResourceName": "//cloudresourcemanager.googleapis.com/projects/project-2"},
+                                        {"gcpResourceName": "//compute.googleapis.com/projects/project-1/zones/us-central1-c/instances/instance-2"},
+                                        {"gcpResourceName": "//compute.googleapis.com/projects/project-1/global/firewalls/default-allow-ssh"},
+                                        {"gcpResourceName": "//cloudresourcemanager.googleapis.com/folders/760347836977"},
+                                        {"gcpResourceName": "//storage.googleapis.com/public-bucket"}
+                                ]}
+                        }`)},
+                        []Resource{
+                                {
+                                        FullName: "//cloudresourcemanager.googleapis.com/projects/project-1",
+                                        Type:     "projects",
+                                        Project:  "project-1",
+                                        Name:     "project-1",
+                                },
+                                {
+                                        FullName: "//cloudresourcemanager.googleapis.com/projects/project-2",
+                                        Type:     "projects",
+                                        Project:  "project-2",
+                                        Name:     "project-2",
+                                },
+                                {
+                                        FullName: "//compute.googleapis.com/projects/project-1/zones/us-central1-c/instances/instance-2",
+                                        Type:     "instances",
+                                        Project:  "project-1",
+                                        Zone:     "us-central1-c",
+                                        Name:     "instance-2",
+                                },
+                                {
+                                        FullName: "//compute.googleapis.com/projects/project-1/global/firewalls/default-allow-ssh",
+                                        Type:     "firewalls",
+                                        Project:  "project-1",
+                                        Name:     "default-allow-ssh",
+                                },
+                                {
+                                        FullName: "//cloudresourcemanager.googleapis.com/folders/760347836977",
+                                        Type:     "folders",
+                                        Name:     "760347836977",
+                                },
+                                {
+                                        FullName: "//storage.googleapis.com/public-bucket",
+                                        Type:     "buckets",
+                                        Name:     "public-bucket",
+                                },
+                        },
+                },
+                {
+                        "no affected resources",
+                        &pubsub.Message{Data: []byte(`{
+                                "logName": "projects/foo-123/logs/threatdetection.googleapis.com%2Fdetection",
+                                "jsonPayload": {}
+                        }`)},
+                        []Resource{},
+                },
+        }
+        for _, tt := range test {
+                t.Run(tt.name, func(t *testing.T) {
+                        f := NewFinding()
+                        if err := f.ReadFinding(tt.message); err != nil {
+                                t.Fatalf("failed reading finding: %q", err)
+                        }
+                        if got := f.AffectedResources(); !reflect.DeepEqual(got, tt.exp) {
+                                t.Errorf("%s failed got:%+v want:%+v", tt.name, got, tt.exp)
+                        }
+                })
+        }
+}
\ No newline at end of file
@@ -0,0 +1,112 @@
+/*
+Package finding contains methods to deserialize and extract fields from findings.
+
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+        https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package finding
+
+import "fmt"
+
+// Severity is the severity of a finding's log entry, ordered from least to most severe.
+type Severity int
+
+// Severities as defined by Stackdriver Logging's LogSeverity.
+const (
+        SeverityDefault Severity = iota
+        SeverityDebug
+        SeverityInfo
+        SeverityNotice
+        SeverityWarning
+        SeverityError
+        SeverityCritical
+        SeverityAlert
+        SeverityEmergency
+)
+
+var severityNames = []string{"DEFAULT", "DEBUG", "INFO", "NOTICE", "WARNING", "ERROR", "CRITICAL", "ALERT", "EMERGENCY"}
+
+// ParseSeverity returns the severity with the given name.
+func ParseSeverity(s string) (Severity, error) {
+        for i, n := range severityNames {
+                if n == s {
+                        return Severity(i), nil
+                }
+        }
+        return SeverityDefault, fmt.Errorf("unknown severity %q", s)
+}
+
+// String returns the name of the severity.
+func (s Severity) String() string {
+        if s < 0 || int(s) >= len(severityNames) {
+                return fmt.Sprintf("Severity(%d)", int(s))
+        }
+        return severityNames[s]
+}
+
+// Priority is the detection priority ETD assigns to a finding, ordered from lowest to highest.
+type Priority int
+
+// Priorities as reported in ETD's detectionPriority.
+const (
+        PriorityUnspecified Priority = iota
+        PriorityLow
+        PriorityMedium
+        PriorityHigh
+)
+
+var priorityNames = []string{"UNSPECIFIED", "LOW", "MEDIUM", "HIGH"}
+
+// ParsePriority returns the priority with the given name.
+func ParsePriority(s string) (Priority, error) {
+        for i, n := range priorityNames {
+                if n == s {
+                        return Priority(i), nil
+                }
+        }
+        return PriorityUnspecified, fmt.Errorf("unknown priority %q", s)
+}
+
+// String returns the name of the priority.
+func (p Priority) String() string {
+        if p < 0 || int(p) >= len(priorityNames) {
+                return fmt.Sprintf("Priority(%d)", int(p))
+        }
+        return priorityNames[p]
+}
+
+// sccSeverities maps the severity Security Command Center reports for a finding to
+// the log severity and detection priority ETD reports for the same finding.
+var sccSeverities = map[string]struct {
+        severity Severity
+        priority Priority
+}{
+        "CRITICAL": {SeverityCritical, PriorityHigh},
+        "HIGH":     {SeverityError, PriorityHigh},
+        "MEDIUM":   {SeverityWarning, PriorityMedium},
+        "LOW":      {SeverityNotice, PriorityLow},
+}
+
+// Threshold is the minimum severity and priority a finding must have for an action to respond.
+//
+// The zero value accepts every finding.
+type Threshold struct {
+        Severity Severity
+        Priority Priority
+}
+
+// Meets returns whether the finding's severity and priority are at or above the threshold.
+func (f *Finding) Meets(t Threshold) bool {
+        return f.Severity() >= t.Severity && f.Priority() >= t.Priority
+}
\ No newline at end of file
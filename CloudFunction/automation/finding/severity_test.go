@@ -0,0 +1,114 @@
+/*
+Package finding contains methods to deserialize and extract fields from findings.
+
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+        https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package finding
+
+import (
+        "fmt"
+        "testing"
+        "time"
+
+        "cloud.google.com/go/pubsub"
+)
+
+const severityFinding = `{
+        "insertId": "qnjgp6a7",
+        "jsonPayload": {
+                "detectionCategory": {
+                        "indicator": "domain",
+                        "ruleName": "bad_domain",
+                        "technique": "Malware"
+                },
+                "detectionPriority": "%s",
+                "eventTime": "2019-07-16T16:27:49.644Z"
+        },
+        "logName": "projects/aerial-jigsaw-235219/logs/threatdetection.googleapis.com%%2Fdetection",
+        "severity": "%s"
+}`
+
+// TestSeverityAccessors verifies the severity, priority, technique and event time of a finding.
+func TestSeverityAccessors(t *testing.T) {
+        f := NewFinding()
+        if err := f.ReadFinding(&pubsub.Message{Data: []byte(fmt.Sprintf(severityFinding, "HIGH", "CRITICAL"))}); err != nil {
+                t.Fatalf("failed reading finding: %q", err)
+        }
+        if got := f.Severity(); got != SeverityCritical {
+                t.Errorf("severity got:%v want:%v", got, SeverityCritical)
+        }
+        if got := f.Priority(); got != PriorityHigh {
+                t.Errorf("priority got:%v want:%v", got, PriorityHigh)
+        }
+        if got := f.Technique(); got != "Malware" {
+                t.Errorf("technique got:%q want:%q", got, "Malware")
+        }
+        if got := f.Indicator(); got != "domain" {
+                t.Errorf("indicator got:%q want:%q", got, "domain")
+        }
+        exp := time.Date(2019, 7, 16, 16, 27, 49, 644000000, time.UTC)
+        if got := f.EventTime(); !got.Equal(exp) {
+                t.Errorf("event time got:%v want:%v", got, exp)
+        }
+}
+
+// TestMeets verifies findings are compared against a threshold.
+func TestMeets(t *testing.T) {
+        test := []struct {
+                name      string
+                priority  string
+                severity  string
+                threshold Threshold
+                exp       bool
+        }{
+                {"no threshold", "", "", Threshold{}, true},
+                {"meets priority", "HIGH", "CRITICAL", Threshold{Priority: PriorityHigh}, true},
+                {"below priority", "LOW", "CRITICAL", Threshold{Priority: PriorityMedium}, false},
+                {"meets severity", "LOW", "ERROR", Threshold{Severity: SeverityError}, true},
+                {"below severity", "HIGH", "WARNING", Threshold{Severity: SeverityError}, false},
+                {"meets both", "MEDIUM", "ALERT", Threshold{Severity: SeverityCritical, Priority: PriorityMedium}, true},
+                {"unknown values", "URGENT", "BAD", Threshold{Priority: PriorityLow}, false},
+        }
+        for _, tt := range test {
+                t.Run(tt.name, func(t *testing.T) {
+                        f := NewFinding()
+                        if err := f.ReadFinding(&pubsub.Message{Data: []byte(fmt.Sprintf(severityFinding, tt.priority, tt.severity))}); err != nil {
+                                t.Fatalf("failed reading finding: %q", err)
+                        }
+                        if got := f.Meets(tt.threshold); got != tt.exp {
+                                t.Errorf("%s failed got:%v want:%v", tt.name, got, tt.exp)
+                        }
+                })
+        }
+}
+
+// TestParseSeverity verifies names round trip through their parsed values.
+func TestParseSeverity(t *testing.T) {
+        for _, n := range severityNames {
+                s, err := ParseSeverity(n)
+                if err != nil || s.String() != n {
+                        t.Errorf("severity %q got:%v err:%v", n, s, err)
+                }
+        }
+        for _, n := range priorityNames {
+                p, err := ParsePriority(n)
+                if err != nil || p.String() != n {
+                        t.Errorf("priority %q got:%v err:%v", n, p, err)
+                }
+        }
+        if _, err := ParseSeverity("SEVERE"); err == nil {
+                t.Errorf("expected error parsing unknown severity")
+        }
+}
\ No newline at end of file
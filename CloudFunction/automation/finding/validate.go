@@ -0,0 +1,176 @@
+/*
+Package finding contains methods to deserialize and extract fields from findings.
+
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+        https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package finding
+
+import (
+        "sort"
+        "strings"
+        "sync"
+)
+
+var (
+        requiredMu sync.RWMutex
+        // requiredFields contains the requirements a finding must meet keyed by rule or sub rule name.
+        requiredFields = make(map[string][]requirement)
+)
+
+// requirement is met when any of its fields is present, or when its check passes if it has one.
+type requirement struct {
+        // fields are the paths of the fields, their description if there's a check.
+        fields []string
+        check  func(f *Finding) bool
+}
+
+// met returns whether the finding, decoded as entry, meets the requirement.
+func (r requirement) met(f *Finding, entry interface{}) bool {
+        if r.check != nil {
+                return r.check(f)
+        }
+        return anyPresent(entry, r.fields)
+}
+
+// ValidationError lists the required fields missing from a finding.
+//
+// ValidationError matches ErrorValueNotFound with errors.Is.
+type ValidationError struct {
+        // Missing contains a ParseError for each missing field.
+        Missing []*ParseError
+}
+
+// Error implements the error interface.
+func (e *ValidationError) Error() string {
+        fields := make([]string, 0, len(e.Missing))
+        for _, m := range e.Missing {
+                fields = append(fields, m.Field)
+        }
+        return ErrorValueNotFound.Error() + ": " + strings.Join(fields, ", ")
+}
+
+// Is reports whether the target is ErrorValueNotFound.
+func (e *ValidationError) Is(target error) bool {
+        return target == ErrorValueNotFound
+}
+
+// RequireFields marks fields findings with the given rule or sub rule name must have.
+//
+// Fields are paths into the ETD log entry, for example "jsonPayload.properties.location".
+func RequireFields(name string, fields ...string) {
+        requiredMu.Lock()
+        defer requiredMu.Unlock()
+        for _, field := range fields {
+                requiredFields[name] = append(requiredFields[name], requirement{fields: []string{field}})
+        }
+}
+
+// RequireAnyField marks fields findings with the given rule or sub rule name must have at least one of.
+//
+// A finding missing all of them is reported with the fields joined by " or ".
+// If RequireAnyField is called without fields, it panics.
+func RequireAnyField(name string, fields ...string) {
+        if len(fields) == 0 {
+                panic("finding: RequireAnyField called without fields for " + name)
+        }
+        requiredMu.Lock()
+        defer requiredMu.Unlock()
+        requiredFields[name] = append(requiredFields[name], requirement{fields: append([]string{}, fields...)})
+}
+
+// RequireCheck marks findings with the given rule or sub rule name as invalid unless the
+// check passes, for requirements on values rather than on fields being present.
+//
+// A finding failing the check is reported with the field as missing.
+// If RequireCheck is called with a nil check, it panics.
+func RequireCheck(name, field string, check func(f *Finding) bool) {
+        if check == nil {
+                panic("finding: RequireCheck called with a nil check for " + name)
+        }
+        requiredMu.Lock()
+        defer requiredMu.Unlock()
+        requiredFields[name] = append(requiredFields[name], requirement{fields: []string{field}, check: check})
+}
+
+// Validate checks the finding meets every requirement of its rule and sub rule.
+//
+// A *ValidationError is returned if any are missing or empty. Actions should call Validate
+// before relying on accessors, which return zero values when a field is absent.
+func (f *Finding) Validate() error {
+        var entry interface{}
+        if err := decode(f.data, &entry); err != nil {
+                return err
+        }
+
+        requiredMu.RLock()
+        requirements := append([]requirement{}, requiredFields[f.RuleName()]...)
+        if sr := f.etd.JSONPayload.DetectionCategory.SubRuleName; sr != "" {
+                requirements = append(requirements, requiredFields[sr]...)
+        }
+        requiredMu.RUnlock()
+
+        missing := []string{}
+        for _, r := range requirements {
+                if !r.met(f, entry) {
+                        missing = append(missing, strings.Join(r.fields, " or "))
+                }
+        }
+        sort.Strings(missing)
+
+        ve := &ValidationError{}
+        for _, field := range missing {
+                ve.Missing = append(ve.Missing, &ParseError{Field: field, Err: ErrorValueNotFound})
+        }
+        if len(ve.Missing) > 0 {
+                return ve
+        }
+        return nil
+}
+
+// anyPresent returns whether any of the fields has a non-empty value.
+func anyPresent(entry interface{}, fields []string) bool {
+        for _, field := range fields {
+                if present(entry, strings.Split(field, ".")) {
+                        return true
+                }
+        }
+        return false
+}
+
+// present returns whether the path leads to a non-empty value.
+func present(v interface{}, path []string) bool {
+        if len(path) == 0 {
+                switch t := v.(type) {
+                case nil:
+                        return false
+                case string:
+                        return t != ""
+                case []interface{}:
+                        return len(t) > 0
+                }
+                return true
+        }
+        obj, ok := v.(map[string]interface{})
+        if !ok {
+                return false
+        }
+        // Match keys the same way encoding/json does when decoding into structs.
+        for k, child := range obj {
+                if strings.EqualFold(k, path[0]) {
+                        return present(child, path[1:])
+                }
+        }
+        return false
+}
\ No newline at end of file
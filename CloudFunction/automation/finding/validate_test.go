@@ -0,0 +1,120 @@
+/*
+Package finding contains methods to deserialize and extract fields from findings.
+
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+        https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package finding
+
+import (
+        "errors"
+        "reflect"
+        "testing"
+
+        "cloud.google.com/go/pubsub"
+)
+
+// TestValidate verifies required fields are reported when missing.
+func TestValidate(t *testing.T) {
+        test := []struct {
+                name    string
+                message *pubsub.Message
+                missing []string
+        }{
+                {
+                        "complete bad ip finding",
+                        genNetworkMessage("bad_ip", `
+                                "project_id": "test-project",
+                                "location": "us-central1-c",
+                                "sourceInstance": "/projects/test-project/zones/us-central1-c/instances/instance-2"`),
+                        nil,
+                },
+                {
+                        "bad ip finding without instance",
+                        genNetworkMessage("bad_ip", `
+                                "project_id": "test-project",
+                                "location": "us-central1-c",
+                                "sourceInstance": ""`),
+                        []string{"jsonPayload.affectedResources instance or jsonPayload.properties.sourceInstance"},
+                },
+                {
+                        "bad ip finding with only affected resources",
+                        &pubsub.Message{Data: []byte(`{
+                                "jsonPayload": {
+                                        "detectionCategory": {"ruleName": "bad_ip"},
+                                        "affectedResources": [{"gcpResourceName": "//compute.googleapis.com/projects/p/zones/z/instances/i"}],
+                                        "properties": {"ip": ["8.8.8.8"]}
+                                },
+                                "logName": "projects/p/logs/threatdetection.googleapis.com%2Fdetection"
+                        }`)},
+                        nil,
+                },
+                {
+                        "bad ip finding with only the project affected",
+                        &pubsub.Message{Data: []byte(`{
+                                "jsonPayload": {
+                                        "detectionCategory": {"ruleName": "bad_ip"},
+                                        "affectedResources": [{"gcpResourceName": "//cloudresourcemanager.googleapis.com/projects/123"}],
+                                        "properties": {"ip": ["8.8.8.8"]}
+                                },
+                                "logName": "projects/p/logs/threatdetection.googleapis.com%2Fdetection"
+                        }`)},
+                        []string{"jsonPayload.affectedResources instance or jsonPayload.properties.sourceInstance"},
+                },
+                {
+                        "bad domain finding without properties",
+                        genNetworkMessage("bad_domain", ``),
+                        []string{"jsonPayload.affectedResources instance or jsonPayload.properties.sourceInstance"},
+                },
+                {
+                        "external member sub rule without members",
+                        genMessage("external_member_added_to_policy", `"externalMembers": []`),
+                        []string{"jsonPayload.properties.externalMembers"},
+                },
+                {
+                        "rule without requirements",
+                        genMessage("", ``),
+                        nil,
+                },
+        }
+        for _, tt := range test {
+                t.Run(tt.name, func(t *testing.T) {
+                        f := NewFinding()
+                        if err := f.ReadFinding(tt.message); err != nil {
+                                t.Fatalf("failed reading finding: %q", err)
+                        }
+                        err := f.Validate()
+                        if tt.missing == nil {
+                                if err != nil {
+                                        t.Errorf("%s failed got:%q want:nil", tt.name, err)
+                                }
+                                return
+                        }
+                        if !errors.Is(err, ErrorValueNotFound) {
+                                t.Fatalf("%s failed got:%q want:%q", tt.name, err, ErrorValueNotFound)
+                        }
+                        var ve *ValidationError
+                        if !errors.As(err, &ve) {
+                                t.Fatalf("%q is not a ValidationError", err)
+                        }
+                        got := []string{}
+                        for _, m := range ve.Missing {
+                                got = append(got, m.Field)
+                        }
+                        if !reflect.DeepEqual(got, tt.missing) {
+                                t.Errorf("%s failed got:%q want:%q", tt.name, got, tt.missing)
+                        }
+                })
+        }
+}
\ No newline at end of file
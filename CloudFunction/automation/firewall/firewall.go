@@ -20,11 +20,36 @@ package firewall
 import (
         "automation/clients"
 
+        "errors"
         "fmt"
+        "net"
+        "strconv"
+        "strings"
+        "time"
 
         cs "google.golang.org/api/compute/v1"
 )
 
+const (
+        // MaxDestinationRanges is how many destination ranges a firewall rule holds, the ranges
+        // blocked by BlockDestinations are sharded into rules of at most this many.
+        MaxDestinationRanges = 256
+        // blockPriority is the priority of the rules blocking destinations, it's above the
+        // default priority of 1000 so the usual allow rules don't let the traffic through.
+        blockPriority = 100
+        // blockDescriptionPrefix starts the description of the rules blocking destinations,
+        // the sources that added to a rule follow it.
+        blockDescriptionPrefix = "Denies egress to destinations blocked for: "
+        // maxDescriptionLength is the longest description a firewall rule can have.
+        maxDescriptionLength = 2048
+        // maxNameLength is the longest name a firewall rule can have.
+        maxNameLength = 63
+        // maxBlockAttempts is how many times blocking is tried when the rules change concurrently.
+        maxBlockAttempts = 5
+        // initialBlockBackoff is the wait before blocking again, doubled on each retry.
+        initialBlockBackoff = 500 * time.Millisecond
+)
+
 type client interface {
         clients.ComputeServiceInt
 }
@@ -32,11 +57,13 @@ type client interface {
 // Firewall struct
 type Firewall struct {
         c client
+        // sleep waits between attempts at blocking destinations, replaced in tests.
+        sleep func(time.Duration)
 }
 
 // NewFirewall returns a new instance of firewall.
 func NewFirewall(c client) *Firewall {
-        return &Firewall{c: c}
+        return &Firewall{c: c, sleep: time.Sleep}
 }
 
 // EnableFirewallRule sets the firewall rule to enabled.
@@ -57,4 +84,281 @@ func (f *Firewall) DisableFirewallRule(projectID string, name string) (*cs.Opera
                 return nil, fmt.Errorf("failed to disable firewall rule: %q", err)
         }
         return resp, nil
+}
+
+// CreateFirewallRule creates the firewall rule.
+func (f *Firewall) CreateFirewallRule(projectID string, rule *cs.Firewall) (*cs.Operation, error) {
+        resp, err := f.c.InsertFirewallRule(projectID, rule)
+        if err != nil {
+                return nil, fmt.Errorf("failed to create firewall rule: %q", err)
+        }
+        return resp, nil
+}
+
+// EnsureFirewallRule creates the rule unless a rule with its name exists, it returns true if
+// the rule was created.
+//
+// An existing rule is left as it is, except that it's enabled if it was disabled. It returns
+// once the rule is in effect.
+func (f *Firewall) EnsureFirewallRule(projectID string, rule *cs.Firewall) (bool, error) {
+        existing, err := f.c.GetFirewallRule(projectID, rule.Name)
+        if err == nil {
+                if existing.Disabled {
+                        op, err := f.EnableFirewallRule(projectID, rule.Name)
+                        if err != nil {
+                                return false, err
+                        }
+                        if err := f.wait(projectID, op); err != nil {
+                                return false, err
+                        }
+                }
+                return false, nil
+        }
+        if !errors.Is(err, clients.ErrNotFound) {
+                return false, fmt.Errorf("failed to get firewall rule: %q", err)
+        }
+        op, err := f.CreateFirewallRule(projectID, rule)
+        if err != nil {
+                return false, err
+        }
+        if err := f.wait(projectID, op); err != nil {
+                return false, err
+        }
+        return true, nil
+}
+
+// wait waits for the operation changing a rule to finish, the rule is in effect once it has.
+func (f *Firewall) wait(projectID string, op *cs.Operation) error {
+        if !started(op) {
+                return nil
+        }
+        if err := f.c.WaitGlobalOperation(projectID, op.Name); err != nil {
+                return fmt.Errorf("failed to wait for firewall rule: %q", err)
+        }
+        return nil
+}
+
+// started returns whether the operation was started, those of a dry run never are.
+func started(op *cs.Operation) bool {
+        return op != nil && op.Name != ""
+}
+
+// BlockDestinations denies egress from every instance on the network to the ranges.
+//
+// The network is the URL of the network. A rule can't mix address families, so IPv4 ranges
+// are merged into the rules named name-v4-0, name-v4-1 and so on and IPv6 ranges into
+// name-v6-0, name-v6-1 and so on, ranges already blocked are skipped. A rule is filled up to
+// MaxDestinationRanges before the next one is created. The description of each rule lists
+// every source that added ranges to it, a rule whose description can't take another source
+// isn't added to. The names of the rules created or updated are returned. It fails if a range
+// doesn't parse or a rule's name would be too long for a firewall rule.
+//
+// Rules aren't versioned, a concurrent change can overwrite the ranges added by another. The
+// rules changed are read back once in effect and when any of the ranges or the source are
+// missing, or a rule was created in between, the ranges are merged again after backing off.
+func (f *Firewall) BlockDestinations(projectID, network, name string, ranges []string, source string) ([]string, error) {
+        v4, v6 := []string{}, []string{}
+        for _, r := range ranges {
+                ip := net.ParseIP(r)
+                if ip == nil {
+                        var err error
+                        if ip, _, err = net.ParseCIDR(r); err != nil {
+                                return nil, fmt.Errorf("invalid destination range %q", r)
+                        }
+                }
+                if ip.To4() != nil {
+                        v4 = append(v4, r)
+                } else {
+                        v6 = append(v6, r)
+                }
+        }
+        changed := []string{}
+        for _, family := range []struct {
+                suffix string
+                ranges []string
+        }{{"-v4", v4}, {"-v6", v6}} {
+                if len(family.ranges) == 0 {
+                        continue
+                }
+                names, err := f.blockFamily(projectID, network, name+family.suffix, family.ranges, source)
+                changed = append(changed, names...)
+                if err != nil {
+                        return changed, err
+                }
+        }
+        return changed, nil
+}
+
+// blockFamily merges the ranges of a single address family into the rules, retrying on
+// concurrent changes, see BlockDestinations.
+func (f *Firewall) blockFamily(projectID, network, name string, ranges []string, source string) ([]string, error) {
+        changed := []string{}
+        backoff := initialBlockBackoff
+        for attempt := 1; ; attempt++ {
+                names, blocked, err := f.blockDestinations(projectID, network, name, ranges, source)
+                for _, n := range names {
+                        if !containsString(changed, n) {
+                                changed = append(changed, n)
+                        }
+                }
+                if err != nil {
+                        return changed, err
+                }
+                if blocked {
+                        return changed, nil
+                }
+                if attempt == maxBlockAttempts {
+                        return changed, fmt.Errorf("failed to block destinations after %d attempts: %w", attempt, clients.ErrConflict)
+                }
+                f.sleep(backoff)
+                backoff *= 2
+        }
+}
+
+// blockDestinations merges the ranges into the rules once, see BlockDestinations. It returns
+// the names of the rules changed and whether the ranges were found blocked once read back,
+// changes whose operation wasn't started aren't read back.
+func (f *Firewall) blockDestinations(projectID, network, name string, ranges []string, source string) ([]string, bool, error) {
+        var rules []*cs.Firewall
+        blocked := map[string]bool{}
+        for n := 0; ; n++ {
+                rule, err := f.c.GetFirewallRule(projectID, name+"-"+strconv.Itoa(n))
+                if errors.Is(err, clients.ErrNotFound) {
+                        break
+                }
+                if err != nil {
+                        return nil, false, fmt.Errorf("failed to get firewall rule: %q", err)
+                }
+                for _, r := range rule.DestinationRanges {
+                        blocked[r] = true
+                }
+                rules = append(rules, rule)
+        }
+
+        pending := []string{}
+        for _, r := range ranges {
+                if !blocked[r] {
+                        blocked[r] = true
+                        pending = append(pending, r)
+                }
+        }
+
+        changed := []string{}
+        // written maps the name of each rule changed to the ranges it's expected to hold.
+        written := map[string][]string{}
+        for _, rule := range rules {
+                free := MaxDestinationRanges - len(rule.DestinationRanges)
+                if len(pending) == 0 || free <= 0 {
+                        continue
+                }
+                description, ok := blockDescription(rule.Description, source)
+                if !ok {
+                        continue
+                }
+                if free > len(pending) {
+                        free = len(pending)
+                }
+                rb := &cs.Firewall{
+                        Description:       description,
+                        DestinationRanges: append(append([]string{}, rule.DestinationRanges...), pending[:free]...),
+                }
+                op, err := f.c.PatchFirewallRule(projectID, rule.Name, rb)
+                if err != nil {
+                        return changed, false, fmt.Errorf("failed to update firewall rule %q: %q", rule.Name, err)
+                }
+                if err := f.wait(projectID, op); err != nil {
+                        return changed, false, err
+                }
+                changed = append(changed, rule.Name)
+                if started(op) {
+                        written[rule.Name] = pending[:free]
+                }
+                pending = pending[free:]
+        }
+
+        for n := len(rules); len(pending) > 0; n++ {
+                size := MaxDestinationRanges
+                if size > len(pending) {
+                        size = len(pending)
+                }
+                description, _ := blockDescription("", source)
+                rule := &cs.Firewall{
+                        Name:              name + "-" + strconv.Itoa(n),
+                        Description:       description,
+                        Network:           network,
+                        Direction:         "EGRESS",
+                        Priority:          blockPriority,
+                        Denied:            []*cs.FirewallDenied{{IPProtocol: "all"}},
+                        DestinationRanges: pending[:size],
+                }
+                if len(rule.Name) > maxNameLength {
+                        return changed, false, fmt.Errorf("blocking rule name %q is longer than %d characters", rule.Name, maxNameLength)
+                }
+                op, err := f.c.InsertFirewallRule(projectID, rule)
+                if errors.Is(err, clients.ErrConflict) {
+                        // The rule was created since the rules were read, it's merged into next.
+                        return changed, false, nil
+                }
+                if err != nil {
+                        return changed, false, fmt.Errorf("failed to create firewall rule: %q", err)
+                }
+                if err := f.wait(projectID, op); err != nil {
+                        return changed, false, err
+                }
+                changed = append(changed, rule.Name)
+                if started(op) {
+                        written[rule.Name] = pending[:size]
+                }
+                pending = pending[size:]
+        }
+
+        for n, ranges := range written {
+                rule, err := f.c.GetFirewallRule(projectID, n)
+                if err != nil {
+                        return changed, false, fmt.Errorf("failed to get firewall rule: %q", err)
+                }
+                if !containsString(blockSources(rule.Description), source) {
+                        return changed, false, nil
+                }
+                for _, r := range ranges {
+                        if !containsString(rule.DestinationRanges, r) {
+                                return changed, false, nil
+                        }
+                }
+        }
+        return changed, true, nil
+}
+
+// blockDescription returns the description listing the sources of a blocking rule with the
+// source added, false if it would be longer than a rule's description can be.
+func blockDescription(description, source string) (string, bool) {
+        sources := blockSources(description)
+        if !containsString(sources, source) {
+                sources = append(sources, source)
+        }
+        description = blockDescriptionPrefix + strings.Join(sources, ", ")
+        return description, len(description) <= maxDescriptionLength
+}
+
+// blockSources returns the sources listed by the description of a blocking rule, a
+// description not written by BlockDestinations lists none.
+func blockSources(description string) []string {
+        if !strings.HasPrefix(description, blockDescriptionPrefix) {
+                return nil
+        }
+        list := strings.TrimPrefix(description, blockDescriptionPrefix)
+        if list == "" {
+                return nil
+        }
+        return strings.Split(list, ", ")
+}
+
+// containsString returns whether the value is in the slice.
+func containsString(slice []string, value string) bool {
+        for _, v := range slice {
+                if v == value {
+                        return true
+                }
+        }
+        return false
 }
\ No newline at end of file
@@ -19,13 +19,20 @@ package firewall
 
 import (
         "automation/clients"
-        cs "google.golang.org/api/compute/v1"
+        "errors"
+        "fmt"
+        "reflect"
+        "strings"
         "testing"
+        "time"
+
+        cs "google.golang.org/api/compute/v1"
 )
 
 const (
         projectID = "test-project-id"
         ruleName  = "generic-rule-name"
+        network   = "projects/test-project-id/global/networks/default"
 )
 
 func TestEnableFirewallRule(t *testing.T) {
@@ -48,8 +55,8 @@ func TestEnableFirewallRule(t *testing.T) {
                         if err != tt.expectedError {
                                 t.Errorf("%v failed exp:%v got: %v", tt.name, tt.expectedError, err)
                         }
-                        if mock.SavedFirewallRule.Disabled != tt.expectedResponse.Disabled {
-                                t.Errorf("%v failed exp:%v got:%v", tt.name, tt.expectedResponse, mock.SavedFirewallRule)
+                        if got := mock.SavedFirewallRules[ruleName]; got.Disabled != tt.expectedResponse.Disabled {
+                                t.Errorf("%v failed exp:%v got:%v", tt.name, tt.expectedResponse, got)
                         }
 
                 })
@@ -76,10 +83,337 @@ func TestDisableFirewallRule(t *testing.T) {
                         if err != tt.expectedError {
                                 t.Errorf("%v failed exp:%q got: %q", tt.name, tt.expectedError, err)
                         }
-                        if mock.SavedFirewallRule.Disabled != tt.expectedResponse.Disabled {
-                                t.Errorf("%v failed exp:%v got:%v", tt.name, tt.expectedResponse, mock.SavedFirewallRule)
+                        if got := mock.SavedFirewallRules[ruleName]; got.Disabled != tt.expectedResponse.Disabled {
+                                t.Errorf("%v failed exp:%v got:%v", tt.name, tt.expectedResponse, got)
                         }
 
                 })
         }
+}
+
+func TestEnsureFirewallRule(t *testing.T) {
+        tests := []struct {
+                name            string
+                existing        *cs.Firewall
+                expectedCreated bool
+                expectedEnabled bool
+                expectedWaited  []string
+        }{
+                {
+                        name:            "creates missing rule",
+                        expectedCreated: true,
+                        expectedWaited:  []string{"insert-" + ruleName},
+                },
+                {
+                        name:     "keeps existing rule",
+                        existing: &cs.Firewall{Name: ruleName},
+                },
+                {
+                        name:            "enables disabled rule",
+                        existing:        &cs.Firewall{Name: ruleName, Disabled: true},
+                        expectedEnabled: true,
+                        expectedWaited:  []string{"patch-" + ruleName},
+                },
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        mock := &clients.MockClients{}
+                        if tt.existing != nil {
+                                mock.AddFirewallRuleFake(tt.existing)
+                        }
+                        f := NewFirewall(mock)
+                        created, err := f.EnsureFirewallRule(projectID, &cs.Firewall{Name: ruleName})
+                        if err != nil {
+                                t.Fatalf("%v failed: %q", tt.name, err)
+                        }
+                        if created != tt.expectedCreated {
+                                t.Errorf("%v failed created got:%v want:%v", tt.name, created, tt.expectedCreated)
+                        }
+                        if inserted := len(mock.SavedInsertedFirewalls) > 0; inserted != tt.expectedCreated {
+                                t.Errorf("%v failed inserted got:%v want:%v", tt.name, inserted, tt.expectedCreated)
+                        }
+                        rb := mock.SavedFirewallRules[ruleName]
+                        if enabled := rb != nil && !rb.Disabled; enabled != tt.expectedEnabled {
+                                t.Errorf("%v failed enabled got:%v want:%v", tt.name, enabled, tt.expectedEnabled)
+                        }
+                        if !reflect.DeepEqual(mock.SavedWaitedOperations, tt.expectedWaited) {
+                                t.Errorf("%v failed waited got:%q want:%q", tt.name, mock.SavedWaitedOperations, tt.expectedWaited)
+                        }
+                })
+        }
+}
+
+func TestEnsureFirewallRuleWaitFails(t *testing.T) {
+        mock := &clients.MockClients{}
+        mock.AddWaitOperationErrorFake(errors.New("operation failed"))
+        _, err := NewFirewall(mock).EnsureFirewallRule(projectID, &cs.Firewall{Name: ruleName})
+        if exp := `failed to wait for firewall rule: "operation failed"`; err == nil || err.Error() != exp {
+                t.Errorf("failed got:%v want:%q", err, exp)
+        }
+}
+
+func TestBlockDestinations(t *testing.T) {
+        full := ipRanges(MaxDestinationRanges)
+        tests := []struct {
+                name            string
+                existing        []*cs.Firewall
+                ranges          []string
+                expectedChanged []string
+                expectedPatched map[string]int
+                expectedCreated map[string]int
+        }{
+                {
+                        name:            "creates first rule",
+                        ranges:          []string{"8.8.8.8", "8.8.4.4"},
+                        expectedChanged: []string{"block-v4-0"},
+                        expectedCreated: map[string]int{"block-v4-0": 2},
+                },
+                {
+                        name:            "merges into existing rule",
+                        existing:        []*cs.Firewall{{Name: "block-v4-0", DestinationRanges: []string{"8.8.8.8"}}},
+                        ranges:          []string{"8.8.8.8", "8.8.4.4"},
+                        expectedChanged: []string{"block-v4-0"},
+                        expectedPatched: map[string]int{"block-v4-0": 2},
+                },
+                {
+                        name:            "skips blocked ranges",
+                        existing:        []*cs.Firewall{{Name: "block-v4-0", DestinationRanges: []string{"8.8.8.8"}}},
+                        ranges:          []string{"8.8.8.8"},
+                        expectedChanged: []string{},
+                },
+                {
+                        name:            "shards past full rule",
+                        existing:        []*cs.Firewall{{Name: "block-v4-0", DestinationRanges: full}},
+                        ranges:          []string{"8.8.8.8"},
+                        expectedChanged: []string{"block-v4-1"},
+                        expectedCreated: map[string]int{"block-v4-1": 1},
+                },
+                {
+                        name:            "shards many ranges",
+                        existing:        []*cs.Firewall{{Name: "block-v4-0", DestinationRanges: []string{"8.8.8.8"}}},
+                        ranges:          append(ipRanges(MaxDestinationRanges+10), "8.8.8.8"),
+                        expectedChanged: []string{"block-v4-0", "block-v4-1"},
+                        expectedPatched: map[string]int{"block-v4-0": MaxDestinationRanges},
+                        expectedCreated: map[string]int{"block-v4-1": 11},
+                },
+                {
+                        name:            "shards per address family",
+                        existing:        []*cs.Firewall{{Name: "block-v4-0", DestinationRanges: []string{"8.8.8.8"}}},
+                        ranges:          []string{"2001:4860:4860::8888", "8.8.4.4", "2001:db8::/32"},
+                        expectedChanged: []string{"block-v4-0", "block-v6-0"},
+                        expectedPatched: map[string]int{"block-v4-0": 2},
+                        expectedCreated: map[string]int{"block-v6-0": 2},
+                },
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        mock := &clients.MockClients{}
+                        for _, r := range tt.existing {
+                                mock.AddFirewallRuleFake(r)
+                        }
+                        changed, err := NewFirewall(mock).BlockDestinations(projectID, network, "block", tt.ranges, "finding1")
+                        if err != nil {
+                                t.Fatalf("%v failed: %q", tt.name, err)
+                        }
+                        if !reflect.DeepEqual(changed, tt.expectedChanged) {
+                                t.Errorf("%v failed changed got:%q want:%q", tt.name, changed, tt.expectedChanged)
+                        }
+                        patched := map[string]int{}
+                        for name, rb := range mock.SavedFirewallRules {
+                                patched[name] = len(rb.DestinationRanges)
+                        }
+                        created := map[string]int{}
+                        for _, rb := range mock.SavedInsertedFirewalls {
+                                if rb.Direction != "EGRESS" || rb.Network != network {
+                                        t.Errorf("%v failed rule %q is %s on %q", tt.name, rb.Name, rb.Direction, rb.Network)
+                                }
+                                if exp := blockDescriptionPrefix + "finding1"; rb.Description != exp {
+                                        t.Errorf("%v failed description got:%q want:%q", tt.name, rb.Description, exp)
+                                }
+                                created[rb.Name] = len(rb.DestinationRanges)
+                        }
+                        if len(patched) > 0 || len(tt.expectedPatched) > 0 {
+                                if !reflect.DeepEqual(patched, tt.expectedPatched) {
+                                        t.Errorf("%v failed patched got:%v want:%v", tt.name, patched, tt.expectedPatched)
+                                }
+                        }
+                        if len(created) > 0 || len(tt.expectedCreated) > 0 {
+                                if !reflect.DeepEqual(created, tt.expectedCreated) {
+                                        t.Errorf("%v failed created got:%v want:%v", tt.name, created, tt.expectedCreated)
+                                }
+                        }
+                })
+        }
+}
+
+func TestBlockDestinationsSources(t *testing.T) {
+        long := strings.Repeat("x", maxDescriptionLength-len(blockDescriptionPrefix)-len(", finding2")+1)
+        tests := []struct {
+                name                 string
+                existing             *cs.Firewall
+                expectedDescriptions map[string]string
+        }{
+                {
+                        name:                 "adds source to rule",
+                        existing:             &cs.Firewall{Name: "block-v4-0", Description: blockDescriptionPrefix + "finding1"},
+                        expectedDescriptions: map[string]string{"block-v4-0": blockDescriptionPrefix + "finding1, finding2"},
+                },
+                {
+                        name:                 "keeps listed source",
+                        existing:             &cs.Firewall{Name: "block-v4-0", Description: blockDescriptionPrefix + "finding2, finding1"},
+                        expectedDescriptions: map[string]string{"block-v4-0": blockDescriptionPrefix + "finding2, finding1"},
+                },
+                {
+                        name:                 "replaces other description",
+                        existing:             &cs.Firewall{Name: "block-v4-0", Description: "Blocks bad IPs."},
+                        expectedDescriptions: map[string]string{"block-v4-0": blockDescriptionPrefix + "finding2"},
+                },
+                {
+                        name:     "shards past full description",
+                        existing: &cs.Firewall{Name: "block-v4-0", Description: blockDescriptionPrefix + long},
+                        expectedDescriptions: map[string]string{
+                                "block-v4-0": blockDescriptionPrefix + long,
+                                "block-v4-1": blockDescriptionPrefix + "finding2",
+                        },
+                },
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        mock := &clients.MockClients{}
+                        mock.AddFirewallRuleFake(tt.existing)
+                        if _, err := NewFirewall(mock).BlockDestinations(projectID, network, "block", []string{"8.8.8.8"}, "finding2"); err != nil {
+                                t.Fatalf("%v failed: %q", tt.name, err)
+                        }
+                        got := map[string]string{}
+                        for name := range tt.expectedDescriptions {
+                                rule, err := mock.GetFirewallRule(projectID, name)
+                                if err != nil {
+                                        t.Fatalf("%v failed: %q", tt.name, err)
+                                }
+                                got[name] = rule.Description
+                        }
+                        if !reflect.DeepEqual(got, tt.expectedDescriptions) {
+                                t.Errorf("%v failed got:%q want:%q", tt.name, got, tt.expectedDescriptions)
+                        }
+                })
+        }
+}
+
+func TestBlockDestinationsRetries(t *testing.T) {
+        tests := []struct {
+                name             string
+                existing         *cs.Firewall
+                races            int
+                expectedRanges   []string
+                expectedSlept    []time.Duration
+                expectedError    error
+                expectedInserted int
+        }{
+                {
+                        name:           "merges again after concurrent patch",
+                        existing:       &cs.Firewall{Name: "block-v4-0", DestinationRanges: []string{"1.1.1.1"}},
+                        races:          1,
+                        expectedRanges: []string{"1.1.1.1", "9.9.9.9", "8.8.8.8"},
+                        expectedSlept:  []time.Duration{initialBlockBackoff},
+                },
+                {
+                        name:             "merges again after overwritten create",
+                        races:            1,
+                        expectedRanges:   []string{"1.1.1.1", "9.9.9.9", "8.8.8.8"},
+                        expectedSlept:    []time.Duration{initialBlockBackoff},
+                        expectedInserted: 1,
+                },
+                {
+                        name:           "gives up after attempts",
+                        existing:       &cs.Firewall{Name: "block-v4-0", DestinationRanges: []string{"1.1.1.1"}},
+                        races:          maxBlockAttempts,
+                        expectedRanges: []string{"1.1.1.1", "9.9.9.9"},
+                        expectedSlept:  []time.Duration{initialBlockBackoff, 2 * initialBlockBackoff, 4 * initialBlockBackoff, 8 * initialBlockBackoff},
+                        expectedError:  clients.ErrConflict,
+                },
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        mock := &clients.MockClients{}
+                        if tt.existing != nil {
+                                mock.AddFirewallRuleFake(tt.existing)
+                        }
+                        // Another writer overwrites block-v4-0 right after the next races changes to
+                        // it, with the ranges it read before the change plus its own.
+                        races := tt.races
+                        race := func() {
+                                if races > 0 {
+                                        races--
+                                        mock.AddFirewallRuleRaceFake(&cs.Firewall{Name: "block-v4-0", DestinationRanges: []string{"1.1.1.1", "9.9.9.9"}})
+                                }
+                        }
+                        race()
+                        f := NewFirewall(mock)
+                        slept := []time.Duration{}
+                        f.sleep = func(d time.Duration) {
+                                slept = append(slept, d)
+                                race()
+                        }
+                        changed, err := f.BlockDestinations(projectID, network, "block", []string{"8.8.8.8"}, "finding1")
+                        if !errors.Is(err, tt.expectedError) {
+                                t.Errorf("%v failed error got:%v want:%v", tt.name, err, tt.expectedError)
+                        }
+                        if !reflect.DeepEqual(changed, []string{"block-v4-0"}) {
+                                t.Errorf("%v failed changed got:%q", tt.name, changed)
+                        }
+                        rule, _ := mock.GetFirewallRule(projectID, "block-v4-0")
+                        if !reflect.DeepEqual(rule.DestinationRanges, tt.expectedRanges) {
+                                t.Errorf("%v failed ranges got:%q want:%q", tt.name, rule.DestinationRanges, tt.expectedRanges)
+                        }
+                        if !reflect.DeepEqual(slept, tt.expectedSlept) {
+                                t.Errorf("%v failed slept got:%v want:%v", tt.name, slept, tt.expectedSlept)
+                        }
+                        if len(mock.SavedInsertedFirewalls) != tt.expectedInserted {
+                                t.Errorf("%v failed inserted got:%d want:%d", tt.name, len(mock.SavedInsertedFirewalls), tt.expectedInserted)
+                        }
+                })
+        }
+}
+
+func TestBlockDestinationsErrors(t *testing.T) {
+        tests := []struct {
+                name          string
+                ruleName      string
+                ranges        []string
+                expectedError string
+        }{
+                {
+                        name:          "invalid range",
+                        ruleName:      "block",
+                        ranges:        []string{"8.8.8.8", "not-an-ip"},
+                        expectedError: `invalid destination range "not-an-ip"`,
+                },
+                {
+                        name:          "name too long",
+                        ruleName:      "block-indicators-" + strings.Repeat("n", 42),
+                        ranges:        []string{"8.8.8.8"},
+                        expectedError: `blocking rule name "block-indicators-` + strings.Repeat("n", 42) + `-v4-0" is longer than 63 characters`,
+                },
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        mock := &clients.MockClients{}
+                        _, err := NewFirewall(mock).BlockDestinations(projectID, network, tt.ruleName, tt.ranges, "finding1")
+                        if err == nil || err.Error() != tt.expectedError {
+                                t.Errorf("%v failed got:%v want:%q", tt.name, err, tt.expectedError)
+                        }
+                        if len(mock.SavedInsertedFirewalls) != 0 {
+                                t.Errorf("%v failed created rules: %v", tt.name, mock.SavedInsertedFirewalls)
+                        }
+                })
+        }
+}
+
+// ipRanges returns n distinct IP addresses.
+func ipRanges(n int) []string {
+        ranges := []string{}
+        for i := 0; i < n; i++ {
+                ranges = append(ranges, fmt.Sprintf("10.0.%d.%d", i/256, i%256))
+        }
+        return ranges
 }
\ No newline at end of file
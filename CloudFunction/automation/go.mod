@@ -1,6 +1,6 @@
 module automation
 
-go 1.12
+go 1.13
 
 require (
 	cloud.google.com/go v0.41.0
@@ -8,7 +8,7 @@ require (
 	github.com/golang/protobuf v1.3.2 // indirect
 	github.com/kylelemons/godebug v1.1.0
 	golang.org/x/oauth2 v0.0.0-20190604053449-0f29369cfe45
-	google.golang.org/api v0.7.0
+	google.golang.org/api v0.8.0
 	google.golang.org/genproto v0.0.0-20190708153700-3bdd9d9f5532
 	google.golang.org/grpc v1.22.1 // indirect
 	gopkg.in/d4l3k/messagediff.v1 v1.2.1
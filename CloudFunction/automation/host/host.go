@@ -72,7 +72,7 @@ func (h *Host) ListInstanceDisks(projectID, zone, instance string) ([]string, er
         dl := []string{}
         for _, d := range ds.Items {
                 if !h.diskBelongsToInstance(d, instance) {
-                        return []string{}, nil
+                        continue
                 }
                 dl = append(dl, d.Name)
         }
@@ -99,4 +99,41 @@ func (h *Host) SetSnapshotLabels(projectID, resource string, m map[string]string
         }
         return nil
 
+}
+
+// InstanceNetworks returns the networks the instance's interfaces are attached to.
+func (h *Host) InstanceNetworks(projectID, zone, instance string) ([]string, error) {
+        i, err := h.c.GetInstance(projectID, zone, instance)
+        if err != nil {
+                return nil, fmt.Errorf("failed to get instance: %q", err)
+        }
+        networks := []string{}
+        for _, n := range i.NetworkInterfaces {
+                networks = append(networks, n.Network)
+        }
+        return networks, nil
+}
+
+// AddInstanceTag adds the network tag to the instance, it returns false if the instance
+// already had it.
+func (h *Host) AddInstanceTag(projectID, zone, instance, tag string) (bool, error) {
+        i, err := h.c.GetInstance(projectID, zone, instance)
+        if err != nil {
+                return false, fmt.Errorf("failed to get instance: %q", err)
+        }
+        tags := &cs.Tags{}
+        if i.Tags != nil {
+                tags.Items = append(tags.Items, i.Tags.Items...)
+                tags.Fingerprint = i.Tags.Fingerprint
+        }
+        for _, t := range tags.Items {
+                if t == tag {
+                        return false, nil
+                }
+        }
+        tags.Items = append(tags.Items, tag)
+        if _, err := h.c.SetInstanceTags(projectID, zone, instance, tags); err != nil {
+                return false, fmt.Errorf("failed to set instance tags: %q", err)
+        }
+        return true, nil
 }
\ No newline at end of file
@@ -0,0 +1,150 @@
+/*
+Package journal records IAM policy changes so they can be undone.
+
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+        https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package journal
+
+import (
+        "context"
+        "encoding/json"
+        "fmt"
+        "io/ioutil"
+        "sort"
+        "strings"
+
+        "cloud.google.com/go/storage"
+        "google.golang.org/api/iterator"
+)
+
+// gcsPrefix is the prefix of the objects entries are kept in.
+const gcsPrefix = "journal/"
+
+// objects reads and writes the objects of a bucket.
+type objects interface {
+        // write saves b as the object with the name.
+        write(name string, b []byte) error
+        // read returns the contents of the object with the name.
+        read(name string) ([]byte, error)
+        // list returns the names of the objects starting with the prefix.
+        list(prefix string) ([]string, error)
+}
+
+// GCSStore is a Store keeping each entry as a JSON object in a Cloud Storage bucket.
+//
+// Entries outlive the function instance that wrote them, so a change can be restored from
+// any instance. The function's service account needs to create and read objects in the bucket.
+type GCSStore struct {
+        objects objects
+}
+
+// NewGCSStore returns a GCSStore keeping entries under "journal/" in the bucket.
+func NewGCSStore(ctx context.Context, c *storage.Client, bucket string) *GCSStore {
+        return &GCSStore{objects: &gcsObjects{ctx: ctx, bucket: c.Bucket(bucket)}}
+}
+
+// Put saves the entry, replacing any entry with the same ID.
+func (s *GCSStore) Put(e *Entry) error {
+        b, err := json.Marshal(e)
+        if err != nil {
+                return fmt.Errorf("failed to marshal entry: %q", err)
+        }
+        if err := s.objects.write(gcsPrefix+e.ID+".json", b); err != nil {
+                return fmt.Errorf("failed to write entry: %q", err)
+        }
+        return nil
+}
+
+// Get returns the entry with the ID.
+func (s *GCSStore) Get(id string) (*Entry, error) {
+        b, err := s.objects.read(gcsPrefix + id + ".json")
+        if err != nil {
+                return nil, fmt.Errorf("failed to read entry: %q", err)
+        }
+        return unmarshalEntry(b)
+}
+
+// List returns every entry, oldest first.
+func (s *GCSStore) List() ([]*Entry, error) {
+        names, err := s.objects.list(gcsPrefix)
+        if err != nil {
+                return nil, fmt.Errorf("failed to list entries: %q", err)
+        }
+        sort.Strings(names)
+        entries := []*Entry{}
+        for _, n := range names {
+                if !strings.HasSuffix(n, ".json") {
+                        continue
+                }
+                e, err := s.Get(strings.TrimSuffix(strings.TrimPrefix(n, gcsPrefix), ".json"))
+                if err != nil {
+                        return nil, err
+                }
+                entries = append(entries, e)
+        }
+        return entries, nil
+}
+
+// FindByInsertID returns the entries of the changes a finding caused, oldest first.
+func (s *GCSStore) FindByInsertID(insertID string) ([]*Entry, error) {
+        entries, err := s.List()
+        if err != nil {
+                return nil, err
+        }
+        return byInsertID(entries, insertID), nil
+}
+
+// gcsObjects are the objects of a Cloud Storage bucket.
+type gcsObjects struct {
+        ctx    context.Context
+        bucket *storage.BucketHandle
+}
+
+// write saves b as the object with the name.
+func (o *gcsObjects) write(name string, b []byte) error {
+        w := o.bucket.Object(name).NewWriter(o.ctx)
+        w.ContentType = "application/json"
+        if _, err := w.Write(b); err != nil {
+                w.Close()
+                return err
+        }
+        return w.Close()
+}
+
+// read returns the contents of the object with the name.
+func (o *gcsObjects) read(name string) ([]byte, error) {
+        r, err := o.bucket.Object(name).NewReader(o.ctx)
+        if err != nil {
+                return nil, err
+        }
+        defer r.Close()
+        return ioutil.ReadAll(r)
+}
+
+// list returns the names of the objects starting with the prefix.
+func (o *gcsObjects) list(prefix string) ([]string, error) {
+        names := []string{}
+        it := o.bucket.Objects(o.ctx, &storage.Query{Prefix: prefix})
+        for {
+                attrs, err := it.Next()
+                if err == iterator.Done {
+                        return names, nil
+                }
+                if err != nil {
+                        return nil, err
+                }
+                names = append(names, attrs.Name)
+        }
+}
\ No newline at end of file
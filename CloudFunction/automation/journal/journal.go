@@ -0,0 +1,156 @@
+/*
+Package journal records IAM policy changes so they can be undone.
+
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+        https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package journal
+
+import (
+        "encoding/json"
+        "fmt"
+        "io/ioutil"
+        "os"
+        "path/filepath"
+        "sort"
+        "strings"
+        "time"
+
+        crm "google.golang.org/api/cloudresourcemanager/v1"
+)
+
+// Entry records an IAM policy change before it's made.
+type Entry struct {
+        // ID identifies the entry within its store.
+        ID string `json:"id"`
+        // Resource is the resource whose policy changed, for example "projects/p" or "folders/123".
+        Resource string `json:"resource"`
+        // Policy is the policy as it was before the change.
+        Policy *crm.Policy `json:"policy"`
+        // Removed contains the members removed from each role.
+        Removed map[string][]string `json:"removed,omitempty"`
+        // Added contains the members added to each role.
+        Added map[string][]string `json:"added,omitempty"`
+        // InsertID is the insert ID of the finding that caused the change, if any.
+        InsertID string `json:"insertId,omitempty"`
+        // Time is when the change was made.
+        Time time.Time `json:"time"`
+}
+
+// NewEntry returns a new entry for a change to the resource's policy at the given time.
+func NewEntry(resource string, t time.Time) *Entry {
+        id := t.UTC().Format("20060102T150405.000000000Z") + "-" + strings.Replace(resource, "/", "-", -1)
+        return &Entry{ID: id, Resource: resource, Time: t}
+}
+
+// Store persists journal entries.
+type Store interface {
+        // Put saves the entry, replacing any entry with the same ID.
+        Put(e *Entry) error
+        // Get returns the entry with the ID.
+        Get(id string) (*Entry, error)
+        // List returns every entry, oldest first.
+        List() ([]*Entry, error)
+        // FindByInsertID returns the entries of the changes a finding caused, oldest first.
+        FindByInsertID(insertID string) ([]*Entry, error)
+}
+
+// byInsertID returns the entries caused by the finding with the insert ID.
+func byInsertID(entries []*Entry, insertID string) []*Entry {
+        found := []*Entry{}
+        for _, e := range entries {
+                if e.InsertID == insertID {
+                        found = append(found, e)
+                }
+        }
+        return found
+}
+
+// FileStore is a Store keeping each entry as a JSON file in a directory.
+//
+// The directory is only as durable as the disk it's on, a Cloud Function's temporary
+// directory is lost with its instance. Use a GCSStore to keep entries across instances.
+type FileStore struct {
+        dir string
+}
+
+// NewFileStore returns a FileStore in the directory, it's created on the first Put.
+func NewFileStore(dir string) *FileStore {
+        return &FileStore{dir: dir}
+}
+
+// Put saves the entry, replacing any entry with the same ID.
+func (s *FileStore) Put(e *Entry) error {
+        if err := os.MkdirAll(s.dir, 0700); err != nil {
+                return fmt.Errorf("failed to create journal: %q", err)
+        }
+        b, err := json.Marshal(e)
+        if err != nil {
+                return fmt.Errorf("failed to marshal entry: %q", err)
+        }
+        if err := ioutil.WriteFile(s.path(e.ID), b, 0600); err != nil {
+                return fmt.Errorf("failed to write entry: %q", err)
+        }
+        return nil
+}
+
+// Get returns the entry with the ID.
+func (s *FileStore) Get(id string) (*Entry, error) {
+        b, err := ioutil.ReadFile(s.path(id))
+        if err != nil {
+                return nil, fmt.Errorf("failed to read entry: %q", err)
+        }
+        return unmarshalEntry(b)
+}
+
+// List returns every entry, oldest first.
+func (s *FileStore) List() ([]*Entry, error) {
+        names, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+        if err != nil {
+                return nil, fmt.Errorf("failed to list entries: %q", err)
+        }
+        sort.Strings(names)
+        entries := []*Entry{}
+        for _, n := range names {
+                e, err := s.Get(strings.TrimSuffix(filepath.Base(n), ".json"))
+                if err != nil {
+                        return nil, err
+                }
+                entries = append(entries, e)
+        }
+        return entries, nil
+}
+
+// FindByInsertID returns the entries of the changes a finding caused, oldest first.
+func (s *FileStore) FindByInsertID(insertID string) ([]*Entry, error) {
+        entries, err := s.List()
+        if err != nil {
+                return nil, err
+        }
+        return byInsertID(entries, insertID), nil
+}
+
+// unmarshalEntry returns the entry encoded in b.
+func unmarshalEntry(b []byte) (*Entry, error) {
+        var e Entry
+        if err := json.Unmarshal(b, &e); err != nil {
+                return nil, fmt.Errorf("failed to unmarshal entry: %q", err)
+        }
+        return &e, nil
+}
+
+// path returns the file the entry with the ID is kept in.
+func (s *FileStore) path(id string) string {
+        return filepath.Join(s.dir, filepath.Base(id)+".json")
+}
\ No newline at end of file
@@ -0,0 +1,129 @@
+/*
+Package journal records IAM policy changes so they can be undone.
+
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+        https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package journal
+
+import (
+        "fmt"
+        "io/ioutil"
+        "os"
+        "path/filepath"
+        "reflect"
+        "strings"
+        "testing"
+        "time"
+
+        crm "google.golang.org/api/cloudresourcemanager/v1"
+)
+
+func TestFileStore(t *testing.T) {
+        dir, err := ioutil.TempDir("", "journal")
+        if err != nil {
+                t.Fatalf("failed to create directory: %q", err)
+        }
+        defer os.RemoveAll(dir)
+        testStore(t, NewFileStore(filepath.Join(dir, "entries")))
+}
+
+func TestGCSStore(t *testing.T) {
+        testStore(t, &GCSStore{objects: memoryObjects{}})
+}
+
+// testStore verifies entries are put, read back, listed and found by the store.
+func testStore(t *testing.T, s Store) {
+        e := NewEntry("projects/test-project", time.Date(2019, 7, 16, 21, 0, 44, 0, time.UTC))
+        e.Policy = &crm.Policy{
+                Bindings: []*crm.Binding{{Role: "roles/editor", Members: []string{"user:tom@gmail.com"}}},
+                Etag:     "BwWKmjvelug=",
+        }
+        e.Removed = map[string][]string{"roles/editor": {"user:tom@gmail.com"}}
+        e.InsertID = "eppsoda4"
+
+        if err := s.Put(e); err != nil {
+                t.Fatalf("failed to put entry: %q", err)
+        }
+        got, err := s.Get(e.ID)
+        if err != nil {
+                t.Fatalf("failed to get entry: %q", err)
+        }
+        if !reflect.DeepEqual(got, e) {
+                t.Errorf("failed got:%+v want:%+v", got, e)
+        }
+        if _, err := s.Get("missing"); err == nil {
+                t.Errorf("failed got entry for missing ID")
+        }
+
+        older := NewEntry("folders/123", time.Date(2019, 7, 16, 20, 0, 0, 0, time.UTC))
+        older.InsertID = "other"
+        newer := NewEntry("projects/other-project", time.Date(2019, 7, 16, 22, 0, 0, 0, time.UTC))
+        newer.InsertID = "eppsoda4"
+        for _, n := range []*Entry{newer, older} {
+                if err := s.Put(n); err != nil {
+                        t.Fatalf("failed to put entry: %q", err)
+                }
+        }
+        list, err := s.List()
+        if err != nil {
+                t.Fatalf("failed to list entries: %q", err)
+        }
+        if want := []*Entry{older, e, newer}; !reflect.DeepEqual(list, want) {
+                t.Errorf("failed list got:%+v want:%+v", list, want)
+        }
+        found, err := s.FindByInsertID("eppsoda4")
+        if err != nil {
+                t.Fatalf("failed to find entries: %q", err)
+        }
+        if want := []*Entry{e, newer}; !reflect.DeepEqual(found, want) {
+                t.Errorf("failed find got:%+v want:%+v", found, want)
+        }
+        if found, err := s.FindByInsertID("missing"); err != nil || len(found) != 0 {
+                t.Errorf("failed find missing got:%+v err:%v", found, err)
+        }
+}
+
+// memoryObjects are bucket objects kept in memory.
+type memoryObjects map[string][]byte
+
+func (m memoryObjects) write(name string, b []byte) error {
+        m[name] = b
+        return nil
+}
+
+func (m memoryObjects) read(name string) ([]byte, error) {
+        b, ok := m[name]
+        if !ok {
+                return nil, fmt.Errorf("object %q doesn't exist", name)
+        }
+        return b, nil
+}
+
+func (m memoryObjects) list(prefix string) ([]string, error) {
+        names := []string{}
+        for n := range m {
+                if strings.HasPrefix(n, prefix) {
+                        names = append(names, n)
+                }
+        }
+        return names, nil
+}
+
+func TestNewEntry(t *testing.T) {
+        e := NewEntry("folders/760347836977", time.Date(2019, 7, 16, 21, 0, 44, 5, time.UTC))
+        if want := "20190716T210044.000000005Z-folders-760347836977"; e.ID != want {
+                t.Errorf("failed id got:%q want:%q", e.ID, want)
+        }
+}
\ No newline at end of file
@@ -0,0 +1,235 @@
+/*
+Package playbook maps findings to ordered response steps declared in JSON playbooks.
+
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+        https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package playbook
+
+import (
+        "automation/clients"
+        "automation/finding"
+
+        "context"
+        "encoding/json"
+        "fmt"
+        "log"
+        "strings"
+)
+
+// On-failure policies of a step.
+const (
+        // OnFailureAbort stops the playbook and reports the error, it's the default.
+        OnFailureAbort = "abort"
+        // OnFailureContinue logs the error and runs the next step.
+        OnFailureContinue = "continue"
+)
+
+// File is the format playbooks are declared in.
+type File struct {
+        Playbooks []*Playbook `json:"playbooks"`
+}
+
+// Playbook lists the steps taken, in order, for the findings it matches.
+type Playbook struct {
+        // Name identifies the playbook in logs and errors.
+        Name string `json:"name"`
+        // Match selects the findings the playbook responds to.
+        Match Match `json:"match"`
+        // Steps are run in order.
+        Steps []*Step `json:"steps"`
+}
+
+// Match selects findings, every condition set must hold. Lists match if any entry does.
+type Match struct {
+        // Rules are rule names, Security Health Analytics' categories included.
+        Rules []string `json:"rules,omitempty"`
+        // SubRules are sub rule names.
+        SubRules []string `json:"subRules,omitempty"`
+        // MinSeverity is the lowest severity matched, for example "WARNING".
+        MinSeverity string `json:"minSeverity,omitempty"`
+        // MinPriority is the lowest priority matched, for example "HIGH".
+        MinPriority string `json:"minPriority,omitempty"`
+        // Projects are the IDs of the projects the finding must be in.
+        Projects []string `json:"projects,omitempty"`
+        // Folders are the IDs of the folders the finding's project must be within.
+        Folders []string `json:"folders,omitempty"`
+
+        threshold finding.Threshold
+}
+
+// Step is a response step run with its parameters.
+type Step struct {
+        // Action is the name of a registered step, for example "snapshot-disks".
+        Action string `json:"action"`
+        // Params are the step's parameters, see the step for the ones it reads.
+        Params Params `json:"params,omitempty"`
+        // OnFailure is OnFailureAbort or OnFailureContinue.
+        OnFailure string `json:"onFailure,omitempty"`
+}
+
+// Params are a step's parameters keyed by name.
+type Params map[string]json.RawMessage
+
+// Get decodes the parameter into v, it returns false if the parameter isn't set.
+func (p Params) Get(name string, v interface{}) (bool, error) {
+        raw, ok := p[name]
+        if !ok {
+                return false, nil
+        }
+        if err := json.Unmarshal(raw, v); err != nil {
+                return true, fmt.Errorf("invalid parameter %q: %q", name, err)
+        }
+        return true, nil
+}
+
+// Load parses and validates playbooks declared in JSON, every step must be one of the steps.
+func Load(b []byte, steps Steps) ([]*Playbook, error) {
+        var file File
+        if err := json.Unmarshal(b, &file); err != nil {
+                return nil, fmt.Errorf("failed to parse playbooks: %q", err)
+        }
+        var errs []string
+        names := map[string]bool{}
+        for i, p := range file.Playbooks {
+                if p.Name == "" {
+                        p.Name = fmt.Sprintf("playbook %d", i)
+                }
+                if names[p.Name] {
+                        errs = append(errs, fmt.Sprintf("%s: duplicate name", p.Name))
+                }
+                names[p.Name] = true
+                for _, err := range p.validate(steps) {
+                        errs = append(errs, p.Name+": "+err)
+                }
+        }
+        if len(errs) > 0 {
+                return nil, fmt.Errorf("invalid playbooks: %s", strings.Join(errs, "; "))
+        }
+        return file.Playbooks, nil
+}
+
+// validate returns the playbook's problems and parses its thresholds.
+func (p *Playbook) validate(steps Steps) []string {
+        var errs []string
+        if p.Match.MinSeverity != "" {
+                s, err := finding.ParseSeverity(p.Match.MinSeverity)
+                if err != nil {
+                        errs = append(errs, err.Error())
+                }
+                p.Match.threshold.Severity = s
+        }
+        if p.Match.MinPriority != "" {
+                pr, err := finding.ParsePriority(p.Match.MinPriority)
+                if err != nil {
+                        errs = append(errs, err.Error())
+                }
+                p.Match.threshold.Priority = pr
+        }
+        if len(p.Steps) == 0 {
+                errs = append(errs, "no steps")
+        }
+        for i, s := range p.Steps {
+                if steps[s.Action] == nil {
+                        errs = append(errs, fmt.Sprintf("step %d has unknown action %q", i, s.Action))
+                }
+                if s.OnFailure == "" {
+                        s.OnFailure = OnFailureAbort
+                }
+                if s.OnFailure != OnFailureAbort && s.OnFailure != OnFailureContinue {
+                        errs = append(errs, fmt.Sprintf("step %d has unknown onFailure %q", i, s.OnFailure))
+                }
+        }
+        return errs
+}
+
+// Engine runs the playbooks matching a finding.
+type Engine struct {
+        c     clients.ClientInt
+        steps Steps
+}
+
+// NewEngine returns an engine running playbooks with the clients and the steps they were
+// loaded with.
+func NewEngine(c clients.ClientInt, steps Steps) *Engine {
+        return &Engine{c: c, steps: steps}
+}
+
+// Matches returns true if the finding meets every condition of the playbook's match.
+func (e *Engine) Matches(p *Playbook, f *finding.Finding) (bool, error) {
+        return p.Match.matches(e.c, f)
+}
+
+// RunPlaybook runs the playbook's steps for the finding, callers check it Matches first.
+//
+// A step failing with OnFailureAbort stops the playbook and its error is returned.
+func (e *Engine) RunPlaybook(ctx context.Context, p *Playbook, f *finding.Finding) error {
+        for i, s := range p.Steps {
+                step := e.steps[s.Action]
+                if step == nil {
+                        return fmt.Errorf("step %d has unknown action %q", i, s.Action)
+                }
+                err := step(ctx, e.c, f, s.Params)
+                if err == nil {
+                        continue
+                }
+                if s.OnFailure == OnFailureContinue {
+                        log.Printf("playbook %s step %d %s failed, continuing: %q", p.Name, i, s.Action, err)
+                        continue
+                }
+                return fmt.Errorf("step %d %s failed: %q", i, s.Action, err)
+        }
+        return nil
+}
+
+// matches returns true if the finding meets every condition set.
+func (m Match) matches(c clients.ClientInt, f *finding.Finding) (bool, error) {
+        if len(m.Rules) > 0 && !contains(m.Rules, f.RuleName()) {
+                return false, nil
+        }
+        if len(m.SubRules) > 0 && !contains(m.SubRules, f.SubRuleName()) {
+                return false, nil
+        }
+        if !f.Meets(m.threshold) {
+                return false, nil
+        }
+        if len(m.Projects) > 0 && !contains(m.Projects, f.ProjectID()) {
+                return false, nil
+        }
+        if len(m.Folders) == 0 {
+                return true, nil
+        }
+        ancestors, err := c.GetProjectAncestry(f.ProjectID())
+        if err != nil {
+                return false, fmt.Errorf("failed to get project ancestry: %q", err)
+        }
+        for _, a := range ancestors {
+                for _, folderID := range m.Folders {
+                        if a == "folders/"+folderID {
+                                return true, nil
+                        }
+                }
+        }
+        return false, nil
+}
+
+// contains returns true if the value is in the list.
+func contains(list []string, v string) bool {
+        for _, s := range list {
+                if s == v {
+                        return true
+                }
+        }
+        return false
+}
\ No newline at end of file
@@ -0,0 +1,230 @@
+/*
+Package playbook maps findings to ordered response steps declared in JSON playbooks.
+
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+        https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package playbook
+
+import (
+        "automation/clients"
+        "automation/finding"
+        "context"
+        "errors"
+        "reflect"
+        "strings"
+        "testing"
+
+        "cloud.google.com/go/pubsub"
+)
+
+var (
+        badIPFinding = pubsub.Message{Data: []byte(`{
+                "insertId": "eppsoda4",
+                "jsonPayload": {"detectionCategory": {"ruleName": "bad_ip"},
+                "properties": {
+                        "location": "test-zone",
+                        "project_id": "test-project",
+                        "sourceInstance": "/projects/test-project/zones/test-zone/instances/instance1",
+                        "ip": ["8.8.8.8"]
+                }},
+                "logName": "projects/test-project/logs/threatdetection.googleapis.com%2Fdetection"
+        }`)}
+
+        openFirewallFinding = pubsub.Message{Data: []byte(`{
+                "notificationConfigName": "organizations/154584661726/notificationConfigs/health-findings",
+                "finding": {
+                        "name": "organizations/154584661726/sources/1986930501971458034/findings/f2",
+                        "resourceName": "//compute.googleapis.com/projects/test-project/global/firewalls/open-rule",
+                        "state": "ACTIVE",
+                        "category": "OPEN_FIREWALL",
+                        "sourceProperties": {"ProjectId": "test-project", "SeverityLevel": "High"}
+                }
+        }`)}
+)
+
+var (
+        // failed counts the runs of the "fail" step.
+        failed int
+        // counted counts the runs of the "count" step.
+        counted int
+)
+
+// testSteps returns the built-in steps with the "fail" and "count" steps.
+func testSteps() Steps {
+        steps := BuiltinSteps()
+        steps["fail"] = func(context.Context, clients.ClientInt, *finding.Finding, Params) error {
+                failed++
+                return errors.New("step failed")
+        }
+        steps["count"] = func(context.Context, clients.ClientInt, *finding.Finding, Params) error {
+                counted++
+                return nil
+        }
+        return steps
+}
+
+func TestLoad(t *testing.T) {
+        tests := []struct {
+                name     string
+                contents string
+                errMatch string
+        }{
+                {
+                        name: "valid",
+                        contents: `{"playbooks": [{
+                                "name": "snapshot",
+                                "match": {"rules": ["bad_ip"], "minSeverity": "WARNING", "folders": ["760347836977"]},
+                                "steps": [{"action": "count", "params": {"domains": ["gmail.com"]}, "onFailure": "continue"}]
+                        }]}`,
+                },
+                {
+                        name:     "malformed",
+                        contents: `{"playbooks": [`,
+                        errMatch: "failed to parse playbooks",
+                },
+                {
+                        name: "invalid",
+                        contents: `{"playbooks": [
+                                {"name": "a", "match": {"minSeverity": "LOUD"}, "steps": [{"action": "reboot"}, {"action": "disable-firewall", "onFailure": "retry"}]},
+                                {"name": "a"}
+                        ]}`,
+                        errMatch: `invalid playbooks: a: unknown severity "LOUD"; a: step 0 has unknown action "reboot"; a: step 1 has unknown onFailure "retry"; a: duplicate name; a: no steps`,
+                },
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        _, err := Load([]byte(tt.contents), testSteps())
+                        if tt.errMatch == "" && err != nil {
+                                t.Fatalf("%s failed: %q", tt.name, err)
+                        }
+                        if tt.errMatch != "" && (err == nil || !strings.Contains(err.Error(), tt.errMatch)) {
+                                t.Errorf("%s failed got:%v want:%q", tt.name, err, tt.errMatch)
+                        }
+                })
+        }
+}
+
+func TestRunPlaybook(t *testing.T) {
+        const playbooks = `{"playbooks": [
+                {"name": "snapshot", "match": {"rules": ["bad_ip"], "projects": ["test-project"]}, "steps": [{"action": "count"}]},
+                {"name": "severe", "match": {"minSeverity": "WARNING"}, "steps": [{"action": "count"}]},
+                {"name": "production", "match": {"folders": ["760347836977"]}, "steps": [{"action": "disable-firewall"}]},
+                {"name": "other folder", "match": {"folders": ["123"]}, "steps": [{"action": "disable-firewall"}]},
+                {"name": "best effort", "match": {"rules": ["OPEN_FIREWALL"]}, "steps": [{"action": "fail", "onFailure": "continue"}, {"action": "disable-firewall"}]}
+        ]}`
+        tests := []struct {
+                name            string
+                message         pubsub.Message
+                expectedRan     []string
+                expectedCounted int
+                expectedRules   []string
+        }{
+                {
+                        name:            "counts bad ip",
+                        message:         badIPFinding,
+                        expectedRan:     []string{"snapshot", "production"},
+                        expectedCounted: 1,
+                },
+                {
+                        name:          "closes open firewall",
+                        message:       openFirewallFinding,
+                        expectedRan:   []string{"production", "best effort"},
+                        expectedRules: []string{"open-rule"},
+                },
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        pbs, err := Load([]byte(playbooks), testSteps())
+                        if err != nil {
+                                t.Fatalf("%s failed to load: %q", tt.name, err)
+                        }
+                        f := finding.NewFinding()
+                        if err := f.ReadFinding(&tt.message); err != nil {
+                                t.Fatalf("%s failed to read finding: %q", tt.name, err)
+                        }
+                        mock := clients.NewMockClients()
+                        mock.AddGetProjectAncestryFake([]string{"projects/test-project", "folders/760347836977", "organizations/154584661726"})
+
+                        counted = 0
+                        e := NewEngine(mock, testSteps())
+                        var ran []string
+                        for _, p := range pbs {
+                                matched, err := e.Matches(p, f)
+                                if err != nil {
+                                        t.Fatalf("%s failed to match: %q", tt.name, err)
+                                }
+                                if !matched {
+                                        continue
+                                }
+                                if err := e.RunPlaybook(context.Background(), p, f); err != nil {
+                                        t.Fatalf("%s failed: %q", tt.name, err)
+                                }
+                                ran = append(ran, p.Name)
+                        }
+                        if !reflect.DeepEqual(ran, tt.expectedRan) {
+                                t.Errorf("%s failed got:%q want:%q", tt.name, ran, tt.expectedRan)
+                        }
+                        if counted != tt.expectedCounted {
+                                t.Errorf("%s failed counted got:%d want:%d", tt.name, counted, tt.expectedCounted)
+                        }
+                        var rules []string
+                        for name := range mock.SavedFirewallRules {
+                                rules = append(rules, name)
+                        }
+                        if !reflect.DeepEqual(rules, tt.expectedRules) {
+                                t.Errorf("%s failed rules got:%q want:%q", tt.name, rules, tt.expectedRules)
+                        }
+                })
+        }
+}
+
+func TestRunPlaybookAbort(t *testing.T) {
+        pbs, err := Load([]byte(`{"playbooks": [
+                {"name": "aborts", "steps": [{"action": "fail"}, {"action": "disable-firewall"}]}
+        ]}`), testSteps())
+        if err != nil {
+                t.Fatalf("failed to load: %q", err)
+        }
+        f := finding.NewFinding()
+        if err := f.ReadFinding(&openFirewallFinding); err != nil {
+                t.Fatalf("failed to read finding: %q", err)
+        }
+        mock := clients.NewMockClients()
+
+        failed = 0
+        err = NewEngine(mock, testSteps()).RunPlaybook(context.Background(), pbs[0], f)
+        exp := `step 0 fail failed: "step failed"`
+        if err == nil || err.Error() != exp {
+                t.Errorf("failed got:%v want:%q", err, exp)
+        }
+        if failed != 1 || len(mock.SavedFirewallRules) != 0 {
+                t.Errorf("failed got %d failures and %d rules disabled, want 1 and 0", failed, len(mock.SavedFirewallRules))
+        }
+}
+
+func TestRunPlaybookUnknownStep(t *testing.T) {
+        pbs, err := Load([]byte(`{"playbooks": [{"name": "count", "steps": [{"action": "count"}]}]}`), testSteps())
+        if err != nil {
+                t.Fatalf("failed to load: %q", err)
+        }
+        f := finding.NewFinding()
+        if err := f.ReadFinding(&badIPFinding); err != nil {
+                t.Fatalf("failed to read finding: %q", err)
+        }
+        err = NewEngine(clients.NewMockClients(), BuiltinSteps()).RunPlaybook(context.Background(), pbs[0], f)
+        if exp := `step 0 has unknown action "count"`; err == nil || err.Error() != exp {
+                t.Errorf("failed got:%v want:%q", err, exp)
+        }
+}
\ No newline at end of file
@@ -0,0 +1,58 @@
+/*
+Package playbook maps findings to ordered response steps declared in JSON playbooks.
+
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+        https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package playbook
+
+import (
+        "automation/clients"
+        "automation/finding"
+        "automation/firewall"
+
+        "context"
+)
+
+// StepFunc runs a response step for the finding with the step's parameters.
+type StepFunc func(ctx context.Context, c clients.ClientInt, f *finding.Finding, p Params) error
+
+// Steps are the steps playbooks can take keyed by action name.
+//
+// Playbooks are loaded and run with the same steps, steps responding like an action, such as
+// "snapshot-disks", "remove-members" and "remove-domains", are added by actions.PlaybookSteps.
+type Steps map[string]StepFunc
+
+// BuiltinSteps returns the steps this package provides, "disable-firewall".
+func BuiltinSteps() Steps {
+        return Steps{"disable-firewall": disableFirewall}
+}
+
+// disableFirewall disables the firewall rules the finding affects.
+func disableFirewall(_ context.Context, c clients.ClientInt, f *finding.Finding, _ Params) error {
+        fw := firewall.NewFirewall(c)
+        for _, r := range f.AffectedResources() {
+                if r.Type != "firewalls" {
+                        continue
+                }
+                projectID := r.Project
+                if projectID == "" {
+                        projectID = f.ProjectID()
+                }
+                if _, err := fw.DisableFirewallRule(projectID, r.Name); err != nil {
+                        return err
+                }
+        }
+        return nil
+}
\ No newline at end of file
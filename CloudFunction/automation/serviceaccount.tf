@@ -31,6 +31,16 @@ resource "google_project_iam_binding" "gce-snapshot-bind" {
   members = ["serviceAccount:${google_service_account.automation-service-account.email}"]
 }
 
+// Role "compute.securityAdmin" required to create the firewall rules isolating quarantined
+// instances and blocking indicator IPs. This is used in actions/quarantine_instance.go, which
+// also relies on "compute.instanceAdmin" to tag the instances, and in
+// actions/block_indicator_ips.go. This binding can be removed if the actions are not being used.
+resource "google_project_iam_binding" "gce-firewall-bind" {
+  project = "${var.automationProject}"
+  role    = "roles/compute.securityAdmin"
+  members = ["serviceAccount:${google_service_account.automation-service-account.email}"]
+}
+
 resource "google_folder_iam_binding" "cloudfunction-folder-bind" {
   folder  = "folders/${var.userFolder}"
   role    = "roles/resourcemanager.folderAdmin"
@@ -1,7 +1,7 @@
 resource "google_logging_project_sink" "sink" {
   name                   = "sink-threat-findings"
   destination            = "pubsub.googleapis.com/projects/${var.automationProject}/topics/${local.findings-topic}"
-  filter                 = "resource.type = threat_detector"
+  filter                 = "resource.type = threat_detector OR (logName:\"cloudaudit.googleapis.com%2Factivity\" AND protoPayload.methodName = \"SetIamPolicy\")"
   unique_writer_identity = true
   project                = "${var.threatfindingsProject}"
 }
@@ -0,0 +1,184 @@
+/*
+Package user contains methods to change user resources.
+
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+        https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package user
+
+import (
+        "errors"
+        "strings"
+)
+
+const (
+        // deletedPrefix marks members whose account was deleted, the original member follows it.
+        deletedPrefix = "deleted:"
+        // serviceAgentDomain is the domain of Google managed service accounts, including the
+        // service agents Google services act through.
+        serviceAgentDomain = "gserviceaccount.com"
+)
+
+// ErrNoDomains is returned by AllowDomains when no domain is approved, every member would be
+// outside of the allowlist.
+var ErrNoDomains = errors.New("no approved domains")
+
+// Matcher selects the policy members to remove.
+//
+// Members are in the form of IAM policy members such as "user:tom@gmail.com",
+// "group:admins@example.com", "serviceAccount:sa@p.iam.gserviceaccount.com" or
+// "domain:example.com". A deleted member, "deleted:user:tom@gmail.com?uid=123" for
+// example, is matched as the member it was before being deleted.
+type Matcher struct {
+        // members contains the members matched exactly.
+        members map[string]bool
+        // domains contains the lower case domains matched.
+        domains []string
+        // subdomains matches the subdomains of domains as well.
+        subdomains bool
+        // allowlist matches the members outside of domains instead.
+        allowlist bool
+        // types limits matching to members of these types, empty for any type.
+        types map[string]bool
+}
+
+// MatchMembers returns a matcher of the given members.
+func MatchMembers(members ...string) *Matcher {
+        m := &Matcher{members: map[string]bool{}}
+        for _, member := range members {
+                m.members[parseMember(member).String()] = true
+        }
+        return m
+}
+
+// MatchDomains returns a matcher of the members belonging to the given domains.
+func MatchDomains(domains ...string) *Matcher {
+        m := &Matcher{}
+        for _, d := range domains {
+                if d = strings.ToLower(strings.TrimSpace(d)); d != "" {
+                        m.domains = append(m.domains, d)
+                }
+        }
+        return m
+}
+
+// AllowDomains returns a matcher of every member outside of the given approved domains.
+//
+// Members without a domain, such as allUsers and allAuthenticatedUsers, are never matched,
+// public access is left to the actions closing it. Google managed service accounts in
+// gserviceaccount.com are only matched if WithTypes explicitly includes "serviceAccount",
+// removing the service agents of enabled services would break them.
+func AllowDomains(domains ...string) (*Matcher, error) {
+        m := MatchDomains(domains...)
+        if len(m.domains) == 0 {
+                return nil, ErrNoDomains
+        }
+        m.allowlist = true
+        return m, nil
+}
+
+// WithSubdomains matches the subdomains of the matcher's domains as well, for example
+// "user:tom@mail.example.com" for "example.com".
+func (m *Matcher) WithSubdomains() *Matcher {
+        m.subdomains = true
+        return m
+}
+
+// WithTypes limits the matcher to members of the given types, for example "user" and "group".
+func (m *Matcher) WithTypes(types ...string) *Matcher {
+        m.types = map[string]bool{}
+        for _, t := range types {
+                m.types[t] = true
+        }
+        return m
+}
+
+// Match returns true if the member is matched.
+func (m *Matcher) Match(member string) bool {
+        pm := parseMember(member)
+        if len(m.types) > 0 && !m.types[pm.kind] {
+                return false
+        }
+        if m.members != nil {
+                return m.members[pm.String()]
+        }
+        in := m.inDomains(pm.domain)
+        if m.allowlist {
+                return pm.domain != "" && !in && (!isServiceAgent(pm) || m.types["serviceAccount"])
+        }
+        return in
+}
+
+// isServiceAgent returns true if the member is a Google managed service account.
+func isServiceAgent(pm member) bool {
+        return pm.kind == "serviceAccount" && (pm.domain == serviceAgentDomain || strings.HasSuffix(pm.domain, "."+serviceAgentDomain))
+}
+
+// inDomains returns true if the domain is one of the matcher's domains.
+func (m *Matcher) inDomains(domain string) bool {
+        if domain == "" {
+                return false
+        }
+        for _, d := range m.domains {
+                if domain == d || m.subdomains && strings.HasSuffix(domain, "."+d) {
+                        return true
+                }
+        }
+        return false
+}
+
+// member is a policy member split into its parts.
+type member struct {
+        // kind is the member type such as "user", empty if the member has none.
+        kind string
+        // id is the member without its type, for example "tom@gmail.com".
+        id string
+        // domain is the lower case domain of the member, if any.
+        domain string
+}
+
+// parseMember splits a policy member, deleted members are parsed as the original member.
+func parseMember(s string) member {
+        s = strings.TrimPrefix(s, deletedPrefix)
+        var pm member
+        if i := strings.Index(s, ":"); i != -1 {
+                pm.kind, pm.id = s[:i], s[i+1:]
+        } else if strings.Contains(s, "@") {
+                pm.id = s
+        } else {
+                pm.kind = s
+        }
+        // Deleted members carry the unique ID of the deleted account.
+        if i := strings.Index(pm.id, "?uid="); i != -1 {
+                pm.id = pm.id[:i]
+        }
+        switch {
+        case pm.kind == "domain":
+                pm.domain = strings.ToLower(pm.id)
+        case strings.Contains(pm.id, "@"):
+                pm.domain = strings.ToLower(pm.id[strings.LastIndex(pm.id, "@")+1:])
+        }
+        return pm
+}
+
+// String returns the member as it appears in a policy, without any deleted marker.
+func (pm member) String() string {
+        switch {
+        case pm.kind == "":
+                return pm.id
+        case pm.id == "":
+                return pm.kind
+        }
+        return pm.kind + ":" + pm.id
+}
\ No newline at end of file
@@ -0,0 +1,83 @@
+/*
+Package user contains methods to change user resources.
+
+Copyright 2019 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+        https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package user
+
+import "testing"
+
+func TestMatcher(t *testing.T) {
+        tests := []struct {
+                name    string
+                matcher *Matcher
+                member  string
+                exp     bool
+        }{
+                {"exact member", MatchMembers("user:tom+test@gmail.com"), "user:tom+test@gmail.com", true},
+                {"exact member doesn't treat plus as regex", MatchMembers("user:tom+test@gmail.com"), "user:tommtest@gmail.com", false},
+                {"exact member of another type", MatchMembers("user:tom@gmail.com"), "group:tom@gmail.com", false},
+                {"exact deleted member", MatchMembers("user:tom@gmail.com"), "deleted:user:tom@gmail.com?uid=123456789", true},
+                {"no members", MatchMembers(), "user:tom@gmail.com", false},
+                {"domain user", MatchDomains("gmail.com"), "user:tom@gmail.com", true},
+                {"domain is case insensitive", MatchDomains("Gmail.com"), "user:Tom@GMAIL.com", true},
+                {"domain group", MatchDomains("gmail.com"), "group:team@gmail.com", true},
+                {"domain member", MatchDomains("gmail.com"), "domain:gmail.com", true},
+                {"domain without type", MatchDomains("gmail.com"), "tom@gmail.com", true},
+                {"domain suffix isn't a subdomain", MatchDomains("gmail.com"), "user:tim@thegmail.com", false},
+                {"subdomain not matched by default", MatchDomains("example.com"), "user:tom@mail.example.com", false},
+                {"subdomain", MatchDomains("example.com").WithSubdomains(), "user:tom@mail.example.com", true},
+                {"subdomain suffix", MatchDomains("example.com").WithSubdomains(), "user:tom@badexample.com", false},
+                {"deleted domain member", MatchDomains("gmail.com"), "deleted:user:tom@gmail.com?uid=123456789", true},
+                {"public member has no domain", MatchDomains("gmail.com"), "allUsers", false},
+                {"type filter matches", MatchDomains("gmail.com").WithTypes("user"), "user:tom@gmail.com", true},
+                {"type filter excludes", MatchDomains("gmail.com").WithTypes("user"), "group:team@gmail.com", false},
+                {"type filter of deleted member", MatchDomains("gmail.com").WithTypes("user"), "deleted:user:tom@gmail.com?uid=1", true},
+                {"allowlist approved", allow(t, "google.com"), "user:tom@google.com", false},
+                {"allowlist outside", allow(t, "google.com"), "user:tom@gmail.com", true},
+                {"allowlist subdomain", allow(t, "google.com").WithSubdomains(), "serviceAccount:sa@dev.google.com", false},
+                {"allowlist public member", allow(t, "google.com"), "allAuthenticatedUsers", false},
+                {"allowlist all users", allow(t, "google.com"), "allUsers", false},
+                {"allowlist service agent", allow(t, "google.com"), "serviceAccount:service-1@compute-system.iam.gserviceaccount.com", false},
+                {"allowlist deleted service agent", allow(t, "google.com"), "deleted:serviceAccount:sa@p.iam.gserviceaccount.com?uid=1", false},
+                {"allowlist service agent of type", allow(t, "google.com").WithTypes("serviceAccount"), "serviceAccount:sa@p.iam.gserviceaccount.com", true},
+                {"allowlist type filter", allow(t, "google.com").WithTypes("user", "group"), "serviceAccount:sa@p.iam.gserviceaccount.com", false},
+                {"allowlist outside service account", allow(t, "google.com"), "serviceAccount:sa@evil.com", true},
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        if got := tt.matcher.Match(tt.member); got != tt.exp {
+                                t.Errorf("%s failed got:%t want:%t", tt.name, got, tt.exp)
+                        }
+                })
+        }
+}
+
+// allow returns the allowlist matcher of the domains.
+func allow(t *testing.T, domains ...string) *Matcher {
+        m, err := AllowDomains(domains...)
+        if err != nil {
+                t.Fatalf("AllowDomains(%q) failed: %q", domains, err)
+        }
+        return m
+}
+
+func TestAllowDomainsEmpty(t *testing.T) {
+        for _, domains := range [][]string{nil, {""}, {" "}} {
+                if _, err := AllowDomains(domains...); err != ErrNoDomains {
+                        t.Errorf("AllowDomains(%q) failed got:%q want:%q", domains, err, ErrNoDomains)
+                }
+        }
+}
\ No newline at end of file
@@ -19,10 +19,14 @@ package user
 
 import (
         "automation/clients"
+        "automation/journal"
 
+        "encoding/json"
+        "errors"
         "fmt"
-        "regexp"
+        "sort"
         "strings"
+        "time"
 
         stg "cloud.google.com/go/storage"
         crm "google.golang.org/api/cloudresourcemanager/v1"
@@ -33,66 +37,437 @@ type client interface {
         clients.StorageInt
 }
 
+const (
+        // maxAttempts is how many times a policy change is tried when the policy changes concurrently.
+        maxAttempts = 5
+        // initialBackoff is the wait before retrying a conflicting change, doubled on each retry.
+        initialBackoff = 500 * time.Millisecond
+)
+
 // User struct
 type User struct {
         c client
+        // sleep waits between conflicting attempts, replaced in tests.
+        sleep func(time.Duration)
+        // now returns the time policy changes are journaled at, replaced in tests.
+        now func() time.Time
+        // journal records policy changes before they're set, nil to not record them.
+        journal journal.Store
+        // insertID is the insert ID of the finding the changes are made for.
+        insertID string
 }
 
 // NewUser returns a new instance of Useu.
 func NewUser(c client) *User {
-        return &User{c: c}
+        return &User{c: c, sleep: time.Sleep, now: time.Now}
+}
+
+// Journaled returns a copy of the user that writes a journal entry to the store before
+// every IAM policy change, recording the finding's insertID with it.
+func (u *User) Journaled(s journal.Store, insertID string) *User {
+        j := *u
+        j.journal = s
+        j.insertID = insertID
+        return &j
+}
+
+// Result is the outcome of an IAM policy change.
+type Result struct {
+        // Policy is the policy as it was set.
+        Policy *crm.Policy
+        // Removed contains the members removed from each role, it's empty if nothing changed.
+        Removed Diff
+        // Added contains the members added back to each role by Restore.
+        Added Diff
+        // Attempts is how many times the policy was read, modified and set. It's more than
+        // one when the policy was changed concurrently and the change was applied again.
+        Attempts int
+        // JournalID is the ID of the journal entry recording the policy before the change,
+        // empty when the change isn't journaled or nothing changed.
+        JournalID string
+}
+
+// Diff maps a role to the members removed from or added to it, sorted.
+//
+// Members removed from a conditional binding are listed under the binding's role.
+type Diff map[string][]string
+
+// String returns the diff as "role: member, member; role: member" with roles sorted.
+func (d Diff) String() string {
+        roles := make([]string, 0, len(d))
+        for r := range d {
+                roles = append(roles, r)
+        }
+        sort.Strings(roles)
+        parts := []string{}
+        for _, r := range roles {
+                parts = append(parts, r+": "+strings.Join(d[r], ", "))
+        }
+        return strings.Join(parts, "; ")
+}
+
+// Grant is a role granted to a member in an IAM policy.
+type Grant struct {
+        // Member is the policy member, for example "user:tom@gmail.com".
+        Member string
+        // Role is the granted role, empty to match every role of the member.
+        Role string
+        // Condition is the expression of a conditional binding. When set only the member's
+        // conditional grant is removed, otherwise the member is removed from the role's
+        // unconditional and conditional bindings alike.
+        Condition string
+}
+
+// policyResource gets and sets the IAM policy of a project, folder or organization.
+type policyResource struct {
+        // kind is the resource level used in errors, for example "folder".
+        kind string
+        // name is the resource name, for example "folders/123".
+        name string
+        get  func() (*crm.Policy, error)
+        set  func(*crm.Policy) (*crm.Policy, error)
+}
+
+// project returns the policy resource of the project.
+func (u *User) project(projectID string) policyResource {
+        return policyResource{
+                kind: "project",
+                name: "projects/" + projectID,
+                get:  func() (*crm.Policy, error) { return u.c.GetPolicyProject(projectID) },
+                set:  func(p *crm.Policy) (*crm.Policy, error) { return u.c.SetPolicyProject(projectID, p) },
+        }
+}
+
+// folder returns the policy resource of the folder.
+func (u *User) folder(folderID string) policyResource {
+        return policyResource{
+                kind: "folder",
+                name: "folders/" + folderID,
+                get:  func() (*crm.Policy, error) { return u.c.GetPolicyFolder(folderID) },
+                set:  func(p *crm.Policy) (*crm.Policy, error) { return u.c.SetPolicyFolder(folderID, p) },
+        }
+}
+
+// organization returns the policy resource of the organization.
+func (u *User) organization(organizationID string) policyResource {
+        return policyResource{
+                kind: "organization",
+                name: "organizations/" + organizationID,
+                get:  func() (*crm.Policy, error) { return u.c.GetPolicyOrganization(organizationID) },
+                set:  func(p *crm.Policy) (*crm.Policy, error) { return u.c.SetPolicyOrganization(organizationID, p) },
+        }
+}
+
+// resource returns the policy resource with the name, for example "projects/p".
+func (u *User) resource(name string) (policyResource, error) {
+        parts := strings.Split(name, "/")
+        if len(parts) != 2 || parts[1] == "" {
+                return policyResource{}, fmt.Errorf("invalid resource name %q", name)
+        }
+        switch parts[0] {
+        case "projects":
+                return u.project(parts[1]), nil
+        case "folders":
+                return u.folder(parts[1]), nil
+        case "organizations":
+                return u.organization(parts[1]), nil
+        }
+        return policyResource{}, fmt.Errorf("unsupported resource %q", name)
 }
 
 // RemoveDomainsProject removes all members from the given resource that end with the disallowed domains.
-func (u *User) RemoveDomainsProject(projectID string, disallowedDomains []string) (*crm.Policy, error) {
-        domains := strings.Replace(strings.Join(disallowedDomains, "|"), ".", `\.`, -1)
-        regex := regexp.MustCompile(fmt.Sprintf(`@(%s)$`, domains))
+func (u *User) RemoveDomainsProject(projectID string, disallowedDomains []string) (*Result, error) {
+        return u.removeDomains(u.project(projectID), disallowedDomains)
+}
 
-        resp, err := u.c.GetPolicyProject(projectID)
-        if err != nil {
-                return nil, fmt.Errorf("failed to get project policy: %q", err)
+// RemoveDomainsFolder removes all members from the given folder that end with the disallowed domains.
+func (u *User) RemoveDomainsFolder(folderID string, disallowedDomains []string) (*Result, error) {
+        return u.removeDomains(u.folder(folderID), disallowedDomains)
+}
+
+// RemoveDomainsOrganization removes all members from the given organization that end with the disallowed domains.
+func (u *User) RemoveDomainsOrganization(organizationID string, disallowedDomains []string) (*Result, error) {
+        return u.removeDomains(u.organization(organizationID), disallowedDomains)
+}
+
+// RemoveMatchingProject removes the members the matcher matches from every role of the project.
+func (u *User) RemoveMatchingProject(projectID string, m *Matcher) (*Result, error) {
+        return u.removeMatching(u.project(projectID), m)
+}
+
+// RemoveMatchingFolder removes the members the matcher matches from every role of the folder.
+func (u *User) RemoveMatchingFolder(folderID string, m *Matcher) (*Result, error) {
+        return u.removeMatching(u.folder(folderID), m)
+}
+
+// RemoveMatchingOrganization removes the members the matcher matches from every role of the organization.
+func (u *User) RemoveMatchingOrganization(organizationID string, m *Matcher) (*Result, error) {
+        return u.removeMatching(u.organization(organizationID), m)
+}
+
+// RemoveMembersProject removes the given members from every role.
+func (u *User) RemoveMembersProject(projectID string, disallowedUserEmails []string) (*Result, error) {
+        return u.RemoveMatchingProject(projectID, MatchMembers(disallowedUserEmails...))
+}
+
+// RemoveGrantsProject removes the members from only the roles they're granted in grants.
+//
+// A grant without a role removes its member from every role, as RemoveMembersProject does.
+func (u *User) RemoveGrantsProject(projectID string, grants []Grant) (*Result, error) {
+        return u.removeGrants(u.project(projectID), grants)
+}
+
+// RemoveGrantsFolder removes the members from only the roles they're granted in grants on the folder.
+func (u *User) RemoveGrantsFolder(folderID string, grants []Grant) (*Result, error) {
+        return u.removeGrants(u.folder(folderID), grants)
+}
+
+// RemoveGrantsOrganization removes the members from only the roles they're granted in grants on the organization.
+func (u *User) RemoveGrantsOrganization(organizationID string, grants []Grant) (*Result, error) {
+        return u.removeGrants(u.organization(organizationID), grants)
+}
+
+// InDomains returns true if the member belongs to one of the domains.
+func InDomains(member string, domains []string) bool {
+        return MatchDomains(domains...).Match(member)
+}
+
+// removeDomains removes the members of the disallowed domains from the resource's policy.
+func (u *User) removeDomains(r policyResource, disallowedDomains []string) (*Result, error) {
+        return u.removeMatching(r, MatchDomains(disallowedDomains...))
+}
+
+// removeMatching removes the members the matcher matches from the resource's policy.
+func (u *User) removeMatching(r policyResource, m *Matcher) (*Result, error) {
+        return u.remove(r, func(_ *crm.Binding, member string) bool {
+                return m.Match(member)
+        })
+}
+
+// removeGrants removes the grants from the resource's policy.
+func (u *User) removeGrants(r policyResource, grants []Grant) (*Result, error) {
+        granted := map[Grant]bool{}
+        for _, g := range grants {
+                granted[g] = true
         }
+        return u.remove(r, func(b *crm.Binding, member string) bool {
+                if granted[Grant{Member: member}] || granted[Grant{Member: member, Role: b.Role}] {
+                        return true
+                }
+                return b.Condition != nil && granted[Grant{Member: member, Role: b.Role, Condition: b.Condition.Expression}]
+        })
+}
+
+// remove removes the matching members from the resource's policy.
+func (u *User) remove(r policyResource, match func(b *crm.Binding, member string) bool) (*Result, error) {
+        return u.change(r, func(p *crm.Policy) (Diff, Diff) {
+                _, removed := u.removeMembersFromPolicy(match, p)
+                return removed, Diff{}
+        })
+}
+
+// change modifies the resource's policy and sets it, modify returns the members it
+// removed and added.
+//
+// The policy is set with the etag it was read with, if it changed in between the set
+// conflicts and the change is applied again to a fresh copy after backing off. The policy
+// isn't set when nothing changed. When journaled, the policy as read is recorded before
+// every set, the entry is overwritten with the latest read on retries.
+func (u *User) change(r policyResource, modify func(p *crm.Policy) (removed, added Diff)) (*Result, error) {
+        var entry *journal.Entry
+        if u.journal != nil {
+                entry = journal.NewEntry(r.name, u.now())
+                entry.InsertID = u.insertID
+        }
+        backoff := initialBackoff
+        for attempt := 1; ; attempt++ {
+                p, err := r.get()
+                if err != nil {
+                        return nil, fmt.Errorf("failed to get %s policy: %q", r.kind, err)
+                }
+                read, err := copyPolicy(p)
+                if err != nil {
+                        return nil, err
+                }
+
+                // Removing the last member of a conditional binding drops its condition, the
+                // policy still needs the version it was read with to be written.
+                version := policyVersion(p)
+                removed, added := modify(p)
+                if len(removed) == 0 && len(added) == 0 {
+                        return &Result{Policy: p, Removed: removed, Added: added, Attempts: attempt}, nil
+                }
+                if p.Version = policyVersion(p); p.Version < version {
+                        p.Version = version
+                }
 
-        p := u.removeMembersFromPolicy(regex, resp)
+                if entry != nil {
+                        entry.Policy, entry.Removed, entry.Added = read, removed, added
+                        if err := u.journal.Put(entry); err != nil {
+                                return nil, fmt.Errorf("failed to journal %s policy: %q", r.kind, err)
+                        }
+                }
+                setp, err := r.set(p)
+                if err == nil {
+                        res := &Result{Policy: setp, Removed: removed, Added: added, Attempts: attempt}
+                        if entry != nil {
+                                res.JournalID = entry.ID
+                        }
+                        return res, nil
+                }
+                if !errors.Is(err, clients.ErrConflict) {
+                        return nil, fmt.Errorf("failed to set %s policy: %q", r.kind, err)
+                }
+                if attempt == maxAttempts {
+                        return nil, fmt.Errorf("failed to set %s policy after %d attempts: %q", r.kind, attempt, err)
+                }
+                u.sleep(backoff)
+                backoff *= 2
+        }
+}
 
-        setp, err := u.c.SetPolicyProject(projectID, p)
+// Restore re-applies the members a journal entry records as removed to the current policy
+// of the entry's resource.
+//
+// Members are added back to the role and condition they were granted with before the change.
+// Anything changed in the policy since is kept, members already granted are left as they are.
+func (u *User) Restore(e *journal.Entry) (*Result, error) {
+        r, err := u.resource(e.Resource)
         if err != nil {
-                return nil, fmt.Errorf("failed to set project policy: %q", err)
+                return nil, err
         }
-        return setp, nil
+        grants := removedGrants(e)
+        return u.change(r, func(p *crm.Policy) (Diff, Diff) {
+                return Diff{}, addGrantsToPolicy(p, grants)
+        })
 }
 
-// RemoveMembersProject removes the given members.
-func (u *User) RemoveMembersProject(projectID string, disallowedUserEmails []string) (*crm.Policy, error) {
-        joined := strings.Replace(strings.Join(disallowedUserEmails, "|"), ".", `\.`, -1)
-        emailInRegex := regexp.MustCompile(fmt.Sprintf("^%s$", joined))
+// removedGrant is a member removed from a binding recorded in a journal entry.
+type removedGrant struct {
+        member    string
+        role      string
+        condition *crm.Expr
+}
 
-        resp, err := u.c.GetPolicyProject(projectID)
+// removedGrants returns the entry's removed members with the condition of the binding they
+// were removed from, in the order of the policy before the change.
+func removedGrants(e *journal.Entry) []removedGrant {
+        grants := []removedGrant{}
+        if e.Policy == nil {
+                return grants
+        }
+        for _, b := range e.Policy.Bindings {
+                for _, m := range b.Members {
+                        for _, r := range e.Removed[b.Role] {
+                                if r == m {
+                                        grants = append(grants, removedGrant{member: m, role: b.Role, condition: b.Condition})
+                                }
+                        }
+                }
+        }
+        return grants
+}
 
-        if err != nil {
-                return nil, fmt.Errorf("failed to get project policy: %q", err)
+// addGrantsToPolicy adds the members to the binding of their role and condition, the binding
+// is created if it no longer exists. The added members are returned by role.
+func addGrantsToPolicy(p *crm.Policy, grants []removedGrant) Diff {
+        added := Diff{}
+        for _, g := range grants {
+                var binding *crm.Binding
+                for _, b := range p.Bindings {
+                        if b.Role == g.role && sameCondition(b.Condition, g.condition) {
+                                binding = b
+                                break
+                        }
+                }
+                if binding == nil {
+                        binding = &crm.Binding{Role: g.role, Condition: g.condition}
+                        p.Bindings = append(p.Bindings, binding)
+                }
+                granted := false
+                for _, m := range binding.Members {
+                        if m == g.member {
+                                granted = true
+                                break
+                        }
+                }
+                if !granted {
+                        binding.Members = append(binding.Members, g.member)
+                        added.add(g.role, g.member)
+                }
         }
+        return added
+}
 
-        p := u.removeMembersFromPolicy(emailInRegex, resp)
-        setp, err := u.c.SetPolicyProject(projectID, p)
+// sameCondition returns true if both bindings are unconditional or have the same condition.
+func sameCondition(a, b *crm.Expr) bool {
+        if a == nil || b == nil {
+                return a == nil && b == nil
+        }
+        return a.Expression == b.Expression && a.Title == b.Title
+}
+
+// copyPolicy returns a deep copy of the policy.
+func copyPolicy(p *crm.Policy) (*crm.Policy, error) {
+        b, err := json.Marshal(p)
         if err != nil {
-                return nil, fmt.Errorf("failed to set project policy: %q", err)
+                return nil, fmt.Errorf("failed to copy policy: %q", err)
+        }
+        var c crm.Policy
+        if err := json.Unmarshal(b, &c); err != nil {
+                return nil, fmt.Errorf("failed to copy policy: %q", err)
         }
-        return setp, nil
+        return &c, nil
 }
 
-// removeMembersFromPolicy removes members that match the given regex.
-func (u *User) removeMembersFromPolicy(regex *regexp.Regexp, policy *crm.Policy) *crm.Policy {
+// removeMembersFromPolicy removes members that match in the binding, conditions are kept as read.
+//
+// Bindings left without members are dropped. The removed members are returned by role.
+func (u *User) removeMembersFromPolicy(match func(b *crm.Binding, member string) bool, policy *crm.Policy) (*crm.Policy, Diff) {
+        diff := Diff{}
+        bindings := []*crm.Binding{}
         for _, b := range policy.Bindings {
                 members := []string{}
                 for _, m := range b.Members {
-                        if !regex.MatchString(m) {
+                        if !match(b, m) {
                                 members = append(members, m)
+                                continue
                         }
+                        diff.add(b.Role, m)
                 }
                 b.Members = members
+                if len(members) > 0 {
+                        bindings = append(bindings, b)
+                }
+        }
+        if len(diff) > 0 {
+                policy.Bindings = bindings
+        }
+        return policy, diff
+}
+
+// add records the member as removed from or added to the role.
+func (d Diff) add(role, member string) {
+        for _, m := range d[role] {
+                if m == member {
+                        return
+                }
+        }
+        d[role] = append(d[role], member)
+        sort.Strings(d[role])
+}
+
+// policyVersion returns the version the policy must be written with.
+//
+// Policies with conditional bindings must be version 3, writing them with an older version
+// fails. Other policies keep the version they were read with.
+func policyVersion(p *crm.Policy) int64 {
+        for _, b := range p.Bindings {
+                if b.Condition != nil {
+                        return clients.PolicyVersion
+                }
         }
-        return policy
+        return p.Version
 }
 
 // RemoveEntityFromBucket removes ACL Entity in the bucket.
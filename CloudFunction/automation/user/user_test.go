@@ -19,7 +19,14 @@ package user
 
 import (
         "automation/clients"
+        "automation/journal"
+        "errors"
+        "fmt"
+        "io/ioutil"
+        "os"
+        "reflect"
         "testing"
+        "time"
 
         stg "cloud.google.com/go/storage"
         crm "google.golang.org/api/cloudresourcemanager/v1"
@@ -73,14 +80,14 @@ func TestRemoveDomainsProject(t *testing.T) {
                         name:              "remove all",
                         input:             createBindings([]string{"test-foo@gmail.com", "test-bob@gmail.com"}),
                         disallowedDomains: []string{"gmail.com"},
-                        expected:          createBindings([]string{}),
+                        expected:          []*crm.Binding{},
                 },
         }
         for _, tt := range tests {
                 t.Run(tt.name, func(t *testing.T) {
                         mock.AddGetPolicyFake(tt.input)
                         p, _ := r.RemoveDomainsProject(tt.name, tt.disallowedDomains)
-                        if diff, equal := messagediff.PrettyDiff(p.Bindings, tt.expected); !equal {
+                        if diff, equal := messagediff.PrettyDiff(p.Policy.Bindings, tt.expected); !equal {
                                 t.Errorf("%v failed, difference: %+v", tt.name, diff)
                         }
                 })
@@ -113,7 +120,7 @@ func TestRemoveMembersProject(t *testing.T) {
                         name:          "remove all",
                         input:         createBindings([]string{"user:test-foo@google.com", "user:test-bob@google.com"}),
                         removeMembers: []string{"user:test-foo@google.com", "user:test-bob@google.com"},
-                        expected:      createBindings([]string{}),
+                        expected:      []*crm.Binding{},
                 },
         }
 
@@ -124,7 +131,7 @@ func TestRemoveMembersProject(t *testing.T) {
                         if err != nil {
                                 t.Errorf("%v failed, err: %+v", tt.name, err)
                         }
-                        if diff, equal := messagediff.PrettyDiff(p.Bindings, tt.expected); !equal {
+                        if diff, equal := messagediff.PrettyDiff(p.Policy.Bindings, tt.expected); !equal {
                                 t.Errorf("%v failed, difference: %v", tt.name, diff)
                         }
 
@@ -132,6 +139,460 @@ func TestRemoveMembersProject(t *testing.T) {
         }
 }
 
+// TestRemoveMatchingProject verifies the members outside of the approved domains are removed.
+func TestRemoveMatchingProject(t *testing.T) {
+        mock := &clients.MockClients{}
+        mock.AddGetPolicyFake(createBindings([]string{
+                "allUsers",
+                "deleted:user:old@google.com?uid=123",
+                "group:team@google.com",
+                "user:bob@gmail.com",
+                "user:tim@eng.google.com",
+        }))
+        m, err := AllowDomains("google.com")
+        if err != nil {
+                t.Fatalf("failed, err: %+v", err)
+        }
+        res, err := NewUser(mock).RemoveMatchingProject("test-project", m.WithSubdomains())
+        if err != nil {
+                t.Fatalf("failed, err: %+v", err)
+        }
+        expected := createBindings([]string{"allUsers", "deleted:user:old@google.com?uid=123", "group:team@google.com", "user:tim@eng.google.com"})
+        if diff, equal := messagediff.PrettyDiff(res.Policy.Bindings, expected); !equal {
+                t.Errorf("failed, difference: %v", diff)
+        }
+}
+
+// TestRemoveGrantsProject verifies members are only removed from the roles they were granted.
+func TestRemoveGrantsProject(t *testing.T) {
+        mock := &clients.MockClients{}
+        r := NewUser(mock)
+        tests := []struct {
+                name     string
+                input    []*crm.Binding
+                grants   []Grant
+                expected []*crm.Binding
+        }{
+                {
+                        name: "remove member from granted role",
+                        input: []*crm.Binding{
+                                {Role: "roles/editor", Members: []string{"user:bob@gmail.com", "user:tim@google.com"}},
+                                {Role: "roles/viewer", Members: []string{"user:bob@gmail.com"}},
+                        },
+                        grants: []Grant{{Member: "user:bob@gmail.com", Role: "roles/editor"}},
+                        expected: []*crm.Binding{
+                                {Role: "roles/editor", Members: []string{"user:tim@google.com"}},
+                                {Role: "roles/viewer", Members: []string{"user:bob@gmail.com"}},
+                        },
+                },
+                {
+                        name: "remove member from every role",
+                        input: []*crm.Binding{
+                                {Role: "roles/editor", Members: []string{"user:bob@gmail.com", "user:tim@google.com"}},
+                                {Role: "roles/viewer", Members: []string{"user:bob@gmail.com"}},
+                        },
+                        grants: []Grant{{Member: "user:bob@gmail.com"}},
+                        expected: []*crm.Binding{
+                                {Role: "roles/editor", Members: []string{"user:tim@google.com"}},
+                        },
+                },
+                {
+                        name:     "leaves other members of the domain",
+                        input:    createBindings([]string{"user:bob@gmail.com", "user:existing@gmail.com"}),
+                        grants:   []Grant{{Member: "user:bob@gmail.com", Role: "roles/editor"}},
+                        expected: createBindings([]string{"user:existing@gmail.com"}),
+                },
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        mock.AddGetPolicyFake(tt.input)
+                        p, err := r.RemoveGrantsProject(tt.name, tt.grants)
+                        if err != nil {
+                                t.Errorf("%v failed, err: %+v", tt.name, err)
+                        }
+                        if diff, equal := messagediff.PrettyDiff(p.Policy.Bindings, tt.expected); !equal {
+                                t.Errorf("%v failed, difference: %v", tt.name, diff)
+                        }
+                })
+        }
+}
+
+// TestRemoveDomainsLevels verifies domains are removed from folder and organization policies.
+func TestRemoveDomainsLevels(t *testing.T) {
+        tests := []struct {
+                name     string
+                remove   func(u *User) (*Result, error)
+                resource string
+        }{
+                {
+                        name: "folder",
+                        remove: func(u *User) (*Result, error) {
+                                return u.RemoveDomainsFolder("760347836977", []string{"gmail.com"})
+                        },
+                        resource: "folders/760347836977",
+                },
+                {
+                        name: "organization",
+                        remove: func(u *User) (*Result, error) {
+                                return u.RemoveDomainsOrganization("154584661726", []string{"gmail.com"})
+                        },
+                        resource: "organizations/154584661726",
+                },
+                {
+                        name: "folder grants",
+                        remove: func(u *User) (*Result, error) {
+                                return u.RemoveGrantsFolder("760347836977", []Grant{{Member: "user:bob@gmail.com", Role: "roles/editor"}})
+                        },
+                        resource: "folders/760347836977",
+                },
+                {
+                        name: "organization grants",
+                        remove: func(u *User) (*Result, error) {
+                                return u.RemoveGrantsOrganization("154584661726", []Grant{{Member: "user:bob@gmail.com"}})
+                        },
+                        resource: "organizations/154584661726",
+                },
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        mock := &clients.MockClients{}
+                        mock.AddGetPolicyFake(createBindings([]string{"user:bob@gmail.com", "user:tim@google.com"}))
+                        if _, err := tt.remove(NewUser(mock)); err != nil {
+                                t.Fatalf("%v failed, err: %+v", tt.name, err)
+                        }
+                        p, ok := mock.SavedSetPolicies[tt.resource]
+                        if !ok {
+                                t.Fatalf("%v failed, policy not set for %q", tt.name, tt.resource)
+                        }
+                        if diff, equal := messagediff.PrettyDiff(p.Bindings, createBindings([]string{"user:tim@google.com"})); !equal {
+                                t.Errorf("%v failed, difference: %v", tt.name, diff)
+                        }
+                })
+        }
+}
+
+// TestRemoveConflicts verifies a change is applied again when the policy changes concurrently.
+func TestRemoveConflicts(t *testing.T) {
+        conflict := fmt.Errorf("failed to set project IAM policy: %w", clients.ErrConflict)
+        tests := []struct {
+                name          string
+                errs          []error
+                expectedError bool
+                attempts      int
+                sleeps        []time.Duration
+        }{
+                {
+                        name:     "no conflict",
+                        attempts: 1,
+                },
+                {
+                        name:     "retries conflicts",
+                        errs:     []error{conflict, conflict},
+                        attempts: 3,
+                        sleeps:   []time.Duration{initialBackoff, 2 * initialBackoff},
+                },
+                {
+                        name:          "gives up after max attempts",
+                        errs:          []error{conflict, conflict, conflict, conflict, conflict},
+                        expectedError: true,
+                        sleeps:        []time.Duration{initialBackoff, 2 * initialBackoff, 4 * initialBackoff, 8 * initialBackoff},
+                },
+                {
+                        name:          "doesn't retry other errors",
+                        errs:          []error{errors.New("permission denied")},
+                        expectedError: true,
+                },
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        mock := &clients.MockClients{}
+                        mock.AddGetPolicyFake(createBindings([]string{"user:bob@gmail.com", "user:tim@google.com"}))
+                        mock.AddSetPolicyErrorsFake(tt.errs...)
+                        r := NewUser(mock)
+                        var sleeps []time.Duration
+                        r.sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+
+                        res, err := r.RemoveDomainsProject("test-project", []string{"gmail.com"})
+                        if (err != nil) != tt.expectedError {
+                                t.Fatalf("%v failed, err: %+v", tt.name, err)
+                        }
+                        if !reflect.DeepEqual(sleeps, tt.sleeps) {
+                                t.Errorf("%v failed, sleeps got:%v want:%v", tt.name, sleeps, tt.sleeps)
+                        }
+                        if err != nil {
+                                return
+                        }
+                        if res.Attempts != tt.attempts {
+                                t.Errorf("%v failed, attempts got:%d want:%d", tt.name, res.Attempts, tt.attempts)
+                        }
+                        if diff, equal := messagediff.PrettyDiff(res.Policy.Bindings, createBindings([]string{"user:tim@google.com"})); !equal {
+                                t.Errorf("%v failed, difference: %v", tt.name, diff)
+                        }
+                })
+        }
+}
+
+// TestRemoveConditionalBindings verifies conditional bindings are kept and policies read with conditions are written as version 3.
+func TestRemoveConditionalBindings(t *testing.T) {
+        const expression = `request.time < timestamp("2020-01-01T00:00:00Z")`
+        tests := []struct {
+                name     string
+                remove   func(u *User) (*Result, error)
+                expected []*crm.Binding
+                version  int64
+        }{
+                {
+                        name: "remove member from role",
+                        remove: func(u *User) (*Result, error) {
+                                return u.RemoveGrantsProject("test-project", []Grant{{Member: "user:bob@gmail.com", Role: "roles/editor"}})
+                        },
+                        expected: createBindings([]string{"user:tim@google.com"}),
+                        version:  clients.PolicyVersion,
+                },
+                {
+                        name: "remove conditional grant only",
+                        remove: func(u *User) (*Result, error) {
+                                return u.RemoveGrantsProject("test-project", []Grant{{Member: "user:bob@gmail.com", Role: "roles/editor", Condition: expression}})
+                        },
+                        expected: createBindings([]string{"user:bob@gmail.com", "user:tim@google.com"}),
+                        version:  clients.PolicyVersion,
+                },
+                {
+                        name: "keeps conditional binding",
+                        remove: func(u *User) (*Result, error) {
+                                return u.RemoveGrantsProject("test-project", []Grant{{Member: "user:tim@google.com", Role: "roles/editor"}})
+                        },
+                        expected: createConditionalBindings([]string{"user:bob@gmail.com"}, []string{"user:bob@gmail.com"}),
+                        version:  clients.PolicyVersion,
+                },
+                {
+                        name: "condition doesn't match",
+                        remove: func(u *User) (*Result, error) {
+                                return u.RemoveGrantsProject("test-project", []Grant{{Member: "user:bob@gmail.com", Role: "roles/editor", Condition: "true"}})
+                        },
+                        expected: createConditionalBindings([]string{"user:bob@gmail.com", "user:tim@google.com"}, []string{"user:bob@gmail.com"}),
+                },
+                {
+                        name: "remove domains",
+                        remove: func(u *User) (*Result, error) {
+                                return u.RemoveDomainsProject("test-project", []string{"gmail.com"})
+                        },
+                        expected: createBindings([]string{"user:tim@google.com"}),
+                        version:  clients.PolicyVersion,
+                },
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        mock := &clients.MockClients{}
+                        mock.AddGetPolicyFake(createConditionalBindings([]string{"user:bob@gmail.com", "user:tim@google.com"}, []string{"user:bob@gmail.com"}))
+                        res, err := tt.remove(NewUser(mock))
+                        if err != nil {
+                                t.Fatalf("%v failed, err: %+v", tt.name, err)
+                        }
+                        if diff, equal := messagediff.PrettyDiff(res.Policy.Bindings, tt.expected); !equal {
+                                t.Errorf("%v failed, difference: %v", tt.name, diff)
+                        }
+                        if res.Policy.Version != tt.version {
+                                t.Errorf("%v failed, version got:%d want:%d", tt.name, res.Policy.Version, tt.version)
+                        }
+                })
+        }
+}
+
+// TestRemoveDiff verifies the removed members are reported by role and empty bindings are dropped.
+func TestRemoveDiff(t *testing.T) {
+        tests := []struct {
+                name     string
+                input    []*crm.Binding
+                expected []*crm.Binding
+                removed  Diff
+                diff     string
+        }{
+                {
+                        name: "members removed from roles",
+                        input: []*crm.Binding{
+                                {Role: "roles/editor", Members: []string{"user:bob@gmail.com", "user:tim@google.com"}},
+                                {Role: "roles/viewer", Members: []string{"user:carl@gmail.com", "user:bob@gmail.com"}},
+                        },
+                        expected: []*crm.Binding{
+                                {Role: "roles/editor", Members: []string{"user:tim@google.com"}},
+                        },
+                        removed: Diff{
+                                "roles/editor": {"user:bob@gmail.com"},
+                                "roles/viewer": {"user:bob@gmail.com", "user:carl@gmail.com"},
+                        },
+                        diff: "roles/editor: user:bob@gmail.com; roles/viewer: user:bob@gmail.com, user:carl@gmail.com",
+                },
+                {
+                        name: "nothing removed",
+                        input: []*crm.Binding{
+                                {Role: "roles/editor", Members: []string{"user:tim@google.com"}},
+                        },
+                        expected: []*crm.Binding{
+                                {Role: "roles/editor", Members: []string{"user:tim@google.com"}},
+                        },
+                        removed: Diff{},
+                        diff:    "",
+                },
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        mock := &clients.MockClients{}
+                        mock.AddGetPolicyFake(tt.input)
+                        res, err := NewUser(mock).RemoveDomainsProject("test-project", []string{"gmail.com"})
+                        if err != nil {
+                                t.Fatalf("%v failed, err: %+v", tt.name, err)
+                        }
+                        if diff, equal := messagediff.PrettyDiff(res.Policy.Bindings, tt.expected); !equal {
+                                t.Errorf("%v failed, difference: %v", tt.name, diff)
+                        }
+                        if !reflect.DeepEqual(res.Removed, tt.removed) {
+                                t.Errorf("%v failed, removed got:%v want:%v", tt.name, res.Removed, tt.removed)
+                        }
+                        if got := res.Removed.String(); got != tt.diff {
+                                t.Errorf("%v failed, diff got:%q want:%q", tt.name, got, tt.diff)
+                        }
+                        if len(tt.removed) == 0 && len(mock.SavedSetPolicies) != 0 {
+                                t.Errorf("%v failed, policy set without changes", tt.name)
+                        }
+                })
+        }
+}
+
+// TestPolicyVersion verifies policies without conditions keep the version they were read with.
+func TestPolicyVersion(t *testing.T) {
+        mock := &clients.MockClients{}
+        mock.AddGetPolicyFake(createBindings([]string{"user:bob@gmail.com"}))
+        res, err := NewUser(mock).RemoveDomainsProject("test-project", []string{"gmail.com"})
+        if err != nil {
+                t.Fatalf("failed, err: %+v", err)
+        }
+        if res.Policy.Version != 0 {
+                t.Errorf("failed, version got:%d want:0", res.Policy.Version)
+        }
+}
+
+func TestJournal(t *testing.T) {
+        dir, err := ioutil.TempDir("", "journal")
+        if err != nil {
+                t.Fatalf("failed to create directory: %q", err)
+        }
+        defer os.RemoveAll(dir)
+        conflict := fmt.Errorf("failed to set project IAM policy: %w", clients.ErrConflict)
+        now := time.Date(2019, 7, 16, 21, 0, 44, 0, time.UTC)
+
+        mock := &clients.MockClients{}
+        mock.AddGetPolicyFake(createBindings([]string{"user:bob@gmail.com", "user:tim@google.com"}))
+        mock.AddSetPolicyErrorsFake(conflict)
+        s := journal.NewFileStore(dir)
+        r := NewUser(mock).Journaled(s, "eppsoda4")
+        r.sleep = func(time.Duration) {}
+        r.now = func() time.Time { return now }
+
+        res, err := r.RemoveDomainsProject("test-project", []string{"gmail.com"})
+        if err != nil {
+                t.Fatalf("failed to remove domains: %q", err)
+        }
+        if want := journal.NewEntry("projects/test-project", now).ID; res.JournalID != want {
+                t.Errorf("failed journal id got:%q want:%q", res.JournalID, want)
+        }
+        entries, err := s.FindByInsertID("eppsoda4")
+        if err != nil || len(entries) != 1 {
+                t.Fatalf("failed to find entry got:%+v err:%v", entries, err)
+        }
+        e := entries[0]
+        if diff, equal := messagediff.PrettyDiff(e.Policy.Bindings, createBindings([]string{"user:bob@gmail.com", "user:tim@google.com"})); !equal {
+                t.Errorf("failed policy, difference: %v", diff)
+        }
+        if want := (Diff{"roles/editor": {"user:bob@gmail.com"}}); !reflect.DeepEqual(Diff(e.Removed), want) {
+                t.Errorf("failed removed got:%v want:%v", e.Removed, want)
+        }
+        if e.InsertID != "eppsoda4" || e.Resource != "projects/test-project" {
+                t.Errorf("failed got insertID:%q resource:%q", e.InsertID, e.Resource)
+        }
+}
+
+func TestRestore(t *testing.T) {
+        removed := map[string][]string{"roles/editor": {"user:bob@gmail.com"}}
+        tests := []struct {
+                name             string
+                entry            *journal.Entry
+                current          []*crm.Binding
+                expectedBindings []*crm.Binding
+                expectedAdded    Diff
+        }{
+                {
+                        name: "restores removed member and keeps later changes",
+                        entry: &journal.Entry{
+                                Resource: "projects/test-project",
+                                Policy:   &crm.Policy{Bindings: createBindings([]string{"user:bob@gmail.com", "user:tim@google.com"})},
+                                Removed:  removed,
+                        },
+                        current:          createBindings([]string{"user:tim@google.com", "user:ana@google.com"}),
+                        expectedBindings: createBindings([]string{"user:tim@google.com", "user:ana@google.com", "user:bob@gmail.com"}),
+                        expectedAdded:    Diff{"roles/editor": {"user:bob@gmail.com"}},
+                },
+                {
+                        name: "recreates dropped conditional binding",
+                        entry: &journal.Entry{
+                                Resource: "folders/760347836977",
+                                Policy:   &crm.Policy{Bindings: createConditionalBindings([]string{"user:tim@google.com"}, []string{"user:bob@gmail.com"})},
+                                Removed:  removed,
+                        },
+                        current:          createBindings([]string{"user:tim@google.com"}),
+                        expectedBindings: createConditionalBindings([]string{"user:tim@google.com"}, []string{"user:bob@gmail.com"}),
+                        expectedAdded:    Diff{"roles/editor": {"user:bob@gmail.com"}},
+                },
+                {
+                        name: "skips members granted since",
+                        entry: &journal.Entry{
+                                Resource: "organizations/1055058813388",
+                                Policy:   &crm.Policy{Bindings: createBindings([]string{"user:bob@gmail.com"})},
+                                Removed:  removed,
+                        },
+                        current:          createBindings([]string{"user:bob@gmail.com"}),
+                        expectedBindings: createBindings([]string{"user:bob@gmail.com"}),
+                        expectedAdded:    Diff{},
+                },
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        mock := &clients.MockClients{}
+                        mock.AddGetPolicyFake(tt.current)
+                        res, err := NewUser(mock).Restore(tt.entry)
+                        if err != nil {
+                                t.Fatalf("%v failed, err: %+v", tt.name, err)
+                        }
+                        if diff, equal := messagediff.PrettyDiff(res.Policy.Bindings, tt.expectedBindings); !equal {
+                                t.Errorf("%v failed, difference: %v", tt.name, diff)
+                        }
+                        if !reflect.DeepEqual(res.Added, tt.expectedAdded) {
+                                t.Errorf("%v failed, added got:%v want:%v", tt.name, res.Added, tt.expectedAdded)
+                        }
+                })
+        }
+        if _, err := NewUser(&clients.MockClients{}).Restore(&journal.Entry{Resource: "buckets/b"}); err == nil {
+                t.Errorf("failed to reject unsupported resource")
+        }
+}
+
+func createConditionalBindings(members []string, conditional []string) []*crm.Binding {
+        return []*crm.Binding{
+                {
+                        Role:    "roles/editor",
+                        Members: members,
+                },
+                {
+                        Role:    "roles/editor",
+                        Members: conditional,
+                        Condition: &crm.Expr{
+                                Title:      "expires",
+                                Expression: `request.time < timestamp("2020-01-01T00:00:00Z")`,
+                        },
+                },
+        }
+}
+
 func createBindings(members []string) []*crm.Binding {
         return []*crm.Binding{
                 {
@@ -173,8 +634,9 @@ func TestRemoveEntityFromBucket(t *testing.T) {
                         if err != tt.expectedError {
                                 t.Errorf("%v failed exp:%v got: %v", tt.name, tt.expectedError, err)
                         }
-                        if mock.SavedRemoveBucketUsers != tt.expectedSavedRemoveBucketEntity {
-                                t.Errorf("%v failed exp:%v got:%v", tt.name, tt.expectedSavedRemoveBucketEntity, mock.SavedRemoveBucketUsers)
+                        removed := mock.SavedRemovedBucketUsers
+                        if len(removed) == 0 || removed[len(removed)-1] != tt.expectedSavedRemoveBucketEntity {
+                                t.Errorf("%v failed exp:%v got:%v", tt.name, tt.expectedSavedRemoveBucketEntity, removed)
                         }
                 })
         }
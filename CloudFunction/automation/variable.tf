@@ -11,4 +11,9 @@ variable "threatfindingsProject" {
 variable "userFolder" {
   type        = "string"
   description = "Folder ID that external users are added."
+}
+
+variable "disallowedDomains" {
+  type        = "string"
+  description = "Comma separated domains whose members are revoked, for example \"gmail.com,test.com\"."
 }
\ No newline at end of file